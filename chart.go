@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// グラフの種類
+type ChartType string
+
+const (
+	ChartPie  ChartType = "pie"  // カテゴリ別の円グラフ
+	ChartBar  ChartType = "bar"  // カテゴリ別の棒グラフ
+	ChartLine ChartType = "line" // 支出の推移の折れ線グラフ
+)
+
+// グラフにする期間
+type ChartPeriod string
+
+const (
+	ChartMonth ChartPeriod = "month" // 今月
+	ChartYear  ChartPeriod = "year"  // 今年
+)
+
+const (
+	chartWidth    = 800
+	chartHeight   = 450
+	chartScale    = 2 // 図形は2倍の大きさで描いてから縮小する（縁を滑らかにするため）
+	chartFileName = "chart.png"
+)
+
+// 棒グラフ・折れ線グラフの描画範囲
+const (
+	chartPlotLeft   = 90
+	chartPlotRight  = 770
+	chartPlotTop    = 30
+	chartPlotBottom = 400
+)
+
+// グラフの色と、埋め込みの凡例で同じ色を表す絵文字
+type chartColor struct {
+	RGBA  color.RGBA
+	Emoji string
+}
+
+var chartPalette = []chartColor{
+	{color.RGBA{85, 172, 238, 255}, "🟦"},
+	{color.RGBA{221, 46, 68, 255}, "🟥"},
+	{color.RGBA{120, 177, 89, 255}, "🟩"},
+	{color.RGBA{253, 203, 88, 255}, "🟨"},
+	{color.RGBA{170, 142, 214, 255}, "🟪"},
+	{color.RGBA{244, 144, 12, 255}, "🟧"},
+	{color.RGBA{193, 105, 79, 255}, "🟫"},
+}
+
+// 表示しきれないカテゴリをまとめた「その他」の色
+var chartOtherColor = chartColor{color.RGBA{49, 55, 61, 255}, "⬛"}
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGridColor  = color.RGBA{225, 228, 232, 255}
+	chartAxisColor  = color.RGBA{150, 150, 150, 255}
+	chartTextColor  = color.RGBA{60, 60, 60, 255}
+)
+
+// グラフにする期間（from以上to未満）
+func chartRange(period ChartPeriod, now time.Time) (time.Time, time.Time) {
+	if period == ChartYear {
+		from := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+		return from, from.AddDate(1, 0, 0)
+	}
+	return currentMonthRange(now)
+}
+
+// 期間の表示（例: "2025年1月"、"2025年"）
+func chartPeriodLabel(lang Lang, period ChartPeriod, from time.Time) string {
+	if period == ChartYear {
+		return T(lang, "chart.period_year", from.Year())
+	}
+	return T(lang, "chart.period_month", from.Year(), from.Month())
+}
+
+// 円グラフ・棒グラフの1項目
+type chartSlice struct {
+	Name  string
+	Total Money
+	Color chartColor
+}
+
+// カテゴリ別の合計を金額の大きい順に返す（合計がマイナス・0のカテゴリは除く）
+// 色の数より多い場合は、少ないカテゴリを「その他」にまとめる
+func chartCategories(lang Lang, entries []LedgerEntry, base string) []chartSlice {
+	var slices []chartSlice
+	for _, total := range ledgerTotals(entries, base, func(e LedgerEntry) string { return e.Category }) {
+		if total.Total.Minor > 0 {
+			slices = append(slices, chartSlice{Name: summaryName(lang, total.Name), Total: total.Total})
+		}
+	}
+
+	if len(slices) > len(chartPalette) {
+		other := chartSlice{Name: T(lang, "chart.others"), Total: Money{Currency: base}, Color: chartOtherColor}
+		for _, slice := range slices[len(chartPalette)-1:] {
+			other.Total, _ = other.Total.Add(slice.Total)
+		}
+		slices = append(slices[:len(chartPalette)-1], other)
+	}
+	for i := range slices {
+		if i < len(chartPalette) && slices[i].Color.Emoji == "" {
+			slices[i].Color = chartPalette[i]
+		}
+	}
+	return slices
+}
+
+// 支出の推移（今月: 今日までの日ごとの累計、今年: 今月までの月ごとの合計）
+// labelsは期間全体の目盛り（日・月）で、valuesはnowまでの分だけ返す
+func chartTrend(entries []LedgerEntry, period ChartPeriod, now time.Time, base string) (values []float64, labels []string) {
+	from, to := chartRange(period, now)
+
+	slots, elapsed := 12, int(now.Month())
+	if period == ChartMonth {
+		slots, elapsed = to.AddDate(0, 0, -1).Day(), now.Day()
+	}
+	for i := 1; i <= slots; i++ {
+		labels = append(labels, fmt.Sprint(i))
+	}
+
+	totals := make([]Money, slots)
+	for i := range totals {
+		totals[i] = Money{Currency: base}
+	}
+	for _, entry := range entries {
+		date, ok := parseReceiptDate(entry.Date, now.Location())
+		if !ok || date.Before(from) || !date.Before(to) {
+			continue
+		}
+		index := int(date.Month()) - 1
+		if period == ChartMonth {
+			index = date.Day() - 1
+		}
+		totals[index], _ = totals[index].Add(NewMoney(entry.Amount, base))
+	}
+
+	cumulative := Money{Currency: base}
+	for _, total := range totals[:elapsed] {
+		if period == ChartMonth {
+			cumulative, _ = cumulative.Add(total)
+			total = cumulative
+		}
+		values = append(values, total.Float())
+	}
+	return values, labels
+}
+
+// グラフの画像と埋め込みを作成する
+// グラフにできる記録がない場合はLocalizedErrorを返す
+func BuildChart(lang Lang, chartType ChartType, period ChartPeriod, now time.Time, entries []LedgerEntry) (*discordgo.MessageEmbed, []byte, error) {
+	base := GetBaseCurrency()
+	from, _ := chartRange(period, now)
+	summary := BuildLedgerSummary(entries, base)
+	if summary.Count == 0 {
+		return nil, nil, NewLocalizedError("chart.no_data")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Color:       summaryColor,
+		Description: T(lang, "report.total", summary.Total.Format(lang), summary.Count),
+		Image:       &discordgo.MessageEmbedImage{URL: "attachment://" + chartFileName},
+	}
+
+	var img image.Image
+	if chartType == ChartLine {
+		values, labels := chartTrend(entries, period, now, base)
+		if period == ChartYear {
+			embed.Title = T(lang, "chart.title_monthly", chartPeriodLabel(lang, period, from))
+		} else {
+			embed.Title = T(lang, "chart.title_daily", chartPeriodLabel(lang, period, from))
+		}
+		img = renderLineChart(values, labels, base)
+	} else {
+		slices := chartCategories(lang, entries, base)
+		if len(slices) == 0 {
+			return nil, nil, NewLocalizedError("chart.no_data")
+		}
+		embed.Title = T(lang, "chart.title_category", chartPeriodLabel(lang, period, from))
+		embed.Description += "\n\n" + chartLegend(lang, slices)
+		if chartType == ChartBar {
+			img = renderBarChart(slices)
+		} else {
+			img = renderPieChart(slices)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, nil, NewLocalizedError("chart.render_failed", err)
+	}
+	return embed, buf.Bytes(), nil
+}
+
+// 埋め込みに表示する凡例（例: "🟦 **食費** 45,000円 (35.2%)"）
+func chartLegend(lang Lang, slices []chartSlice) string {
+	var sum int64
+	for _, slice := range slices {
+		sum += slice.Total.Minor
+	}
+	var lines []string
+	for _, slice := range slices {
+		lines = append(lines, fmt.Sprintf("%s **%s** %s (%.1f%%)", slice.Color.Emoji, slice.Name, slice.Total.Format(lang), float64(slice.Total.Minor)/float64(sum)*100))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 円グラフ（12時の位置から時計回りに金額の大きい順）
+func renderPieChart(slices []chartSlice) image.Image {
+	const cx, cy, radius = chartWidth / 2, chartHeight / 2, 190.0
+
+	var sum float64
+	for _, slice := range slices {
+		sum += slice.Total.Float()
+	}
+	// 各項目の終わりの位置（0〜1）
+	ends := make([]float64, len(slices))
+	cumulative := 0.0
+	for i, slice := range slices {
+		cumulative += slice.Total.Float() / sum
+		ends[i] = cumulative
+	}
+
+	canvas := newChartCanvas()
+	for py := 0; py < chartHeight*chartScale; py++ {
+		for px := 0; px < chartWidth*chartScale; px++ {
+			dx := (float64(px)+0.5)/chartScale - cx
+			dy := (float64(py)+0.5)/chartScale - cy
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			// 12時の位置を0として時計回りの割合
+			position := math.Mod(math.Atan2(dy, dx)+math.Pi/2+2*math.Pi, 2*math.Pi) / (2 * math.Pi)
+			for i, end := range ends {
+				if position < end || i == len(ends)-1 {
+					canvas.SetRGBA(px, py, slices[i].Color.RGBA)
+					break
+				}
+			}
+		}
+	}
+	img := finishChartCanvas(canvas)
+
+	// 5%以上の項目に割合を表示する
+	start := 0.0
+	for i, slice := range slices {
+		share := ends[i] - start
+		if share >= 0.05 {
+			angle := (start+share/2)*2*math.Pi - math.Pi/2
+			x := cx + math.Cos(angle)*radius*0.65
+			y := cy + math.Sin(angle)*radius*0.65
+			drawChartText(img, int(x), int(y)+4, fmt.Sprintf("%.0f%%", share*100), chartLabelColor(slice.Color.RGBA), alignCenter)
+		}
+		start = ends[i]
+	}
+	return img
+}
+
+// 棒グラフ（左から金額の大きい順）
+func renderBarChart(slices []chartSlice) image.Image {
+	values := make([]float64, len(slices))
+	for i, slice := range slices {
+		values[i] = slice.Total.Float()
+	}
+	plot := newChartPlot(values)
+	slot := float64(chartPlotRight-chartPlotLeft) / float64(len(slices))
+	barWidth := math.Min(slot*0.6, 80)
+
+	canvas := newChartCanvas()
+	plot.drawGrid(canvas)
+	for i, slice := range slices {
+		x := chartPlotLeft + slot*float64(i) + (slot-barWidth)/2
+		fillChartRect(canvas, x, plot.y(values[i]), x+barWidth, plot.y(0), slice.Color.RGBA)
+	}
+	plot.drawAxis(canvas)
+
+	img := finishChartCanvas(canvas)
+	plot.drawLabels(img, slices[0].Total.Currency)
+	for i, slice := range slices {
+		x := chartPlotLeft + slot*float64(i) + slot/2
+		drawChartText(img, int(x), int(plot.y(values[i]))-6, slice.Total.Number(), chartTextColor, alignCenter)
+	}
+	return img
+}
+
+// 折れ線グラフ（valuesはlabelsの先頭から順の値）
+func renderLineChart(values []float64, labels []string, base string) image.Image {
+	plot := newChartPlot(values)
+	x := func(i int) float64 {
+		return chartPlotLeft + float64(chartPlotRight-chartPlotLeft)*float64(i)/float64(len(labels)-1)
+	}
+	lineColor := chartPalette[0].RGBA
+
+	canvas := newChartCanvas()
+	plot.drawGrid(canvas)
+	plot.drawAxis(canvas)
+	for i := 1; i < len(values); i++ {
+		drawChartLine(canvas, x(i-1), plot.y(values[i-1]), x(i), plot.y(values[i]), 3, lineColor)
+	}
+	for i, value := range values {
+		fillChartCircle(canvas, x(i), plot.y(value), 4, lineColor)
+	}
+
+	img := finishChartCanvas(canvas)
+	plot.drawLabels(img, base)
+	// 日ごとの場合は1日・5日ごと・月末だけ目盛りを表示する（月末と重なる30日などは省く）
+	for i, label := range labels {
+		day := i + 1
+		if len(labels) > 12 && day != 1 && day != len(labels) && (day%5 != 0 || len(labels)-day < 3) {
+			continue
+		}
+		drawChartText(img, int(x(i)), chartPlotBottom+18, label, chartTextColor, alignCenter)
+	}
+	return img
+}
+
+// 棒グラフ・折れ線グラフの縦軸の範囲
+type chartPlot struct {
+	min, max float64
+}
+
+// 縦軸の目盛りの数
+const chartGridLines = 4
+
+func newChartPlot(values []float64) chartPlot {
+	plot := chartPlot{}
+	for _, value := range values {
+		plot.max = math.Max(plot.max, value)
+		plot.min = math.Min(plot.min, value)
+	}
+	plot.max = niceChartMax(plot.max)
+	if plot.min < 0 {
+		plot.min = -niceChartMax(-plot.min)
+	}
+	return plot
+}
+
+// 目盛りが切りのいい値になるように切り上げる（例: 8,300 → 10,000、230 → 250）
+func niceChartMax(value float64) float64 {
+	if value <= 0 {
+		return 1
+	}
+	magnitude := math.Pow10(int(math.Floor(math.Log10(value))))
+	for _, nice := range []float64{1, 2, 2.5, 5} {
+		if value <= nice*magnitude {
+			return nice * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// 金額の縦の位置
+func (p chartPlot) y(value float64) float64 {
+	return chartPlotBottom - (value-p.min)/(p.max-p.min)*(chartPlotBottom-chartPlotTop)
+}
+
+// 目盛りの金額
+func (p chartPlot) gridValue(i int) float64 {
+	return p.min + (p.max-p.min)*float64(i)/chartGridLines
+}
+
+// 横の目盛り線
+func (p chartPlot) drawGrid(canvas *image.RGBA) {
+	for i := 0; i <= chartGridLines; i++ {
+		y := p.y(p.gridValue(i))
+		fillChartRect(canvas, chartPlotLeft, y-0.5, chartPlotRight, y+0.5, chartGridColor)
+	}
+}
+
+// 縦軸と0の位置の横軸
+func (p chartPlot) drawAxis(canvas *image.RGBA) {
+	fillChartRect(canvas, chartPlotLeft-1, chartPlotTop, chartPlotLeft, chartPlotBottom, chartAxisColor)
+	fillChartRect(canvas, chartPlotLeft, p.y(0)-0.5, chartPlotRight, p.y(0)+0.5, chartAxisColor)
+}
+
+// 縦軸の目盛りの金額
+func (p chartPlot) drawLabels(img draw.Image, currency string) {
+	for i := 0; i <= chartGridLines; i++ {
+		value := p.gridValue(i)
+		drawChartText(img, chartPlotLeft-8, int(p.y(value))+4, NewMoney(value, currency).Number(), chartTextColor, alignRight)
+	}
+}
+
+// 背景を塗った2倍の大きさの画像
+func newChartCanvas() *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, chartWidth*chartScale, chartHeight*chartScale))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+	return canvas
+}
+
+// 2倍の大きさで描いた画像を縮小する（文字はこの後に描く）
+func finishChartCanvas(canvas *image.RGBA) *image.NRGBA {
+	return imaging.Resize(canvas, chartWidth, chartHeight, imaging.Lanczos)
+}
+
+// 長方形を塗る（座標は縮小後の位置）
+func fillChartRect(canvas *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	rect := image.Rect(int(math.Round(x0*chartScale)), int(math.Round(y0*chartScale)), int(math.Round(x1*chartScale)), int(math.Round(y1*chartScale)))
+	draw.Draw(canvas, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// 円を塗る（座標は縮小後の位置）
+func fillChartCircle(canvas *image.RGBA, cx, cy, radius float64, c color.RGBA) {
+	cx, cy, radius = cx*chartScale, cy*chartScale, radius*chartScale
+	for py := int(cy - radius); py <= int(cy+radius); py++ {
+		for px := int(cx - radius); px <= int(cx+radius); px++ {
+			dx, dy := float64(px)+0.5-cx, float64(py)+0.5-cy
+			if dx*dx+dy*dy <= radius*radius {
+				canvas.SetRGBA(px, py, c)
+			}
+		}
+	}
+}
+
+// 太さのある線を描く（座標は縮小後の位置）
+func drawChartLine(canvas *image.RGBA, x0, y0, x1, y1, width float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Hypot(x1-x0, y1-y0) * chartScale))
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / math.Max(float64(steps), 1)
+		fillChartCircle(canvas, x0+(x1-x0)*t, y0+(y1-y0)*t, width/2, c)
+	}
+}
+
+// 文字の揃え方
+type chartTextAlign int
+
+const (
+	alignLeft chartTextAlign = iota
+	alignCenter
+	alignRight
+)
+
+// 文字を描く（yはベースラインの位置、数字・英字のみ）
+func drawChartText(img draw.Image, x, y int, text string, c color.RGBA, align chartTextAlign) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	switch align {
+	case alignCenter:
+		x -= width / 2
+	case alignRight:
+		x -= width
+	}
+	drawer := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: face, Dot: fixed.P(x, y)}
+	drawer.DrawString(text)
+}
+
+// 塗りの色の上で読みやすい文字の色（明るい色には濃い文字、暗い色には白）
+func chartLabelColor(background color.RGBA) color.RGBA {
+	luminance := 0.299*float64(background.R) + 0.587*float64(background.G) + 0.114*float64(background.B)
+	if luminance > 160 {
+		return chartTextColor
+	}
+	return chartBackground
+}
+
+// 支出をグラフの画像で表示するコマンドの定義
+var chartCommand = &discordgo.ApplicationCommand{
+	Name:                     "chart",
+	Description:              "家計簿の支出をグラフの画像で表示します",
+	DescriptionLocalizations: englishLocalizations("Show spending as a chart image"),
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:                     discordgo.ApplicationCommandOptionString,
+			Name:                     "type",
+			Description:              "グラフの種類",
+			DescriptionLocalizations: *englishLocalizations("Chart type"),
+			Required:                 true,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "円グラフ（カテゴリ別）", NameLocalizations: *englishLocalizations("Pie (by category)"), Value: string(ChartPie)},
+				{Name: "棒グラフ（カテゴリ別）", NameLocalizations: *englishLocalizations("Bar (by category)"), Value: string(ChartBar)},
+				{Name: "折れ線グラフ（推移）", NameLocalizations: *englishLocalizations("Line (trend)"), Value: string(ChartLine)},
+			},
+		},
+		{
+			Type:                     discordgo.ApplicationCommandOptionString,
+			Name:                     "period",
+			Description:              "期間（省略した場合は今月）",
+			DescriptionLocalizations: *englishLocalizations("Period (defaults to this month)"),
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "今月", NameLocalizations: *englishLocalizations("This month"), Value: string(ChartMonth)},
+				{Name: "今年", NameLocalizations: *englishLocalizations("This year"), Value: string(ChartYear)},
+			},
+		},
+	},
+}
+
+func init() {
+	router.HandleCommand(chartCommand, handleChartCommand, RouteOptions{Defer: true})
+}
+
+// /chart が実行された時の処理
+func handleChartCommand(c *InteractionContext) {
+	lang := c.Lang()
+	chartType, period := ChartPie, ChartMonth
+	for _, option := range c.ApplicationCommandData().Options {
+		switch option.Name {
+		case "type":
+			chartType = ChartType(option.StringValue())
+		case "period":
+			period = ChartPeriod(option.StringValue())
+		}
+	}
+
+	now := time.Now().In(GetTimezone())
+	from, to := chartRange(period, now)
+	entries, err := GetLedgerEntries(from, to)
+	if err != nil {
+		c.Reply("❌ " + LocalizeError(lang, err))
+		return
+	}
+
+	embed, img, err := BuildChart(lang, chartType, period, now, entries)
+	if err != nil {
+		c.Reply(LocalizeError(lang, err))
+		return
+	}
+
+	log.Printf("📊 グラフ作成 - UserID: %s, %s (%s, %d件)", c.User().ID, chartType, period, len(entries))
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Files:  []*discordgo.File{{Name: chartFileName, ContentType: "image/png", Reader: bytes.NewReader(img)}},
+		},
+	})
+}
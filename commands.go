@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// スラッシュコマンドとテキストコマンド（!pingなど）で共通のコマンド
+type botCommand struct {
+	Command   *discordgo.ApplicationCommand
+	Aliases   []string // デフォルトのテキストコマンド（TEXT_COMMAND_ALIASESで上書きできる）
+	Ephemeral bool     // スラッシュコマンドの応答を実行したユーザーにだけ表示する
+	Defer     bool     // 時間のかかる処理のため、先に「考え中...」の応答を返す
+	Run       func(req commandRequest) string
+}
+
+// コマンドを実行したユーザーと場所
+type commandRequest struct {
+	Session   *discordgo.Session
+	User      *discordgo.User
+	ChannelID string
+	Lang      Lang
+}
+
+// 英語の説明（en-US / en-GB 共通）
+func englishLocalizations(text string) *map[discordgo.Locale]string {
+	return &map[discordgo.Locale]string{
+		discordgo.EnglishUS: text,
+		discordgo.EnglishGB: text,
+	}
+}
+
+var botCommands = []*botCommand{
+	{
+		Command: &discordgo.ApplicationCommand{
+			Name:                     "ping",
+			Description:              "Botの応答を確認します",
+			DescriptionLocalizations: englishLocalizations("Check that the bot is responding"),
+		},
+		Aliases: []string{"!ping"},
+		Run:     runPingCommand,
+	},
+	{
+		Command: &discordgo.ApplicationCommand{
+			Name:                     "whoami",
+			Description:              "自分のDiscordユーザー情報とPayerを表示します",
+			DescriptionLocalizations: englishLocalizations("Show your Discord user info and payer"),
+		},
+		Aliases:   []string{"!whoami"},
+		Ephemeral: true,
+		Run:       runWhoamiCommand,
+	},
+	{
+		Command: &discordgo.ApplicationCommand{
+			Name: "amount",
+			NameLocalizations: &map[discordgo.Locale]string{
+				discordgo.Japanese: "いくら",
+			},
+			Description:              "今月の家計簿の記録を表示します",
+			DescriptionLocalizations: englishLocalizations("Show this month's household budget totals"),
+		},
+		Aliases: []string{"いくら"},
+		Defer:   true,
+		Run:     runAmountCommand,
+	},
+}
+
+func init() {
+	for _, cmd := range botCommands {
+		cmd := cmd
+		router.HandleCommand(cmd.Command, func(c *InteractionContext) {
+			content := cmd.Run(commandRequest{Session: c.Session, User: c.User(), ChannelID: c.ChannelID, Lang: c.Lang()})
+			if cmd.Ephemeral {
+				c.ReplyEphemeral(content)
+			} else {
+				c.Reply(content)
+			}
+		}, RouteOptions{Defer: cmd.Defer, Ephemeral: cmd.Ephemeral})
+	}
+}
+
+// テキストコマンドの比較用に文字列を正規化する
+// 前後の空白（全角スペースを含む）を除き、全角英数字・記号を半角に、英字を小文字にする
+func normalizeCommandText(s string) string {
+	s = strings.TrimFunc(s, unicode.IsSpace)
+	return strings.Map(func(r rune) rune {
+		// 全角英数字・記号（！〜～）を半角に変換
+		if r >= '！' && r <= '～' {
+			r = r - '！' + '!'
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// テキストコマンドの別名を読み込む
+// TEXT_COMMAND_ALIASES="ping=!ping,!ピン;amount=いくら,いくら？" のように指定したコマンドだけ上書きする
+// 空にする（例: "amount="）とそのコマンドはテキストで呼び出せなくなる
+func LoadTextAliases() map[string]*botCommand {
+	overrides := map[string][]string{}
+	for _, entry := range strings.Split(os.Getenv("TEXT_COMMAND_ALIASES"), ";") {
+		name, aliases, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		var list []string
+		for _, alias := range strings.Split(aliases, ",") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				list = append(list, alias)
+			}
+		}
+		overrides[strings.TrimSpace(name)] = list
+	}
+
+	aliases := map[string]*botCommand{}
+	for _, cmd := range botCommands {
+		list := cmd.Aliases
+		if override, ok := overrides[cmd.Command.Name]; ok {
+			list = override
+		}
+		for _, alias := range list {
+			aliases[normalizeCommandText(alias)] = cmd
+		}
+	}
+	return aliases
+}
+
+// テキストコマンドを処理する（コマンドだった場合はtrueを返す）
+func handleTextCommand(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	cmd, ok := LoadTextAliases()[normalizeCommandText(m.Content)]
+	if !ok {
+		return false
+	}
+
+	log.Printf("💬 テキストコマンド実行: %s (/%s) - UserID: %s", m.Content, cmd.Command.Name, m.Author.ID)
+	content := cmd.Run(commandRequest{Session: s, User: m.Author, ChannelID: m.ChannelID, Lang: ResolveLang(m.GuildID, "")})
+	if _, err := s.ChannelMessageSend(m.ChannelID, content); err != nil {
+		log.Printf("❌ メッセージ送信失敗: %v", err)
+	}
+	return true
+}
+
+// ping: Botの応答確認
+func runPingCommand(req commandRequest) string {
+	return "Pong!"
+}
+
+// whoami: ユーザー情報と現在のPayer判定結果を表示する
+func runWhoamiCommand(req commandRequest) string {
+	currentPayer := getPayerFromDiscordUser(req.User.ID, req.User.Username)
+
+	// ログにも出力
+	log.Printf("📋 whoami実行 - UserID: %s, Username: %s, Payer: %s", req.User.ID, req.User.Username, currentPayer)
+
+	return T(req.Lang, "whoami.body", req.User.ID, req.User.Username, req.User.GlobalName, currentPayer)
+}
+
+// いくら: GASから今月の記録を取得して表示する
+func runAmountCommand(req commandRequest) string {
+	result, err := GetLatestAmount()
+	if err != nil {
+		return "❌ " + LocalizeError(req.Lang, err)
+	}
+
+	// Discordメッセージを作成（金額にカンマを追加）
+	var message strings.Builder
+	message.WriteString(T(req.Lang, "amount.title", result.CurrentMonth) + "\n```\n")
+	for _, item := range result.Data {
+		// 金額を言語に合わせた表記にする
+		message.WriteString(FormatReportLine(req.Lang, item) + "\n")
+	}
+	message.WriteString("```")
+
+	// 今月の家計簿に返品・返金（マイナスの記録）があれば、差し引き後の合計も表示する
+	from, to := currentMonthRange(time.Now().In(GetTimezone()))
+	if entries, err := GetLedgerEntries(from, to); err != nil {
+		log.Printf("⚠️  返品・返金の集計用の記録を取得できませんでした: %v", err)
+	} else if summary := BuildLedgerSummary(entries, GetBaseCurrency()); summary.RefundCount > 0 {
+		message.WriteString("\n" + T(req.Lang, "amount.refunds", summary.Refunds.Format(req.Lang), summary.RefundCount, summary.Total.Format(req.Lang)))
+	}
+
+	log.Printf("💰 いくらコマンド実行成功 - UserID: %s", req.User.ID)
+	return message.String()
+}
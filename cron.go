@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron形式（"分 時 日 月 曜日"）のスケジュール
+// 各項目は "*"・"5"・"1-5"・"*/15"・"1,15" とその組み合わせに対応する（曜日は0と7が日曜）
+type CronSchedule struct {
+	spec     string
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// 日と曜日の両方を指定した場合は、どちらかに一致すれば実行する（cronと同じ）
+	daysAny     bool
+	weekdaysAny bool
+}
+
+// cron形式の文字列を読み取る
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cronの項目数が不正です（分 時 日 月 曜日 の5項目）: %q", spec)
+	}
+
+	schedule := &CronSchedule{spec: spec}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cronの分が不正です: %v", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cronの時が不正です: %v", err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cronの日が不正です: %v", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cronの月が不正です: %v", err)
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cronの曜日が不正です: %v", err)
+	}
+	// 7の日曜は0にまとめる
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	schedule.daysAny = strings.HasPrefix(fields[2], "*")
+	schedule.weekdaysAny = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// cronの1項目を、実行する値のビット集合にする
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("間隔が不正です: %q", part)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			startText, endText, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startText); err != nil {
+				return 0, fmt.Errorf("値が不正です: %q", part)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endText); err != nil {
+					return 0, fmt.Errorf("値が不正です: %q", part)
+				}
+			} else if hasStep {
+				// "5/15" は5から最大値まで15ごと
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("範囲外です（%d〜%d）: %q", min, max, part)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (cs *CronSchedule) String() string {
+	return cs.spec
+}
+
+// 日付が実行する日かどうか
+func (cs *CronSchedule) matchDay(t time.Time) bool {
+	if cs.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayMatch := cs.days&(1<<uint(t.Day())) != 0
+	weekdayMatch := cs.weekdays&(1<<uint(t.Weekday())) != 0
+	if cs.daysAny || cs.weekdaysAny {
+		return dayMatch && weekdayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+// afterより後の最初の実行日時（afterのタイムゾーンで判定する）
+// 4年以内に実行日時がない場合（2月30日など）はゼロ値を返す
+func (cs *CronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(4, 0, 0)
+
+	for t.Before(limit) {
+		if !cs.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if cs.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if cs.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"net/textproto"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -31,8 +30,9 @@ type DifyWorkflowResponse struct {
 	Data          map[string]interface{} `json:"data"`
 }
 
-// 画像をDifyにアップロードする関数
-func UploadImageToDify(filename string) (string, error) {
+// 画像（メモリ上のデータ）をDifyにアップロードする関数
+// filenameはアップロード時のファイル名として使用する
+func UploadImageToDify(data []byte, filename string) (string, error) {
 	log.Printf("Difyへのアップロード開始: %s", filename)
 
 	difyToken := os.Getenv("DIFY_API_KEY")
@@ -44,7 +44,7 @@ func UploadImageToDify(filename string) (string, error) {
 
 	if difyToken == "" {
 		log.Printf("❌ DIFY_API_KEYが未設定")
-		return "", fmt.Errorf("DIFY_API_KEYが設定されていません")
+		return "", NewLocalizedError("dify.api_key_missing")
 	}
 
 	// 空白をトリミング
@@ -55,36 +55,29 @@ func UploadImageToDify(filename string) (string, error) {
 		log.Printf("エンドポイント未設定、デフォルト使用: %s", difyEndpoint)
 	}
 
-	// ファイルを開く
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Printf("❌ ファイルオープン失敗: %v", err)
-		return "", fmt.Errorf("ファイルオープンエラー: %v", err)
-	}
-	defer file.Close()
-
 	// multipart/form-dataを作成
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
-	// ファイル拡張子からMIME typeを判定
-	mimeType := GetMimeType(filename)
+	// ファイルの中身からMIME typeを判定し、拡張子もそれに合わせる
+	mimeType := DetectMimeType(data)
+	uploadName := FilenameForMimeType(SanitizeFilename(filename), mimeType)
 
 	// Content-Dispositionヘッダーを手動で作成
 	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filepath.Base(filename)))
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, uploadName))
 	h.Set("Content-Type", mimeType)
 
 	part, err := writer.CreatePart(h)
 	if err != nil {
 		log.Printf("❌ フォームパート作成失敗: %v", err)
-		return "", fmt.Errorf("フォームパート作成エラー: %v", err)
+		return "", NewLocalizedError("dify.form_error", err)
 	}
 
-	_, err = io.Copy(part, file)
+	_, err = part.Write(data)
 	if err != nil {
 		log.Printf("❌ ファイルコピー失敗: %v", err)
-		return "", fmt.Errorf("ファイルコピーエラー: %v", err)
+		return "", NewLocalizedError("dify.copy_error", err)
 	}
 
 	// userフィールドを追加
@@ -93,7 +86,7 @@ func UploadImageToDify(filename string) (string, error) {
 	err = writer.Close()
 	if err != nil {
 		log.Printf("❌ writer close失敗: %v", err)
-		return "", fmt.Errorf("writer closeエラー: %v", err)
+		return "", NewLocalizedError("dify.writer_error", err)
 	}
 
 	// リクエストを作成
@@ -101,7 +94,7 @@ func UploadImageToDify(filename string) (string, error) {
 	req, err := http.NewRequest("POST", uploadURL, body)
 	if err != nil {
 		log.Printf("❌ リクエスト作成失敗: %v", err)
-		return "", fmt.Errorf("リクエスト作成エラー: %v", err)
+		return "", NewLocalizedError("dify.request_error", err)
 	}
 
 	// ヘッダーを設定
@@ -116,7 +109,7 @@ func UploadImageToDify(filename string) (string, error) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("❌ リクエスト送信失敗: %v", err)
-		return "", fmt.Errorf("リクエスト送信エラー: %v", err)
+		return "", NewLocalizedError("dify.send_error", err)
 	}
 	defer resp.Body.Close()
 
@@ -124,7 +117,7 @@ func UploadImageToDify(filename string) (string, error) {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("❌ レスポンス読み取り失敗: %v", err)
-		return "", fmt.Errorf("レスポンス読み取りエラー: %v", err)
+		return "", NewLocalizedError("dify.read_error", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
@@ -135,7 +128,7 @@ func UploadImageToDify(filename string) (string, error) {
 			log.Printf("認証エラー: API Keyの設定を確認してください")
 		}
 
-		return "", fmt.Errorf("アップロード失敗 (ステータス: %d): %s", resp.StatusCode, string(respBody))
+		return "", NewLocalizedError("dify.upload_failed", resp.StatusCode, string(respBody))
 	}
 
 	// JSONをパース
@@ -143,16 +136,17 @@ func UploadImageToDify(filename string) (string, error) {
 	err = json.Unmarshal(respBody, &uploadResp)
 	if err != nil {
 		log.Printf("❌ JSONパース失敗: %v", err)
-		return "", fmt.Errorf("JSONパースエラー: %v, レスポンス: %s", err, string(respBody))
+		return "", NewLocalizedError("dify.json_error", err, string(respBody))
 	}
 
 	// log.Printf("✅ アップロード成功 - ID: %s", uploadResp.ID)
 	return uploadResp.ID, nil
 }
 
-// DifyのワークフローまたはチャットBotに画像を送信して処理を実行する関数
-func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
-	log.Printf("🚀 Difyワークフロー実行開始 - UserID: %s, Username: %s, FileID: %s", userID, username, fileID)
+// Difyのワークフローにファイル（画像・PDFなど）を送信して処理を実行する関数
+// fileTypeにはDifyのファイル種別（"image" や "document"）を指定する
+func RunDifyWorkflowWithFiles(fileIDs []string, fileType, userID, username string) (string, error) {
+	log.Printf("🚀 Difyワークフロー実行開始 - UserID: %s, Username: %s, FileIDs: %v, Type: %s", userID, username, fileIDs, fileType)
 
 	difyToken := os.Getenv("DIFY_API_KEY")
 	// DIFY_ENDPOINTとDIFY_API_URLの両方をサポート（後方互換性）
@@ -168,7 +162,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 
 	if difyToken == "" {
 		log.Printf("❌ DIFY_API_KEYが未設定")
-		return "", fmt.Errorf("DIFY_API_KEYが設定されていません")
+		return "", NewLocalizedError("dify.api_key_missing")
 	}
 
 	// 空白をトリミング
@@ -181,10 +175,13 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 	// ワークフローを実行する場合
 	// inputs に画像のfile_idを含める
 	// Difyワークフローが期待する形式で画像データを作成
-	imageData := map[string]interface{}{
-		"transfer_method": "local_file",
-		"upload_file_id":  fileID,
-		"type":            "image",
+	var fileInputs []interface{}
+	for _, fileID := range fileIDs {
+		fileInputs = append(fileInputs, map[string]interface{}{
+			"transfer_method": "local_file",
+			"upload_file_id":  fileID,
+			"type":            fileType,
+		})
 	}
 
 	// DiscordユーザーからPayerを判定
@@ -193,8 +190,8 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 
 	requestBody := map[string]interface{}{
 		"inputs": map[string]interface{}{
-			difyInputName: []interface{}{imageData}, // 配列形式で送信
-			"payer":       payer,                    // "Y" または "S" を直接送信
+			difyInputName: fileInputs, // 配列形式で送信
+			"payer":       payer,      // "Y" または "S" を直接送信
 		},
 		"response_mode": "blocking", // または "streaming"
 		"user":          "discord-bot-user",
@@ -203,7 +200,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		log.Printf("❌ JSONマーシャル失敗: %v", err)
-		return "", fmt.Errorf("JSONマーシャルエラー: %v", err)
+		return "", NewLocalizedError("dify.marshal_error", err)
 	}
 
 	// デバッグ用: 送信するJSONをログ出力
@@ -221,7 +218,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Printf("❌ リクエスト作成失敗: %v", err)
-		return "", fmt.Errorf("リクエスト作成エラー: %v", err)
+		return "", NewLocalizedError("dify.request_error", err)
 	}
 
 	authHeader := "Bearer " + difyToken
@@ -234,7 +231,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("❌ リクエスト送信失敗: %v", err)
-		return "", fmt.Errorf("リクエスト送信エラー: %v", err)
+		return "", NewLocalizedError("dify.send_error", err)
 	}
 	defer resp.Body.Close()
 
@@ -242,7 +239,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("❌ レスポンス読み取り失敗: %v", err)
-		return "", fmt.Errorf("レスポンス読み取りエラー: %v", err)
+		return "", NewLocalizedError("dify.read_error", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
@@ -259,7 +256,7 @@ func RunDifyWorkflowWithImage(fileID, userID, username string) (string, error) {
 			log.Printf("⚠️  Difyサーバー内部エラー: ワークフロー内のロジックやプラグインを確認してください")
 		}
 
-		return "", fmt.Errorf("ワークフロー実行失敗 (ステータス: %d): %s", resp.StatusCode, string(respBody))
+		return "", NewLocalizedError("dify.workflow_failed", resp.StatusCode, string(respBody))
 	}
 
 	log.Printf("✅ ワークフロー実行成功")
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// GAS（家計簿のスプレッドシート）の get_latest_amount のレスポンス
+type LatestAmountResponse struct {
+	Status       string   `json:"status"`
+	Count        int      `json:"count"`
+	CurrentMonth string   `json:"currentMonth"`
+	Data         []string `json:"data"` // "項目：金額" の形式
+}
+
+// GASから今月の記録を取得する
+// 返すエラーはそのままユーザーに表示できるメッセージ
+func GetLatestAmount() (*LatestAmountResponse, error) {
+	// gasのurlを叩いて情報を取得する。リクエストボディにパラメーターとしてaction:"get_latest_amount"を含める
+	url := os.Getenv("GAS_ENDPOINT")
+	data := `{"action":"get_latest_amount"}`
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(data))
+	if err != nil {
+		log.Printf("❌ POSTリクエストの送信中にエラーが発生しました: %v", err)
+		return nil, NewLocalizedError("gas.fetch_failed")
+	}
+	defer resp.Body.Close() // レスポンスボディを必ずクローズする
+
+	// レスポンスボディを読み取る
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("❌ レスポンス読み取り失敗: %v", err)
+		return nil, NewLocalizedError("gas.read_failed")
+	}
+
+	// JSONをパース
+	var result LatestAmountResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		log.Printf("❌ JSONパース失敗: %v", err)
+		return nil, NewLocalizedError("gas.parse_failed")
+	}
+
+	return &result, nil
+}
+
+// レシートを家計簿に記録する方法（LEDGER_WRITER）
+const (
+	LedgerWriterDify = "dify" // Difyのワークフローがスプレッドシートに記録する（デフォルト）
+	LedgerWriterBot  = "bot"  // 換算・返品・返金を反映してから、BotがGASの append_entry で記録する
+)
+
+// 環境変数からレシートを家計簿に記録する方法を取得する
+// Botで記録する場合は、ワークフロー側でスプレッドシートに書き込まないようにすること（二重に記録されるため）
+func GetLedgerWriter() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("LEDGER_WRITER"))) == LedgerWriterBot {
+		return LedgerWriterBot
+	}
+	return LedgerWriterDify
+}
+
+// 家計簿の1件の記録（Difyのワークフローが返す insertedData と同じ項目）
+type LedgerEntry struct {
+	Date           string  `json:"date"` // 2006/01/02
+	Store          string  `json:"store"`
+	Item           string  `json:"item"`
+	Category       string  `json:"category"`
+	Amount         float64 `json:"amount"` // 基準通貨での金額（返品・返金はマイナス）
+	Payer          string  `json:"payer"`
+	Currency       string  `json:"currency,omitempty"`        // 外貨のレシートの元の通貨
+	OriginalAmount float64 `json:"original_amount,omitempty"` // 外貨のレシートの元の金額
+	Rate           float64 `json:"rate,omitempty"`            // 換算に使ったレート
+	ID             string  `json:"id,omitempty"`              // レシートの処理履歴のジョブID
+	Refund         bool    `json:"refund,omitempty"`          // 返品・返金（金額はマイナス）
+	RefundOf       string  `json:"refund_of,omitempty"`       // 返品・返金の元の記録のID
+	Cancels        string  `json:"cancels,omitempty"`         // 打ち消す記録のID（購入として記録した返品・返金のレシート）
+}
+
+// GASの append_entry のレスポンス
+type appendEntryResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// GASの家計簿に記録を追加する（action: "append_entry"）
+// 返すエラーはそのままユーザーに表示できるメッセージ
+func AppendLedgerEntry(entry LedgerEntry) error {
+	return postLedgerEntry("append_entry", entry, "gas.append_failed")
+}
+
+// 家計簿の記録をGASに送信する
+func postLedgerEntry(action string, entry LedgerEntry, failedKey string) error {
+	url := os.Getenv("GAS_ENDPOINT")
+	data, err := json.Marshal(struct {
+		Action string `json:"action"`
+		LedgerEntry
+	}{Action: action, LedgerEntry: entry})
+	if err != nil {
+		return NewLocalizedError(failedKey, err)
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("❌ POSTリクエストの送信中にエラーが発生しました: %v", err)
+		return NewLocalizedError("gas.fetch_failed")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("❌ レスポンス読み取り失敗: %v", err)
+		return NewLocalizedError("gas.read_failed")
+	}
+
+	var result appendEntryResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		log.Printf("❌ JSONパース失敗: %v (%s)", err, TruncateString(string(respBody), 200))
+		return NewLocalizedError("gas.parse_failed")
+	}
+	if result.Status != "success" {
+		return NewLocalizedError(failedKey, result.Message)
+	}
+	return nil
+}
+
+// GASの get_entries のレスポンス
+type ledgerEntriesResponse struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message"`
+	Count   int           `json:"count"`
+	Data    []LedgerEntry `json:"data"`
+}
+
+// GASの家計簿から期間（from以上to未満の日付）の記録を取得する（action: "get_entries"）
+// 返すエラーはそのままユーザーに表示できるメッセージ
+func GetLedgerEntries(from, to time.Time) ([]LedgerEntry, error) {
+	url := os.Getenv("GAS_ENDPOINT")
+	// GASには両端を含む日付で渡す
+	data, err := json.Marshal(map[string]string{
+		"action": "get_entries",
+		"from":   from.Format("2006/01/02"),
+		"to":     to.AddDate(0, 0, -1).Format("2006/01/02"),
+	})
+	if err != nil {
+		return nil, NewLocalizedError("gas.parse_failed")
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("❌ POSTリクエストの送信中にエラーが発生しました: %v", err)
+		return nil, NewLocalizedError("gas.fetch_failed")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("❌ レスポンス読み取り失敗: %v", err)
+		return nil, NewLocalizedError("gas.read_failed")
+	}
+
+	var result ledgerEntriesResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		log.Printf("❌ JSONパース失敗: %v (%s)", err, TruncateString(string(respBody), 200))
+		return nil, NewLocalizedError("gas.parse_failed")
+	}
+	if result.Status != "" && result.Status != "success" {
+		log.Printf("❌ 家計簿の記録の取得失敗: %s", result.Message)
+		return nil, NewLocalizedError("gas.fetch_failed")
+	}
+	return result.Data, nil
+}
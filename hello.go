@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// /hello のボタンのCustomIDの接頭辞
+const helloCustomIDPrefix = "fd_"
+
+var helloCommand = &discordgo.ApplicationCommand{
+	Name:                     "hello",
+	Description:              "挨拶を返します",
+	DescriptionLocalizations: englishLocalizations("Replies with a greeting"),
+}
+
+func init() {
+	router.HandleCommand(helloCommand, handleHelloCommand, RouteOptions{})
+	router.HandleComponent(helloCustomIDPrefix, handleHelloButton, RouteOptions{})
+}
+
+// /hello が実行された時の処理（挨拶とボタンを1つの応答で返す）
+func handleHelloCommand(c *InteractionContext) {
+	lang := c.Lang()
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: T(lang, "hello.greeting"),
+			// Buttons and other components are specified in Components field.
+			Components: []discordgo.MessageComponent{
+				// ActionRow is a container of all buttons within the same row.
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    T(lang, "hello.yes"),
+							Style:    discordgo.SuccessButton,
+							CustomID: helloCustomIDPrefix + "yes",
+						},
+						discordgo.Button{
+							Label:    T(lang, "hello.no"),
+							Style:    discordgo.DangerButton,
+							CustomID: helloCustomIDPrefix + "no",
+						},
+						discordgo.Button{
+							Label: T(lang, "hello.dont_know"),
+							Style: discordgo.LinkButton,
+							// Link buttons don't require CustomID and do not trigger the gateway/HTTP event
+							URL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+							Emoji: &discordgo.ComponentEmoji{
+								Name: "🤷",
+							},
+						},
+					},
+				},
+				// The message may have multiple actions rows.
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label: "Discord Developers server",
+							Style: discordgo.LinkButton,
+							URL:   "https://discord.gg/discord-developers",
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// /hello のYes/Noボタンが押された時の処理
+func handleHelloButton(c *InteractionContext) {
+	if c.MessageComponentData().CustomID == helloCustomIDPrefix+"yes" {
+		c.ReplyEphemeral(T(c.Lang(), "hello.answer_yes"))
+		return
+	}
+	c.ReplyEphemeral(T(c.Lang(), "hello.answer_no"))
+}
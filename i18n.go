@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Botのメッセージの言語
+type Lang string
+
+const (
+	LangJapanese Lang = "ja"
+	LangEnglish  Lang = "en"
+)
+
+// 言語名（"ja", "en", "en-US" など）を対応している言語に変換する
+func parseLang(s string) (Lang, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "ja" || strings.HasPrefix(s, "ja-"):
+		return LangJapanese, true
+	case s == "en" || strings.HasPrefix(s, "en-"):
+		return LangEnglish, true
+	}
+	return "", false
+}
+
+// デフォルトの言語を取得する（BOT_LANGUAGE、デフォルト: ja）
+func GetDefaultLang() Lang {
+	if lang, ok := parseLang(os.Getenv("BOT_LANGUAGE")); ok {
+		return lang
+	}
+	return LangJapanese
+}
+
+// サーバーごとの言語設定を取得する（GUILD_LANGUAGES="サーバーID:en,サーバーID:ja"）
+func GetGuildLangs() map[string]Lang {
+	langs := map[string]Lang{}
+	for _, entry := range strings.Split(os.Getenv("GUILD_LANGUAGES"), ",") {
+		guildID, name, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		if lang, ok := parseLang(name); ok {
+			langs[strings.TrimSpace(guildID)] = lang
+		}
+	}
+	return langs
+}
+
+// メッセージの言語を決める
+// ユーザーのDiscordの言語設定（インタラクションのみ分かる）→ サーバーの言語設定 → デフォルトの順に使う
+func ResolveLang(guildID string, locale discordgo.Locale) Lang {
+	if lang, ok := parseLang(string(locale)); ok {
+		return lang
+	}
+	if lang, ok := GetGuildLangs()[guildID]; ok {
+		return lang
+	}
+	return GetDefaultLang()
+}
+
+// メッセージカタログから指定した言語のメッセージを取得する
+// 引数にエラーを渡した場合は、そのエラーも同じ言語で表示する
+func T(lang Lang, key string, args ...interface{}) string {
+	translations, ok := messageCatalog[key]
+	if !ok {
+		return key
+	}
+	format, ok := translations[lang]
+	if !ok {
+		format = translations[LangJapanese]
+	}
+	if len(args) == 0 {
+		return format
+	}
+
+	localized := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			localized[i] = LocalizeError(lang, err)
+		} else {
+			localized[i] = arg
+		}
+	}
+	return fmt.Sprintf(format, localized...)
+}
+
+// ユーザーに表示するエラー（表示する時の言語で翻訳できる）
+type LocalizedError struct {
+	Key  string
+	Args []interface{}
+}
+
+// メッセージカタログのキーからエラーを作成する
+func NewLocalizedError(key string, args ...interface{}) error {
+	return &LocalizedError{Key: key, Args: args}
+}
+
+// ログなどではデフォルトの日本語で表示する
+func (e *LocalizedError) Error() string {
+	return T(LangJapanese, e.Key, e.Args...)
+}
+
+// 引数の最初のエラーを返す（errors.Is・errors.Asで元のエラーを確認できるようにする）
+func (e *LocalizedError) Unwrap() error {
+	for _, arg := range e.Args {
+		if err, ok := arg.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// エラーを指定した言語のメッセージにする（翻訳できないエラーはそのまま）
+func LocalizeError(lang Lang, err error) string {
+	var localized *LocalizedError
+	if errors.As(err, &localized) {
+		return T(lang, localized.Key, localized.Args...)
+	}
+	return err.Error()
+}
@@ -1,83 +1,219 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp" // WebP画像のデコードに対応
 )
 
-// 添付画像をローカルに保存する関数
-func DownloadImage(url, filename string) error {
-	resp, err := http.Get(url)
+// ダウンロードするファイルサイズの上限（デフォルト: 20MB）
+const defaultDownloadMaxBytes = 20 * 1024 * 1024
+
+// ダウンロードのタイムアウト（デフォルト: 30秒）
+const defaultDownloadTimeout = 30 * time.Second
+
+// 環境変数を正の整数として読み取る
+// 未設定・"20MB" のように数字以外を含む値・0以下の値はfalseを返す（呼び出し側でデフォルト値を使う）
+func positiveIntEnv(name string) (int64, bool) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("⚠️  %s=%q は正の整数ではないため無視します", name, value)
+		return 0, false
+	}
+	return n, true
+}
+
+// 環境変数からダウンロードサイズの上限を取得する
+func GetDownloadMaxBytes() int64 {
+	if n, ok := positiveIntEnv("DOWNLOAD_MAX_BYTES"); ok {
+		return n
+	}
+	return defaultDownloadMaxBytes
+}
+
+// 環境変数からダウンロードのタイムアウトを取得する（秒）
+func GetDownloadTimeout() time.Duration {
+	if n, ok := positiveIntEnv("DOWNLOAD_TIMEOUT_SECONDS"); ok {
+		return time.Duration(n) * time.Second
+	}
+	return defaultDownloadTimeout
+}
+
+// ダウンロードで辿るリダイレクトの上限
+const downloadMaxRedirects = 10
+
+// リダイレクト先ごとに、httpsかつ許可ホスト（IMAGE_URL_ALLOWED_HOSTS）のURLか確認する
+// 許可ホストが別のホストへリダイレクトしても、許可リストの外には取りに行かない
+func checkDownloadRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= downloadMaxRedirects {
+		return NewLocalizedError("download.too_many_redirects")
+	}
+	if !IsAllowedImageURL(req.URL.String(), GetAllowedImageHosts()) {
+		return NewLocalizedError("download.redirect_blocked", req.URL.Redacted())
+	}
+	return nil
+}
+
+// 添付画像をメモリ上にダウンロードする関数
+// タイムアウト・ステータスコード・サイズ上限（DOWNLOAD_MAX_BYTES）を確認し、
+// expectedContentType（Discordの添付ファイル情報）が指定されていればレスポンスのContent-Typeと照合する
+func DownloadImage(rawURL, expectedContentType string) ([]byte, error) {
+	maxBytes := GetDownloadMaxBytes()
+
+	client := &http.Client{
+		Timeout:       GetDownloadTimeout(),
+		CheckRedirect: checkDownloadRedirect,
+	}
+
+	resp, err := client.Get(rawURL)
 	if err != nil {
+		var localized *LocalizedError
+		if errors.As(err, &localized) {
+			log.Printf("🚫 リダイレクト先の確認で中止: %v", err)
+			return nil, localized
+		}
 		log.Printf("❌ HTTPリクエスト失敗: %v", err)
-		return err
+		return nil, NewLocalizedError("download.error", err)
 	}
 	defer resp.Body.Close()
 
-	// 一時ディレクトリを使用
-	tempFile := filepath.Join(os.TempDir(), filename)
-	out, err := os.Create(tempFile)
-	if err != nil {
-		log.Printf("❌ 一時ファイル作成失敗: %v", err)
-		return err
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("❌ ダウンロード失敗 - ステータス: %d", resp.StatusCode)
+		return nil, NewLocalizedError("download.status", resp.StatusCode)
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	if contentType := resp.Header.Get("Content-Type"); !IsDownloadContentTypeAllowed(contentType, expectedContentType) {
+		log.Printf("❌ Content-Type不一致 - 実際: %s, 期待: %s", contentType, expectedContentType)
+		return nil, NewLocalizedError("download.content_type", contentType)
+	}
+
+	if resp.ContentLength > maxBytes {
+		return nil, NewLocalizedError("download.too_large", resp.ContentLength, maxBytes)
+	}
+
+	// 上限+1バイトまで読み、超えていればエラー
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 	if err != nil {
-		log.Printf("❌ ファイル書き込み失敗: %v", err)
-		return err
+		log.Printf("❌ ダウンロード失敗: %v", err)
+		return nil, NewLocalizedError("download.error", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, NewLocalizedError("download.too_large_limit", maxBytes)
 	}
 
-	return nil
+	return data, nil
+}
+
+// ダウンロードしたレスポンスのContent-Typeを受け付けるか判定する
+// HTML（エラーページなど）は常に拒否し、期待する種類がある場合は大分類（image, applicationなど）を照合する
+func IsDownloadContentTypeAllowed(actual, expected string) bool {
+	actual = baseMimeType(actual)
+	expected = baseMimeType(expected)
+
+	if actual == "text/html" {
+		return false
+	}
+	// Content-Typeが無い・汎用バイナリの場合は中身の判定に任せる
+	if actual == "" || actual == "application/octet-stream" || expected == "" {
+		return true
+	}
+	if actual == expected {
+		return true
+	}
+
+	actualMajor, _, _ := strings.Cut(actual, "/")
+	expectedMajor, _, _ := strings.Cut(expected, "/")
+	return actualMajor == expectedMajor
+}
+
+// MIME typeからパラメータ（; charset=...など）を取り除いて小文字にする
+func baseMimeType(mimeType string) string {
+	if idx := strings.Index(mimeType, ";"); idx >= 0 {
+		mimeType = mimeType[:idx]
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
 }
 
 // 画像を圧縮する関数
-func CompressImage(inputPath string) (string, error) {
+// 圧縮後のJPEG（圧縮が不要な場合はメタデータを除いた元の画像）を返す
+func CompressImage(data []byte) ([]byte, error) {
 	// 環境変数から設定を読み込み（デフォルト値あり）
 	maxWidth := 1500
 	quality := 85
 	enableCompression := true
 
-	if width := os.Getenv("IMAGE_MAX_WIDTH"); width != "" {
-		fmt.Sscanf(width, "%d", &maxWidth)
+	if width, ok := positiveIntEnv("IMAGE_MAX_WIDTH"); ok {
+		maxWidth = int(width)
 	}
-	if qual := os.Getenv("IMAGE_QUALITY"); qual != "" {
-		fmt.Sscanf(qual, "%d", &quality)
+	if qual, ok := positiveIntEnv("IMAGE_QUALITY"); ok {
+		quality = int(min(qual, 100))
 	}
 	if enable := os.Getenv("ENABLE_COMPRESSION"); enable == "false" {
 		enableCompression = false
 	}
 
-	// 圧縮が無効の場合は元のファイルをそのまま返す
+	// 目標ファイルサイズ（バイト）。0の場合は幅と品質のみで圧縮する
+	targetMaxBytes, _ := positiveIntEnv("IMAGE_TARGET_MAX_BYTES")
+
+	// EXIFの向き補正・メタデータ（GPSなど）削除の設定
+	autoOrient := true
+	stripMetadata := true
+	if orient := os.Getenv("IMAGE_AUTO_ORIENT"); orient == "false" {
+		autoOrient = false
+	}
+	if strip := os.Getenv("IMAGE_STRIP_METADATA"); strip == "false" {
+		stripMetadata = false
+	}
+
+	// 圧縮が無効の場合はメタデータだけ取り除く（無効化されていれば元の画像をそのまま返す）
 	if !enableCompression {
-		return inputPath, nil
+		if !stripMetadata {
+			return data, nil
+		}
+		return stripImageMetadata(data, autoOrient, quality)
 	}
 
-	// 一時ディレクトリ内のファイルパスに変更
-	tempInputPath := filepath.Join(os.TempDir(), filepath.Base(inputPath))
+	originalSize := int64(len(data))
 
-	// 元のファイルサイズを取得
-	fileInfo, err := os.Stat(tempInputPath)
-	if err != nil {
-		log.Printf("❌ ファイル情報取得失敗: %v", err)
-		return "", fmt.Errorf("ファイル情報取得エラー: %v", err)
+	// 元の画像が目標サイズ以内なら再エンコードしない（前処理が有効な場合を除く）
+	if targetMaxBytes > 0 && originalSize <= targetMaxBytes && !LoadPreprocessOptions().Enabled() {
+		if mimeType := DetectMimeType(data); mimeType == "image/jpeg" || mimeType == "image/png" {
+			log.Printf("✅ 目標サイズ以内のため再エンコードをスキップ: %d bytes <= %d bytes", originalSize, targetMaxBytes)
+			if !stripMetadata {
+				return data, nil
+			}
+			return stripImageMetadata(data, autoOrient, quality)
+		}
 	}
-	originalSize := fileInfo.Size()
 
-	// 画像を読み込む
-	img, err := imaging.Open(tempInputPath)
+	// 画像を読み込む（EXIFのOrientationに従って回転）
+	// 再エンコードしたJPEGにはEXIF/XMPなどのメタデータは含まれない（向き補正をしない場合はOrientationだけ付け直す）
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(autoOrient))
 	if err != nil {
 		log.Printf("❌ 画像読み込み失敗: %v", err)
-		return "", fmt.Errorf("画像読み込みエラー: %v", err)
+		return nil, NewLocalizedError("image.decode_error", err)
 	}
+
+	// レシート向けの前処理（切り抜き・傾き補正・グレースケール・コントラスト強調・2値化）
+	preprocessOpts := LoadPreprocessOptions()
+	img = PreprocessReceipt(img, preprocessOpts)
+
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
@@ -92,25 +228,180 @@ func CompressImage(inputPath string) (string, error) {
 		resizedImg = imaging.Resize(img, maxWidth, newHeight, imaging.Lanczos)
 	}
 
-	// 一時ディレクトリに出力ファイル名を生成
-	ext := filepath.Ext(tempInputPath)
-	baseName := strings.TrimSuffix(filepath.Base(tempInputPath), ext)
-	outputPath := filepath.Join(os.TempDir(), baseName+"_compressed.jpg")
+	// グレースケール化した場合は1チャンネルのJPEGとして保存してサイズを抑える
+	if preprocessOpts.Grayscale || preprocessOpts.Contrast || preprocessOpts.Threshold {
+		resizedImg = toGray(resizedImg)
+	}
+
+	var compressed []byte
+	if targetMaxBytes > 0 {
+		// 目標サイズに収まるまで品質・サイズを下げてJPEGとしてエンコード
+		compressed, err = EncodeJPEGWithinSize(resizedImg, targetMaxBytes, quality)
+		if err != nil {
+			log.Printf("❌ 画像圧縮失敗: %v", err)
+			return nil, err
+		}
+	} else {
+		// JPEGとしてエンコード（品質指定）
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, resizedImg, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+			log.Printf("❌ 画像エンコード失敗: %v", err)
+			return nil, NewLocalizedError("image.encode_error", err)
+		}
+		compressed = buf.Bytes()
+	}
 
-	// JPEGとして保存（品質指定）
-	err = imaging.Save(resizedImg, outputPath, imaging.JPEGQuality(quality))
-	if err != nil {
-		log.Printf("❌ 画像保存失敗: %v", err)
-		return "", fmt.Errorf("画像保存エラー: %v", err)
+	// 向き補正をしない設定では、回転していない画像が横向きに届かないようOrientationだけ付け直す
+	if orientation := ReadJPEGOrientation(data); !autoOrient && orientation != 1 {
+		compressed = InsertJPEGSegments(compressed, JPEGOrientationSegment(orientation))
 	}
 
-	// 圧縮後のファイルサイズを取得
-	compressedInfo, err := os.Stat(outputPath)
-	if err == nil {
-		compressedSize := compressedInfo.Size()
-		compressionRatio := float64(originalSize-compressedSize) / float64(originalSize) * 100
+	// 圧縮率をログ出力
+	if originalSize > 0 {
+		compressionRatio := float64(originalSize-int64(len(compressed))) / float64(originalSize) * 100
 		log.Printf("✅ 画像圧縮完了: %.1f%% 削減", compressionRatio)
 	}
 
-	return outputPath, nil
+	return compressed, nil
+}
+
+// 目標サイズ以内に収まるJPEGを作成する関数
+// まず品質を二分探索し、最低品質でも収まらない場合は縦横を3/4ずつ縮小して再試行する（拡大はしない）
+func EncodeJPEGWithinSize(img image.Image, maxBytes int64, maxQuality int) ([]byte, error) {
+	const minQuality = 30
+	const minSide = 320
+
+	current := img
+	for {
+		var best []byte
+		bestQuality := 0
+		// 設定された品質が最低品質より低い場合はその品質を上限にする（引き上げない）
+		lo, hi := min(minQuality, maxQuality), maxQuality
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			var buf bytes.Buffer
+			if err := imaging.Encode(&buf, current, imaging.JPEG, imaging.JPEGQuality(mid)); err != nil {
+				return nil, NewLocalizedError("image.encode_error", err)
+			}
+			if int64(buf.Len()) <= maxBytes {
+				best, bestQuality = buf.Bytes(), mid
+				lo = mid + 1
+			} else {
+				hi = mid - 1
+			}
+		}
+
+		bounds := current.Bounds()
+		if best != nil {
+			log.Printf("🎯 目標サイズ以内に圧縮: %dx%d, 品質 %d, %d bytes (目標: %d bytes)", bounds.Dx(), bounds.Dy(), bestQuality, len(best), maxBytes)
+			return best, nil
+		}
+
+		newWidth, newHeight := bounds.Dx()*3/4, bounds.Dy()*3/4
+		if max(newWidth, newHeight) < minSide {
+			return nil, NewLocalizedError("image.target_size", maxBytes)
+		}
+		current = imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
+	}
+}
+
+// 再エンコードせずに画像からメタデータを取り除く関数（圧縮無効時に使用）
+// 向き補正が必要なJPEGのみ、回転のために再エンコードする
+// 向き補正をしない場合はEXIFのOrientationだけを残し、どちらの場合もICCプロファイルは残す
+func stripImageMetadata(data []byte, autoOrient bool, quality int) ([]byte, error) {
+	var stripped []byte
+
+	switch DetectMimeType(data) {
+	case "image/jpeg":
+		if autoOrient && ReadJPEGOrientation(data) != 1 {
+			img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+			if err != nil {
+				log.Printf("❌ 画像読み込み失敗: %v", err)
+				return nil, NewLocalizedError("image.decode_error", err)
+			}
+			var buf bytes.Buffer
+			if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+				log.Printf("❌ 画像エンコード失敗: %v", err)
+				return nil, NewLocalizedError("image.encode_error", err)
+			}
+			// 色の再現に必要なICCプロファイルは付け直す
+			stripped = InsertJPEGSegments(buf.Bytes(), JPEGICCSegments(data)...)
+		} else {
+			stripped = StripJPEGMetadata(data)
+		}
+	case "image/png":
+		stripped = StripPNGMetadata(data)
+	default:
+		return data, nil
+	}
+
+	if len(stripped) != len(data) {
+		log.Printf("🧹 メタデータを削除しました: %d bytes -> %d bytes", len(data), len(stripped))
+	}
+	return stripped, nil
+}
+
+// HEIC/HEIFのftypブランド
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// バイト列の先頭（ftypボックス）からHEIC/HEIF画像かどうかを判定する
+func IsHEIC(header []byte) bool {
+	if len(header) < 12 || string(header[4:8]) != "ftyp" {
+		return false
+	}
+	return heifBrands[string(header[8:12])]
+}
+
+// HEIC/HEIF画像をJPEGに変換する関数
+// Goの標準ライブラリ・imagingではHEVCをデコードできないため、
+// DiscordのメディアプロキシにJPEG形式で配信させたものを取得する
+// プロキシURLがあるのはDiscordの添付ファイル・埋め込み画像だけで、URLで貼り付けた画像は変換できない
+func ConvertHEICToJPEG(proxyURL string) ([]byte, error) {
+	if proxyURL == "" {
+		return nil, NewLocalizedError("heic.no_proxy")
+	}
+
+	jpegURL, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, NewLocalizedError("heic.url_error", err)
+	}
+	query := jpegURL.Query()
+	query.Set("format", "jpeg")
+	jpegURL.RawQuery = query.Encode()
+
+	data, err := DownloadImage(jpegURL.String(), "image/jpeg")
+	if err != nil {
+		return nil, NewLocalizedError("heic.convert_failed", err)
+	}
+
+	// 変換結果が実際にデコードできるJPEGか確認する
+	if _, err := imaging.Decode(bytes.NewReader(data)); err != nil {
+		log.Printf("❌ HEIC変換結果のデコード失敗: %v", err)
+		return nil, NewLocalizedError("heic.decode_failed")
+	}
+
+	log.Printf("✅ HEIC画像をJPEGに変換しました（%d bytes）", len(data))
+	return data, nil
+}
+
+// 結果表示用のサムネイルの長辺（ピクセル）
+const thumbnailMaxSide = 320
+
+// 結果表示用の小さなJPEGサムネイルを作成する
+func MakeThumbnail(data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("サムネイル用の画像読み込みエラー: %v", err)
+	}
+
+	img = imaging.Fit(img, thumbnailMaxSide, thumbnailMaxSide, imaging.Lanczos)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(75)); err != nil {
+		return nil, fmt.Errorf("サムネイルのエンコードエラー: %v", err)
+	}
+	return buf.Bytes(), nil
 }
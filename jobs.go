@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// レシート処理ジョブの状態
+const (
+	JobStatusProcessing   = "processing"    // 処理中
+	JobStatusDone         = "done"          // 成功
+	JobStatusWarning      = "warning"       // Dify内部エラー
+	JobStatusFailed       = "failed"        // 失敗
+	JobStatusLedgerFailed = "ledger_failed" // 読み取りは成功したが家計簿への記録（LEDGER_WRITER=bot）に失敗
+)
+
+// 処理履歴を残す日数
+const jobHistoryRetention = 30 * 24 * time.Hour
+
+// レシート1枚分の処理履歴（再処理に使う）
+type ReceiptJob struct {
+	ID        string         `json:"id"`
+	ChannelID string         `json:"channel_id"` // 元のメッセージのチャンネル
+	MessageID string         `json:"message_id"` // 元のメッセージ
+	UserID    string         `json:"user_id"`    // 支払った人として記録するユーザー
+	Username  string         `json:"username"`
+	Source    ReceiptSource  `json:"source"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Result    *ReceiptResult `json:"result,omitempty"` // 記録した内容（元の金額と基準通貨に換算した金額）
+	Attempts  int            `json:"attempts"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// 再処理の対象（失敗・Dify内部エラー・家計簿への記録の失敗）かどうか
+func (j *ReceiptJob) Failed() bool {
+	return j.Status == JobStatusFailed || j.Status == JobStatusWarning || j.Status == JobStatusLedgerFailed
+}
+
+// Difyでの読み取りは済んでいて、家計簿への記録だけが残っているかどうか
+// 再処理ではDifyを実行し直さずに（ワークフローが記録して二重にならないよう）家計簿への記録だけやり直す
+func (j *ReceiptJob) LedgerOnly() bool {
+	return j.Result != nil && j.Result.Parsed && j.Result.Error == ""
+}
+
+// レシート処理の履歴を保存するストア（pathが空の場合はメモリ上のみ）
+type JobStore struct {
+	mu   sync.Mutex
+	path string
+	jobs map[string]*ReceiptJob
+}
+
+// 処理履歴（起動時にLoadJobStoreで差し替える）
+var receiptJobs = NewJobStore("")
+
+// 処理履歴のファイルパスを取得する（デフォルト: data/jobs.json）
+func GetJobHistoryPath() string {
+	if path := os.Getenv("JOB_HISTORY_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join("data", "jobs.json")
+}
+
+func NewJobStore(path string) *JobStore {
+	return &JobStore{path: path, jobs: map[string]*ReceiptJob{}}
+}
+
+// ファイルから処理履歴を読み込む（ファイルがない場合は空の履歴を返す）
+func LoadJobStore(path string) (*JobStore, error) {
+	store := NewJobStore(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, fmt.Errorf("処理履歴の読み込みエラー: %v", err)
+	}
+
+	var jobs []*ReceiptJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return store, fmt.Errorf("処理履歴の解析エラー: %v", err)
+	}
+	for _, job := range jobs {
+		// 処理中のまま終了したジョブは失敗として扱い、再処理できるようにする
+		if job.Status == JobStatusProcessing {
+			job.Status = JobStatusFailed
+			job.Error = T(GetDefaultLang(), "jobs.interrupted")
+		}
+		store.jobs[job.ID] = job
+	}
+
+	log.Printf("📚 処理履歴を読み込みました: %d件 (%s)", len(jobs), path)
+	return store, nil
+}
+
+// 新しいジョブを作成して保存する
+func (st *JobStore) Create(channelID, messageID, userID, username string, source ReceiptSource) *ReceiptJob {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	job := &ReceiptJob{
+		ID:        st.newID(),
+		ChannelID: channelID,
+		MessageID: messageID,
+		UserID:    userID,
+		Username:  username,
+		Source:    source,
+		Status:    JobStatusProcessing,
+		Attempts:  1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	st.jobs[job.ID] = job
+	st.saveOrLog()
+	return job
+}
+
+// 失敗したジョブを再処理のために処理中にする
+// 再処理の対象でない（成功済み・処理中・存在しない）場合はfalseを返す
+func (st *JobStore) Claim(id string) (ReceiptJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok || !job.Failed() {
+		return ReceiptJob{}, false
+	}
+	job.Status = JobStatusProcessing
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	st.saveOrLog()
+	return *job, true
+}
+
+// ジョブの状態と記録した内容を更新して保存する
+func (st *JobStore) Update(id, status, errMessage string, result *ReceiptResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok {
+		return
+	}
+	job.Status = status
+	job.Error = errMessage
+	job.Result = result
+	job.UpdatedAt = time.Now()
+	st.saveOrLog()
+}
+
+// IDでジョブを取得する（呼び出し側で変更しないようコピーを返す）
+func (st *JobStore) Get(id string) (ReceiptJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok {
+		return ReceiptJob{}, false
+	}
+	return *job, true
+}
+
+// 指定した時刻以降に作成され、最後の処理が失敗したジョブを古い順に返す
+func (st *JobStore) FailedSince(since time.Time) []ReceiptJob {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var jobs []ReceiptJob
+	for _, job := range st.jobs {
+		if job.Failed() && !job.CreatedAt.Before(since) {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].CreatedAt.Before(jobs[b].CreatedAt)
+	})
+	return jobs
+}
+
+// 重複しない短いジョブIDを作成する（ボタンのCustomIDに埋め込むため8文字）
+func (st *JobStore) newID() string {
+	return newShortID(func(id string) bool {
+		_, exists := st.jobs[id]
+		return exists
+	})
+}
+
+// 重複しない8文字のIDを作成する（existsで使用済みかどうかを判定する）
+func newShortID(exists func(id string) bool) string {
+	for {
+		buf := make([]byte, 4)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+		}
+		id := hex.EncodeToString(buf)
+		if !exists(id) {
+			return id
+		}
+	}
+}
+
+// 処理履歴をファイルに保存する（保存期間を過ぎたものは削除する）
+// 呼び出し側でロックを取ること
+func (st *JobStore) save() error {
+	cutoff := time.Now().Add(-jobHistoryRetention)
+	jobs := make([]*ReceiptJob, 0, len(st.jobs))
+	for id, job := range st.jobs {
+		if job.CreatedAt.Before(cutoff) {
+			delete(st.jobs, id)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	if st.path == "" {
+		return nil
+	}
+
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].CreatedAt.Before(jobs[b].CreatedAt)
+	})
+	return writeJSONFileAtomic(st.path, jobs)
+}
+
+// 処理履歴を保存する（呼び出し側でロックを取ること）
+// 保存できなくてもレシートの処理は続けられるため、失敗はログに残すだけにする
+func (st *JobStore) saveOrLog() {
+	if err := st.save(); err != nil {
+		log.Printf("❌ 処理履歴の保存失敗: %v", err)
+	}
+}
@@ -1,7 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/disintegration/imaging"
 )
 
 // TestTruncateString - 文字列切り詰めのテスト
@@ -44,6 +69,11 @@ func TestFormatAmountWithComma(t *testing.T) {
 		{"不正形式", "食費1234", "食費1234"},
 		{"空金額", "食費：", "食費："},
 		{"文字混入", "食費：12a34", "食費：12a34"},
+		{"マイナス", "返金：-1234", "返金：-1,234"},
+		{"全角数字", "食費：１２３４５", "食費：12,345"},
+		{"円記号付き", "食費：¥1234", "食費：1,234"},
+		{"カンマ付き", "食費：1,234", "食費：1,234"},
+		{"小数", "食費：1234.4", "食費：1,234"},
 	}
 
 	for _, tt := range tests {
@@ -67,6 +97,8 @@ func TestGetMimeType(t *testing.T) {
 		{"PNG", "image.png", "image/png"},
 		{"GIF", "anim.gif", "image/gif"},
 		{"WebP", "modern.webp", "image/webp"},
+		{"HEIC", "IMG_0001.HEIC", "image/heic"},
+		{"HEIF", "photo.heif", "image/heif"},
 		{"PDF", "doc.pdf", "application/pdf"},
 		{"大文字", "IMAGE.JPG", "image/jpeg"},
 		{"未知", "file.xyz", "application/octet-stream"},
@@ -109,3 +141,3297 @@ func TestGetPayerFromDiscordUser(t *testing.T) {
 		})
 	}
 }
+
+// TestGetDifyFileType - Difyファイル種別判定のテスト
+func TestGetDifyFileType(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		want     string
+	}{
+		{"JPEG", "image/jpeg", "image"},
+		{"PNG", "image/png", "image"},
+		{"PDF", "application/pdf", "document"},
+		{"テキスト", "text/plain", "document"},
+		{"未知", "application/octet-stream", "custom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetDifyFileType(tt.mimeType)
+			if got != tt.want {
+				t.Errorf("GetDifyFileType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtractScannedPDFPages - スキャンPDFの判定とページ画像抽出のテスト
+func TestExtractScannedPDFPages(t *testing.T) {
+	// JPEGのデータにオブジェクトの書き出しに似た並びがあっても、ストリームの中は読まない
+	jpeg := func(name string) string { return "\xFF\xD8\xFF\xE0" + name + " 9 0 obj << /Type /Catalog >>\xFF\xD9" }
+	object := func(n int, value string) string { return strconv.Itoa(n) + " 0 obj\n" + value + "\nendobj\n" }
+	image := func(n, width, height int, data string) string {
+		return strconv.Itoa(n) + " 0 obj\n<< /Type /XObject /Subtype /Image /Width " + strconv.Itoa(width) + " /Height " + strconv.Itoa(height) +
+			" /Filter /DCTDecode /Length " + strconv.Itoa(len(data)) + " >>\nstream\n" + data + "\nendstream\nendobj\n"
+	}
+	page := func(n int, xobjects string) string {
+		return object(n, "<< /Type /Page /Parent 2 0 R /Resources << /XObject << "+xobjects+" >> >> >>")
+	}
+	pdf := func(kids string, parts ...string) []byte {
+		return []byte("%PDF-1.4\n" + object(1, "<< /Type /Catalog /Pages 2 0 R >>") +
+			object(2, "<< /Type /Pages /Kids ["+kids+"] /Count 2 /MediaBox [0 0 595 842] >>") +
+			strings.Join(parts, "") + "trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	}
+
+	// PDF 1.5以降のオブジェクトストリームにページツリーを圧縮したPDF
+	objStm := func() []byte {
+		objects := []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 595 842] >>",
+			"<< /Type /Page /Parent 2 0 R /Resources 4 0 R >>",
+			"<< /XObject << /Im0 5 0 R >> >>",
+		}
+		var header, body strings.Builder
+		for i, value := range objects {
+			fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+			body.WriteString(value + " ")
+		}
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write([]byte(header.String() + body.String()))
+		w.Close()
+		return []byte("%PDF-1.7\n" + image(5, 1240, 1754, jpeg("scan")) +
+			"6 0 obj\n<< /Type /ObjStm /N 4 /First " + strconv.Itoa(header.Len()) + " /Filter /FlateDecode /Length 7 0 R >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n" +
+			object(7, strconv.Itoa(compressed.Len())) +
+			"8 0 obj\n<< /Type /XRef /Root 1 0 R /Size 9 /Length 0 >>\nstream\n\nendstream\nendobj\n%%EOF\n")
+	}
+
+	tests := []struct {
+		name      string
+		pdf       []byte
+		wantPages []string
+	}{
+		{"1ページ1枚のスキャンPDF", pdf("3 0 R 4 0 R", page(3, "/Im0 5 0 R"), page(4, "/Im0 6 0 R"), image(5, 1240, 1754, jpeg("page1")), image(6, 1240, 1754, jpeg("page2"))), []string{jpeg("page1"), jpeg("page2")}},
+		{"圧縮されたページツリー", objStm(), []string{jpeg("scan")}},
+		{"テキストだけのPDF", pdf("3 0 R 4 0 R", page(3, ""), page(4, "")), nil},
+		{"ロゴ入りのPDF", pdf("3 0 R", page(3, "/Logo 4 0 R"), image(4, 200, 60, jpeg("logo"))), nil},
+		{"1ページに複数の画像", pdf("3 0 R", page(3, "/Im0 4 0 R /Im1 5 0 R"), image(4, 1240, 1754, jpeg("a")), image(5, 1240, 1754, jpeg("b"))), nil},
+		{"ページがないPDF", pdf("", image(3, 1240, 1754, jpeg("scan"))), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, ok := ExtractScannedPDFPages(tt.pdf)
+			if ok != (tt.wantPages != nil) || len(pages) != len(tt.wantPages) {
+				t.Fatalf("ExtractScannedPDFPages() = %d pages, %v, want %d pages", len(pages), ok, len(tt.wantPages))
+			}
+			for i := range pages {
+				if string(pages[i]) != tt.wantPages[i] {
+					t.Errorf("page %d = %q, want %q", i+1, pages[i], tt.wantPages[i])
+				}
+			}
+
+			// documentモードでは判定せずにPDFのまま送信する
+			t.Setenv("DIFY_PDF_MODE", "document")
+			if pages := PDFPageImages(tt.pdf); pages != nil {
+				t.Errorf("PDFPageImages() in document mode = %d pages, want PDF as is", len(pages))
+			}
+
+			// scannedモードでもスキャンPDF以外はPDFのまま送信する
+			t.Setenv("DIFY_PDF_MODE", "scanned")
+			if pages := PDFPageImages(tt.pdf); len(pages) != len(tt.wantPages) {
+				t.Errorf("PDFPageImages() in scanned mode = %d pages, want %d", len(pages), len(tt.wantPages))
+			}
+		})
+	}
+}
+
+// TestGetPDFMode - PDF送信モードの設定のテスト
+func TestGetPDFMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"未設定", "", PDFModeDocument},
+		{"document", "document", PDFModeDocument},
+		{"scanned", "Scanned", PDFModeScanned},
+		{"以前の名前のimage", "image", PDFModeScanned},
+		{"不明な値", "raster", PDFModeDocument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DIFY_PDF_MODE", tt.value)
+			if got := GetPDFMode(); got != tt.want {
+				t.Errorf("GetPDFMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIsHEIC - HEIC/HEIF判定のテスト
+func TestIsHEIC(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{"HEIC", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), true},
+		{"HEIF(mif1)", []byte("\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00"), true},
+		{"MP4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), false},
+		{"JPEG", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00"), false},
+		{"短すぎる", []byte("ftyp"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := IsHEIC(tt.header)
+			if got != tt.want {
+				t.Errorf("IsHEIC() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConvertHEICToJPEGWithoutProxy - プロキシURLのないHEIC画像（URLで貼り付けた画像）のテスト
+func TestConvertHEICToJPEGWithoutProxy(t *testing.T) {
+	_, err := ConvertHEICToJPEG("")
+	var localized *LocalizedError
+	if !errors.As(err, &localized) || localized.Key != "heic.no_proxy" {
+		t.Fatalf("ConvertHEICToJPEG(\"\") error = %v, want heic.no_proxy", err)
+	}
+	if got := LocalizeError(LangEnglish, err); !strings.Contains(got, "attached directly to Discord") {
+		t.Errorf("LocalizeError() = %q, want a hint to attach the image", got)
+	}
+}
+
+// TestConvertHEICToJPEGViaProxy - DiscordのメディアプロキシでのJPEG変換のテスト
+func TestConvertHEICToJPEGViaProxy(t *testing.T) {
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00heic-body")
+	var converted bytes.Buffer
+	if err := jpeg.Encode(&converted, makeNoiseImage(40, 30), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /converts はJPEGに変換して返し（署名のクエリも残っていること）、/passthrough は形式の指定を無視してHEICのまま返す
+		if r.URL.Path == "/converts" && r.URL.Query().Get("format") == "jpeg" && r.URL.Query().Get("ex") == "1" {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(converted.Bytes())
+			return
+		}
+		w.Header().Set("Content-Type", "image/heic")
+		w.Write(heic)
+	}))
+	defer server.Close()
+
+	data, err := ConvertHEICToJPEG(server.URL + "/converts?ex=1")
+	if err != nil {
+		t.Fatalf("ConvertHEICToJPEG() error = %v", err)
+	}
+	if !bytes.Equal(data, converted.Bytes()) {
+		t.Errorf("ConvertHEICToJPEG() = %d bytes, want the converted JPEG", len(data))
+	}
+
+	data, err = ConvertHEICToJPEG(server.URL + "/passthrough?ex=1")
+	var localized *LocalizedError
+	if !errors.As(err, &localized) || localized.Key != "heic.decode_failed" {
+		t.Fatalf("ConvertHEICToJPEG() error = %v, want heic.decode_failed", err)
+	}
+	if data != nil {
+		t.Errorf("ConvertHEICToJPEG() passed HEIC data through")
+	}
+}
+
+// TestDetectMimeType - マジックバイトによるMIME type判定のテスト
+func TestDetectMimeType(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"JPEG", "\xFF\xD8\xFF\xE0\x00\x10JFIF", "image/jpeg"},
+		{"PNG", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+		{"GIF", "GIF89a\x01\x00\x01\x00", "image/gif"},
+		{"WebP", "RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"PDF", "%PDF-1.7\n", "application/pdf"},
+		{"HEIC", "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "image/heic"},
+		{"HEIF", "\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00", "image/heif"},
+		{"HTML", "<!DOCTYPE html><html>", "text/html"},
+		{"バイナリ", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectMimeType([]byte(tt.header))
+			if got != tt.want {
+				t.Errorf("DetectMimeType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFilenameForMimeType - 拡張子補正のテスト
+func TestFilenameForMimeType(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		mimeType string
+		want     string
+	}{
+		{"拡張子なし", "image0", "image/png", "image0.png"},
+		{"拡張子違い", "receipt.png", "image/jpeg", "receipt.jpg"},
+		{"一致", "receipt.JPEG", "image/jpeg", "receipt.JPEG"},
+		{"未知の形式", "data.bin", "application/zip", "data.bin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FilenameForMimeType(tt.filename, tt.mimeType)
+			if got != tt.want {
+				t.Errorf("FilenameForMimeType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// テスト用: EXIF（Orientation + GPS）付きのJPEGを作成する
+// 左半分が赤・右半分が青の width x height の画像
+func makeJPEGWithEXIF(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+
+	// TIFF(ビッグエンディアン): IFD0 = Orientation + GPSInfoポインタ, GPS IFD = 緯度
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(2))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x8825, 4})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, uint32(8+2+2*12+4))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0001, 2})
+	binary.Write(&tiff, binary.BigEndian, uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+// TestReadJPEGOrientation - EXIF Orientation読み取りのテスト
+func TestReadJPEGOrientation(t *testing.T) {
+	if got := ReadJPEGOrientation(makeJPEGWithEXIF(t, 8, 4, 6)); got != 6 {
+		t.Errorf("ReadJPEGOrientation() = %v, want 6", got)
+	}
+	if got := ReadJPEGOrientation([]byte("\xFF\xD8\xFF\xD9")); got != 1 {
+		t.Errorf("ReadJPEGOrientation() without EXIF = %v, want 1", got)
+	}
+}
+
+// テスト用: SOIの直後にICCプロファイル（APP2）を入れたJPEG
+func withICCProfile(data []byte) []byte {
+	payload := append([]byte("ICC_PROFILE\x00\x01\x01"), []byte("test-profile")...)
+	segment := []byte{0xFF, 0xE2, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return InsertJPEGSegments(data, append(segment, payload...))
+}
+
+// 元のEXIF（GPSを含む）が取り除かれ、Orientationだけの最小のEXIFになっているか確認する
+func assertOnlyOrientationEXIF(t *testing.T, data []byte, orientation int) {
+	t.Helper()
+	if got := ReadJPEGOrientation(data); got != orientation {
+		t.Errorf("Orientation = %d, want %d", got, orientation)
+	}
+	if orientation == 1 {
+		if bytes.Contains(data, []byte("Exif")) {
+			t.Errorf("output still contains EXIF")
+		}
+		return
+	}
+	if bytes.Count(data, []byte("Exif")) != 1 || !bytes.Contains(data, JPEGOrientationSegment(orientation)) {
+		t.Errorf("output EXIF contains more than the Orientation tag")
+	}
+}
+
+// TestStripJPEGMetadata - JPEGメタデータ削除のテスト
+func TestStripJPEGMetadata(t *testing.T) {
+	data := withICCProfile(makeJPEGWithEXIF(t, 8, 4, 6))
+
+	stripped := StripJPEGMetadata(data)
+	assertOnlyOrientationEXIF(t, stripped, 6)
+	if !bytes.Contains(stripped, []byte("ICC_PROFILE\x00")) {
+		t.Errorf("StripJPEGMetadata() removed the ICC profile")
+	}
+	if got := StripJPEGMetadata(makeJPEGWithEXIF(t, 8, 4, 1)); bytes.Contains(got, []byte("Exif")) {
+		t.Errorf("StripJPEGMetadata() kept EXIF without a rotation")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("StripJPEGMetadata() produced undecodable JPEG: %v", err)
+	}
+	if got := StripJPEGMetadata([]byte("not a jpeg")); string(got) != "not a jpeg" {
+		t.Errorf("StripJPEGMetadata() modified non-JPEG data")
+	}
+}
+
+// TestCompressImageOrientation - 圧縮時の向き補正とメタデータ削除のテスト
+func TestCompressImageOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		autoOrient  string
+		wantWidth   int
+		wantHeight  int
+		// 向き補正をしない場合は表示側で回転できるようOrientationだけ残す
+		wantOrientation int
+	}{
+		{"圧縮あり・向き補正", "true", "true", 20, 40, 1},
+		{"圧縮あり・向き補正なし", "true", "false", 40, 20, 6},
+		{"圧縮なし・向き補正", "false", "true", 20, 40, 1},
+		{"圧縮なし・向き補正なし", "false", "false", 40, 20, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENABLE_COMPRESSION", tt.compression)
+			t.Setenv("IMAGE_AUTO_ORIENT", tt.autoOrient)
+
+			data, err := CompressImage(makeJPEGWithEXIF(t, 40, 20, 6))
+			if err != nil {
+				t.Fatalf("CompressImage() error = %v", err)
+			}
+
+			assertOnlyOrientationEXIF(t, data, tt.wantOrientation)
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("jpeg.Decode() error = %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.wantWidth || b.Dy() != tt.wantHeight {
+				t.Errorf("CompressImage() size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+// TestCompressImageKeepsICCProfile - 圧縮なしで向きを補正した場合もICCプロファイルを残すことのテスト
+func TestCompressImageKeepsICCProfile(t *testing.T) {
+	t.Setenv("ENABLE_COMPRESSION", "false")
+
+	data, err := CompressImage(withICCProfile(makeJPEGWithEXIF(t, 40, 20, 6)))
+	if err != nil {
+		t.Fatalf("CompressImage() error = %v", err)
+	}
+	if !bytes.Contains(data, []byte("ICC_PROFILE\x00")) {
+		t.Errorf("CompressImage() removed the ICC profile")
+	}
+	assertOnlyOrientationEXIF(t, data, 1)
+}
+
+var updateGolden = flag.Bool("update", false, "ゴールデン画像（testdata/golden/*.png）を更新する")
+
+// テスト用: 暗い背景の上に傾いたレシート（白い紙＋文字の行）がある画像を作成する
+func makeReceiptPhoto(angle float64) image.Image {
+	paper := image.NewNRGBA(image.Rect(0, 0, 160, 240))
+	draw.Draw(paper, paper.Bounds(), image.NewUniform(color.NRGBA{225, 220, 210, 255}), image.Point{}, draw.Src)
+	for i, y := 0, 20; y < 220; i, y = i+1, y+14 {
+		lineWidth := 60 + (i*37)%80
+		draw.Draw(paper, image.Rect(15, y, 15+lineWidth, y+4), image.NewUniform(color.NRGBA{50, 45, 40, 255}), image.Point{}, draw.Src)
+	}
+
+	background := color.NRGBA{70, 80, 90, 255}
+	rotated := imaging.Rotate(paper, angle, background)
+
+	photo := image.NewNRGBA(image.Rect(0, 0, 320, 360))
+	draw.Draw(photo, photo.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	offset := image.Pt((320-rotated.Bounds().Dx())/2, (360-rotated.Bounds().Dy())/2)
+	draw.Draw(photo, rotated.Bounds().Add(offset), rotated, image.Point{}, draw.Src)
+	return photo
+}
+
+// ゴールデン画像と比較する（浮動小数点演算の差を許容するため、わずかな誤差は無視する）
+func assertGolden(t *testing.T, name string, got image.Image) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".png")
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := imaging.Save(got, path); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := imaging.Open(path)
+	if err != nil {
+		t.Fatalf("ゴールデン画像を開けません（go test -run %s -update で作成）: %v", t.Name(), err)
+	}
+	if !got.Bounds().Size().Eq(want.Bounds().Size()) {
+		t.Fatalf("size = %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+
+	gotGray, wantGray := toGray(got), toGray(want)
+	diffPixels := 0
+	for i := range gotGray.Pix {
+		d := int(gotGray.Pix[i]) - int(wantGray.Pix[i])
+		if d > 8 || d < -8 {
+			diffPixels++
+		}
+	}
+	if diffPixels > len(gotGray.Pix)/200 {
+		t.Errorf("%d/%d pixels differ from %s", diffPixels, len(gotGray.Pix), path)
+	}
+}
+
+// TestPreprocessReceiptGolden - レシート前処理の各ステップのゴールデン画像テスト
+func TestPreprocessReceiptGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PreprocessOptions
+	}{
+		{"crop", PreprocessOptions{Crop: true}},
+		{"deskew", PreprocessOptions{Deskew: true}},
+		{"grayscale", PreprocessOptions{Grayscale: true}},
+		{"contrast", PreprocessOptions{Contrast: true}},
+		{"threshold", PreprocessOptions{Threshold: true}},
+		{"all", PreprocessOptions{Crop: true, Deskew: true, Grayscale: true, Contrast: true, Threshold: true}},
+	}
+
+	input := makeReceiptPhoto(5)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertGolden(t, "preprocess_"+tt.name, PreprocessReceipt(input, tt.opts))
+		})
+	}
+}
+
+// TestDetectSkewAngle - 傾き検出のテスト
+func TestDetectSkewAngle(t *testing.T) {
+	for _, angle := range []float64{-6, 0, 4} {
+		got := DetectSkewAngle(makeReceiptPhoto(angle))
+		if math.Abs(got-angle) > 0.75 {
+			t.Errorf("DetectSkewAngle() for %v° = %v", angle, got)
+		}
+	}
+}
+
+// TestPreprocessDisabled - 前処理が全て無効の場合は画像をそのまま返す
+func TestPreprocessDisabled(t *testing.T) {
+	input := makeReceiptPhoto(5)
+	if got := PreprocessReceipt(input, PreprocessOptions{}); got != input {
+		t.Errorf("PreprocessReceipt() with no options modified the image")
+	}
+}
+
+// テスト用: 圧縮しにくいノイズ画像を作成する
+func makeNoiseImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
+
+// TestEncodeJPEGWithinSize - 目標サイズ指定の圧縮のテスト
+func TestEncodeJPEGWithinSize(t *testing.T) {
+	img := makeNoiseImage(800, 600)
+
+	for _, maxBytes := range []int64{200_000, 60_000} {
+		data, err := EncodeJPEGWithinSize(img, maxBytes, 85)
+		if err != nil {
+			t.Fatalf("EncodeJPEGWithinSize(%d) error = %v", maxBytes, err)
+		}
+		if int64(len(data)) > maxBytes {
+			t.Errorf("EncodeJPEGWithinSize(%d) = %d bytes", maxBytes, len(data))
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := decoded.Bounds(); b.Dx() > 800 || b.Dy() > 600 {
+			t.Errorf("EncodeJPEGWithinSize(%d) upscaled to %dx%d", maxBytes, b.Dx(), b.Dy())
+		}
+	}
+
+	if _, err := EncodeJPEGWithinSize(img, 100, 85); err == nil {
+		t.Errorf("EncodeJPEGWithinSize() with impossible target should fail")
+	}
+}
+
+// TestEncodeJPEGWithinSizeLowQuality - 最低品質（30）より低い IMAGE_QUALITY を引き上げないことのテスト
+func TestEncodeJPEGWithinSizeLowQuality(t *testing.T) {
+	img := makeNoiseImage(200, 150)
+
+	var want bytes.Buffer
+	if err := imaging.Encode(&want, img, imaging.JPEG, imaging.JPEGQuality(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := EncodeJPEGWithinSize(img, 10_000_000, 20)
+	if err != nil {
+		t.Fatalf("EncodeJPEGWithinSize() error = %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("EncodeJPEGWithinSize() = %d bytes, want the quality 20 encoding (%d bytes)", len(got), want.Len())
+	}
+}
+
+// TestCompressImageTargetSize - 目標サイズ以内の画像は再エンコードしない
+func TestCompressImageTargetSize(t *testing.T) {
+	t.Setenv("IMAGE_TARGET_MAX_BYTES", "1000000")
+
+	var original bytes.Buffer
+	if err := jpeg.Encode(&original, makeNoiseImage(200, 100), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CompressImage(original.Bytes())
+	if err != nil {
+		t.Fatalf("CompressImage() error = %v", err)
+	}
+	if !bytes.Equal(got, original.Bytes()) {
+		t.Errorf("CompressImage() re-encoded an image already within the target size")
+	}
+}
+
+// TestGetDownloadMaxBytes - ダウンロードサイズ上限の設定のテスト
+func TestGetDownloadMaxBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int64
+	}{
+		{"未設定", "", defaultDownloadMaxBytes},
+		{"指定あり", "1048576", 1048576},
+		{"0", "0", defaultDownloadMaxBytes},
+		{"マイナス", "-1", defaultDownloadMaxBytes},
+		{"数値以外", "abc", defaultDownloadMaxBytes},
+		{"単位付き", "20MB", defaultDownloadMaxBytes},
+		{"前後の空白", " 2048 ", 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOWNLOAD_MAX_BYTES", tt.value)
+			if got := GetDownloadMaxBytes(); got != tt.want {
+				t.Errorf("GetDownloadMaxBytes() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetDownloadTimeout - ダウンロードのタイムアウトの設定のテスト
+func TestGetDownloadTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"未設定", "", defaultDownloadTimeout},
+		{"指定あり", "10", 10 * time.Second},
+		{"単位付き", "10s", defaultDownloadTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOWNLOAD_TIMEOUT_SECONDS", tt.value)
+			if got := GetDownloadTimeout(); got != tt.want {
+				t.Errorf("GetDownloadTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDownloadImageSizeLimit - ダウンロードサイズ上限のテスト
+func TestDownloadImageSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 2048))
+	}))
+	defer server.Close()
+
+	t.Setenv("DOWNLOAD_MAX_BYTES", "4096")
+	data, err := DownloadImage(server.URL, "")
+	if err != nil || len(data) != 2048 {
+		t.Errorf("DownloadImage() = %d bytes, %v; want 2048 bytes", len(data), err)
+	}
+
+	t.Setenv("DOWNLOAD_MAX_BYTES", "1024")
+	if _, err := DownloadImage(server.URL, ""); err == nil {
+		t.Errorf("DownloadImage() over the limit should fail")
+	}
+}
+
+// TestDownloadImageRedirect - リダイレクト先のホスト・スキームの確認のテスト
+func TestDownloadImageRedirect(t *testing.T) {
+	t.Setenv("IMAGE_URL_ALLOWED_HOSTS", "cdn.discordapp.com")
+
+	targetHit := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetHit = true
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xFF\xD8\xFF\xE0"))
+	}))
+	defer target.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/to-other-host":
+			http.Redirect(w, r, target.URL+"/secret.jpg", http.StatusFound)
+		case "/to-http":
+			http.Redirect(w, r, "http://cdn.discordapp.com/1/receipt.jpg", http.StatusFound)
+		case "/to-unlisted":
+			http.Redirect(w, r, "https://internal.example/receipt.jpg", http.StatusFound)
+		}
+	}))
+	defer redirector.Close()
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"許可されていないホスト（ローカル）", "/to-other-host"},
+		{"許可ホストでもhttp", "/to-http"},
+		{"許可リストにないhttpsのホスト", "/to-unlisted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DownloadImage(redirector.URL+tt.path, "")
+			var localized *LocalizedError
+			if !errors.As(err, &localized) || localized.Key != "download.redirect_blocked" {
+				t.Errorf("DownloadImage() error = %v, want download.redirect_blocked", err)
+			}
+		})
+	}
+	if targetHit {
+		t.Errorf("DownloadImage() followed a redirect to a host that is not allowed")
+	}
+}
+
+// TestDownloadImageValidation - ステータスコード・Content-Typeの検証のテスト
+func TestDownloadImageValidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>Not Found</html>"))
+		default:
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("\xFF\xD8\xFF\xE0"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{"正常", "/receipt.jpg", "image/jpeg", false},
+		{"期待値なし", "/receipt.jpg", "", false},
+		{"404", "/missing", "image/jpeg", true},
+		{"HTMLページ", "/html", "", true},
+		{"種類の不一致", "/receipt.jpg", "application/pdf", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DownloadImage(server.URL+tt.path, tt.expected)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DownloadImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSanitizeFilename - ファイル名サニタイズのテスト
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"通常", "receipt.jpg", "receipt.jpg"},
+		{"日本語", "レシート.png", "レシート.png"},
+		{"パス", "../../etc/passwd", "passwd"},
+		{"Windowsパス", "..\\..\\secret.jpg", "secret.jpg"},
+		{"引用符", "a\"b.jpg", "a_b.jpg"},
+		{"制御文字", "re\x00ce\nipt.jpg", "receipt.jpg"},
+		{"隠しファイル", ".env", "env"},
+		{"空", "", "attachment"},
+		{"ドットのみ", "..", "attachment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeFilename(tt.input)
+			if got != tt.want {
+				t.Errorf("SanitizeFilename(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIsAllowedImageURL - 画像URLのホスト許可リスト判定のテスト
+func TestIsAllowedImageURL(t *testing.T) {
+	allowed := []string{"cdn.discordapp.com", "imgur.com"}
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"許可ホスト", "https://cdn.discordapp.com/attachments/1/2/receipt.jpg", true},
+		{"サブドメイン", "https://i.imgur.com/abc.png", true},
+		{"http", "http://cdn.discordapp.com/a.jpg", false},
+		{"未許可ホスト", "https://example.com/a.jpg", false},
+		{"似たホスト名", "https://evilcdn.discordapp.com.example.com/a.jpg", false},
+		{"ユーザー情報付き", "https://user@cdn.discordapp.com/a.jpg", false},
+		{"不正なURL", "://", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := IsAllowedImageURL(tt.url, allowed)
+			if got != tt.want {
+				t.Errorf("IsAllowedImageURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtractImageURLs - メッセージ本文からの画像URL抽出のテスト
+func TestExtractImageURLs(t *testing.T) {
+	content := "昨日のレシート https://i.imgur.com/abc.JPG と (https://cdn.discordapp.com/x/receipt.pdf)。" +
+		"参考: https://example.com/page https://i.imgur.com/def.png?width=100"
+
+	got := ExtractImageURLs(content)
+	want := []string{
+		"https://i.imgur.com/abc.JPG",
+		"https://cdn.discordapp.com/x/receipt.pdf",
+		"https://i.imgur.com/def.png?width=100",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ExtractImageURLs() = %v, want %v", got, want)
+	}
+}
+
+// TestReceiptSourcesFromMessage - 添付・埋め込み・本文URL・転送メッセージからの収集のテスト
+func TestReceiptSourcesFromMessage(t *testing.T) {
+	t.Setenv("IMAGE_URL_ALLOWED_HOSTS", "cdn.discordapp.com,i.imgur.com")
+
+	m := &discordgo.Message{
+		Content: "https://i.imgur.com/a.jpg https://example.com/b.jpg https://i.imgur.com/a.jpg",
+		Attachments: []*discordgo.MessageAttachment{
+			{URL: "https://cdn.discordapp.com/1/receipt.png", Filename: "receipt.png", ContentType: "image/png"},
+		},
+		Embeds: []*discordgo.MessageEmbed{
+			{Type: discordgo.EmbedTypeImage, Thumbnail: &discordgo.MessageEmbedThumbnail{URL: "https://i.imgur.com/c.png", ProxyURL: "https://media.discordapp.net/external/c.png"}},
+			{Type: discordgo.EmbedTypeRich, Image: &discordgo.MessageEmbedImage{URL: "https://example.com/d.png"}},
+		},
+		MessageSnapshots: []discordgo.MessageSnapshot{
+			{Message: &discordgo.Message{Attachments: []*discordgo.MessageAttachment{
+				{URL: "https://cdn.discordapp.com/2/forwarded.jpg", Filename: "forwarded.jpg"},
+			}}},
+		},
+	}
+
+	got := ReceiptSourcesFromMessage(m)
+	var urls []string
+	for _, source := range got {
+		urls = append(urls, source.URL)
+	}
+	want := []string{
+		"https://cdn.discordapp.com/1/receipt.png",
+		"https://media.discordapp.net/external/c.png",
+		"https://i.imgur.com/a.jpg",
+		"https://cdn.discordapp.com/2/forwarded.jpg",
+	}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("ReceiptSourcesFromMessage() = %v, want %v", urls, want)
+	}
+	if got[1].Filename != "c.png" {
+		t.Errorf("embed filename = %q, want c.png", got[1].Filename)
+	}
+}
+
+// TestBatchStatusEmbed - ステータスメッセージの表示内容と色のテスト
+func TestBatchStatusEmbed(t *testing.T) {
+	tests := []struct {
+		name      string
+		success   int
+		failure   int
+		wantColor int
+		wantText  string
+	}{
+		{"全て成功", 2, 0, statusColorSuccess, "✅ 成功: 2個"},
+		{"一部失敗", 1, 1, statusColorWarning, "❌ 失敗: 1個"},
+		{"全て失敗", 0, 2, statusColorFailure, "全ての画像処理が失敗しました"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// セッションなし（messageIDが空）なので編集は行われない
+			b := &BatchStatus{lang: LangJapanese, color: statusColorRunning, items: []statusItem{{Name: "a.jpg"}, {Name: "b.png"}}}
+			b.Set(0, StageWorkflow, "")
+			b.Set(1, StageFailed, "圧縮に失敗しました")
+
+			embed := b.embed()
+			if !strings.Contains(embed.Description, "🤖 解析中 — **a.jpg**") {
+				t.Errorf("description does not contain running stage: %q", embed.Description)
+			}
+			if !strings.Contains(embed.Description, "❌ 失敗 — **b.png**\n圧縮に失敗しました") {
+				t.Errorf("description does not contain failure detail: %q", embed.Description)
+			}
+
+			b.Finish(tt.success, tt.failure)
+			embed = b.embed()
+			if embed.Color != tt.wantColor {
+				t.Errorf("color = %#x, want %#x", embed.Color, tt.wantColor)
+			}
+			if !strings.Contains(embed.Description, tt.wantText) {
+				t.Errorf("description = %q, want to contain %q", embed.Description, tt.wantText)
+			}
+		})
+	}
+}
+
+// TestFormatYen - 金額の円表記のテスト
+func TestFormatYen(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int
+		want   string
+	}{
+		{"0円", 0, "0円"},
+		{"3桁", 980, "980円"},
+		{"4桁", 1280, "1,280円"},
+		{"7桁", 1234567, "1,234,567円"},
+		{"マイナス", -31828, "-31,828円"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatYen(tt.amount); got != tt.want {
+				t.Errorf("FormatYen(%d) = %q, want %q", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseReceiptResult - Difyのレスポンスからレシートの内容を取り出すテスト
+func TestParseReceiptResult(t *testing.T) {
+	t.Run("正常な結果", func(t *testing.T) {
+		result := `{"workflow_run_id":"run-1","data":{"outputs":{"output":["{\"insertedData\":{\"store\":\"スーパー\",\"item\":\"食材\",\"category\":\"食費\",\"date\":\"2025/01/02\",\"amount\":1280}}"]}}}`
+		got := ParseReceiptResult(result)
+		if !got.Parsed || got.Store != "スーパー" || got.Item != "食材" || got.Category != "食費" || got.Date != "2025/01/02" || got.Amount != (Money{Minor: 1280, Currency: "JPY"}) {
+			t.Errorf("ParseReceiptResult() = %+v", got)
+		}
+		if got.WorkflowRunID != "run-1" {
+			t.Errorf("WorkflowRunID = %q, want run-1", got.WorkflowRunID)
+		}
+	})
+
+	t.Run("Dify内部エラー", func(t *testing.T) {
+		got := ParseReceiptResult(`{"workflow_run_id":"run-2","error":"PluginDaemonInnerError"}`)
+		if got.Error != "PluginDaemonInnerError" || got.Parsed {
+			t.Errorf("ParseReceiptResult() = %+v", got)
+		}
+	})
+
+	t.Run("解析できない結果", func(t *testing.T) {
+		got := ParseReceiptResult("not json")
+		if got.Parsed || got.Raw != "not json" {
+			t.Errorf("ParseReceiptResult() = %+v", got)
+		}
+	})
+}
+
+// TestReceiptResultEmbed - レシート結果の埋め込みのテスト
+func TestReceiptResultEmbed(t *testing.T) {
+	result := &ReceiptResult{Parsed: true, Store: "コンビニ", Amount: Money{Minor: 31828, Currency: "JPY"}, Category: "食費", WorkflowRunID: "run-1"}
+
+	t.Run("成功", func(t *testing.T) {
+		embed := ReceiptResultEmbed(LangJapanese, "a.jpg", "hoshi（Y）", receiptOutcome{Stage: StageDone, Result: result}, "thumbnail_1.jpg")
+		if embed.Title != "🧾 コンビニ" || embed.Color != resultColorSuccess {
+			t.Errorf("title/color = %q/%#x", embed.Title, embed.Color)
+		}
+		if embed.Fields[0].Value != "31,828円" {
+			t.Errorf("amount field = %q, want 31,828円", embed.Fields[0].Value)
+		}
+		if !strings.Contains(embed.Footer.Text, "run-1") {
+			t.Errorf("footer = %q, want to contain workflow_run_id", embed.Footer.Text)
+		}
+		if embed.Thumbnail == nil || embed.Thumbnail.URL != "attachment://thumbnail_1.jpg" {
+			t.Errorf("thumbnail = %+v", embed.Thumbnail)
+		}
+	})
+
+	t.Run("Dify内部エラー", func(t *testing.T) {
+		embed := ReceiptResultEmbed(LangJapanese, "a.jpg", "", receiptOutcome{Stage: StageWarning, Message: "error"}, "")
+		if embed.Color != resultColorWarning || embed.Thumbnail != nil {
+			t.Errorf("color = %#x, thumbnail = %+v", embed.Color, embed.Thumbnail)
+		}
+	})
+
+	t.Run("失敗", func(t *testing.T) {
+		embed := ReceiptResultEmbed(LangJapanese, "a.jpg", "", receiptOutcome{Stage: StageFailed, Message: "圧縮に失敗しました"}, "")
+		if embed.Color != resultColorFailure || embed.Description != "圧縮に失敗しました" {
+			t.Errorf("color = %#x, description = %q", embed.Color, embed.Description)
+		}
+	})
+}
+
+// TestBatchReaction - 処理結果に応じたリアクションのテスト
+func TestBatchReaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure int
+		warning int
+		want    string
+	}{
+		{"全て成功", 0, 0, "✅"},
+		{"Dify内部エラーのみ", 0, 1, "⚠️"},
+		{"失敗あり", 1, 0, "❌"},
+		{"失敗とDify内部エラー", 1, 1, "❌"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchReaction(tt.failure, tt.warning); got != tt.want {
+				t.Errorf("batchReaction(%d, %d) = %q, want %q", tt.failure, tt.warning, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestJobStore - 処理履歴の保存・読み込み・再処理対象の取得のテスト
+func TestJobStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store := NewJobStore(path)
+
+	source := ReceiptSource{URL: "https://cdn.discordapp.com/1/a.jpg", Filename: "a.jpg"}
+	done := store.Create("ch", "msg1", "u1", "hoshi", source)
+	failed := store.Create("ch", "msg2", "u1", "hoshi", source)
+	warning := store.Create("ch", "msg3", "u1", "hoshi", source)
+	processing := store.Create("ch", "msg4", "u1", "hoshi", source)
+	store.Update(done.ID, JobStatusDone, "", nil)
+	store.Update(failed.ID, JobStatusFailed, "圧縮に失敗しました", nil)
+	store.Update(warning.ID, JobStatusWarning, "PluginDaemonInnerError", nil)
+
+	if len(failed.ID) != 8 {
+		t.Errorf("job ID = %q, want 8 characters", failed.ID)
+	}
+
+	t.Run("ファイルから読み込み", func(t *testing.T) {
+		loaded, err := LoadJobStore(path)
+		if err != nil {
+			t.Fatalf("LoadJobStore() error = %v", err)
+		}
+		job, ok := loaded.Get(failed.ID)
+		if !ok || job.Source.URL != source.URL || job.Error != "圧縮に失敗しました" {
+			t.Errorf("Get(%s) = %+v, %v", failed.ID, job, ok)
+		}
+		// 処理中のまま終了したジョブは失敗として読み込まれる
+		if job, _ := loaded.Get(processing.ID); job.Status != JobStatusFailed {
+			t.Errorf("processing job status = %q, want %q", job.Status, JobStatusFailed)
+		}
+	})
+
+	t.Run("再処理の対象", func(t *testing.T) {
+		jobs := store.FailedSince(time.Now().Add(-time.Hour))
+		if len(jobs) != 2 || jobs[0].ID != failed.ID || jobs[1].ID != warning.ID {
+			t.Errorf("FailedSince() = %+v", jobs)
+		}
+		if jobs := store.FailedSince(time.Now().Add(time.Hour)); len(jobs) != 0 {
+			t.Errorf("FailedSince(future) = %d jobs, want 0", len(jobs))
+		}
+	})
+
+	t.Run("再処理の開始", func(t *testing.T) {
+		job, ok := store.Claim(failed.ID)
+		if !ok || job.Status != JobStatusProcessing || job.Attempts != 2 {
+			t.Errorf("Claim() = %+v, %v", job, ok)
+		}
+		// 処理中・成功済みのジョブは再処理しない
+		if _, ok := store.Claim(failed.ID); ok {
+			t.Error("Claim() of processing job succeeded")
+		}
+		if _, ok := store.Claim(done.ID); ok {
+			t.Error("Claim() of done job succeeded")
+		}
+	})
+}
+
+// Discord APIへのリクエストを記録するテスト用のセッション
+type recordedRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+type recordingTransport struct {
+	requests  []recordedRequest
+	responses map[string]string // "METHOD パス" ごとのレスポンス（未設定なら "{}"）
+	statuses  map[string]int    // "METHOD パス" ごとのステータスコード（未設定なら200）
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	rt.requests = append(rt.requests, recordedRequest{Method: req.Method, Path: req.URL.Path, Body: string(body)})
+	response, ok := rt.responses[req.Method+" "+req.URL.Path]
+	if !ok {
+		response = "{}"
+	}
+	statusCode, ok := rt.statuses[req.Method+" "+req.URL.Path]
+	if !ok {
+		statusCode = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func newRecordingSession(t *testing.T) (*discordgo.Session, *recordingTransport) {
+	t.Helper()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("discordgo.New() error = %v", err)
+	}
+	transport := &recordingTransport{}
+	s.Client = &http.Client{Transport: transport}
+	return s, transport
+}
+
+func newTestInteraction(interactionType discordgo.InteractionType, data discordgo.InteractionData) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:     "1",
+		AppID:  "app",
+		Token:  "token",
+		Type:   interactionType,
+		Data:   data,
+		Member: &discordgo.Member{User: &discordgo.User{ID: "u1", Username: "hoshi"}},
+	}}
+}
+
+// TestRouter - インタラクションのルーティングと応答のテスト
+func TestRouter(t *testing.T) {
+	called := ""
+	r := NewRouter()
+	r.HandleCommand(&discordgo.ApplicationCommand{Name: "reply"}, func(c *InteractionContext) {
+		called = "reply"
+		c.Reply("1回目")
+		c.Reply("2回目")
+	}, RouteOptions{})
+	r.HandleCommand(&discordgo.ApplicationCommand{Name: "slow"}, func(c *InteractionContext) {
+		called = "slow"
+		c.Reply("完了")
+	}, RouteOptions{Defer: true, Ephemeral: true})
+	r.HandleCommand(&discordgo.ApplicationCommand{Name: "panic"}, func(c *InteractionContext) {
+		panic("テスト用のpanic")
+	}, RouteOptions{})
+	r.HandleComponent("btn:", func(c *InteractionContext) {
+		called = "button"
+		c.ReplyEphemeral("ok")
+	}, RouteOptions{})
+	r.HandleModal("form:", func(c *InteractionContext) {
+		called = "modal"
+		c.ReplyEphemeral("ok")
+	}, RouteOptions{})
+
+	tests := []struct {
+		name       string
+		i          *discordgo.InteractionCreate
+		wantCalled string
+		wantPaths  []string
+		wantBody   string
+	}{
+		{
+			name:       "2回目の応答はフォローアップになる",
+			i:          newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: "reply"}),
+			wantCalled: "reply",
+			wantPaths:  []string{"POST /api/v9/interactions/1/token/callback", "POST /api/v9/webhooks/app/token"},
+		},
+		{
+			name:       "遅延応答の後は元の応答を編集する",
+			i:          newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: "slow"}),
+			wantCalled: "slow",
+			wantPaths:  []string{"POST /api/v9/interactions/1/token/callback", "PATCH /api/v9/webhooks/app/token/messages/@original"},
+			wantBody:   `"type":5`,
+		},
+		{
+			name:      "panicしてもエラーを応答する",
+			i:         newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: "panic"}),
+			wantPaths: []string{"POST /api/v9/interactions/1/token/callback"},
+			wantBody:  "処理中にエラーが発生しました",
+		},
+		{
+			name:       "ボタンは接頭辞で判定する",
+			i:          newTestInteraction(discordgo.InteractionMessageComponent, discordgo.MessageComponentInteractionData{CustomID: "btn:123"}),
+			wantCalled: "button",
+			wantPaths:  []string{"POST /api/v9/interactions/1/token/callback"},
+		},
+		{
+			name:       "モーダルは接頭辞で判定する",
+			i:          newTestInteraction(discordgo.InteractionModalSubmit, discordgo.ModalSubmitInteractionData{CustomID: "form:abc"}),
+			wantCalled: "modal",
+			wantPaths:  []string{"POST /api/v9/interactions/1/token/callback"},
+		},
+		{
+			name:      "未登録のコマンド",
+			i:         newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: "unknown"}),
+			wantPaths: []string{"POST /api/v9/interactions/1/token/callback"},
+			wantBody:  "このコマンドは現在使用できません",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = ""
+			s, transport := newRecordingSession(t)
+			r.Handle(s, tt.i)
+
+			if called != tt.wantCalled {
+				t.Errorf("called = %q, want %q", called, tt.wantCalled)
+			}
+			var paths []string
+			for _, req := range transport.requests {
+				paths = append(paths, req.Method+" "+strings.SplitN(req.Path, "?", 2)[0])
+			}
+			if strings.Join(paths, ", ") != strings.Join(tt.wantPaths, ", ") {
+				t.Errorf("requests = %v, want %v", paths, tt.wantPaths)
+			}
+			if tt.wantBody != "" && (len(transport.requests) == 0 || !strings.Contains(transport.requests[0].Body, tt.wantBody)) {
+				t.Errorf("first request body does not contain %q: %+v", tt.wantBody, transport.requests)
+			}
+		})
+	}
+}
+
+// TestRouterCommands - 登録したコマンドの一覧のテスト
+func TestRouterCommands(t *testing.T) {
+	var names []string
+	for _, command := range router.Commands() {
+		names = append(names, command.Name)
+	}
+	for _, want := range []string{"hello", recordReceiptCommandName, "retry-failed"} {
+		if !strings.Contains(strings.Join(names, ","), want) {
+			t.Errorf("router.Commands() = %v, want to contain %q", names, want)
+		}
+	}
+}
+
+// TestDiffCommands - 登録済みコマンドとの差分のテスト
+func TestDiffCommands(t *testing.T) {
+	desired := []*discordgo.ApplicationCommand{
+		{Name: "hello", Description: "挨拶を返します"},
+		{Name: "retry-failed", Description: "再処理します"},
+		{Name: recordReceiptCommandName, Type: discordgo.MessageApplicationCommand},
+	}
+
+	tests := []struct {
+		name       string
+		registered []*discordgo.ApplicationCommand
+		want       CommandDiff
+	}{
+		{
+			name: "変更なし（IDなどDiscordが付ける値は無視する）",
+			registered: []*discordgo.ApplicationCommand{
+				{ID: "1", Version: "v1", Name: "hello", Type: discordgo.ChatApplicationCommand, Description: "挨拶を返します"},
+				{ID: "2", Name: "retry-failed", Type: discordgo.ChatApplicationCommand, Description: "再処理します"},
+				{ID: "3", Name: recordReceiptCommandName, Type: discordgo.MessageApplicationCommand, NameLocalizations: &map[discordgo.Locale]string{}},
+			},
+			want: CommandDiff{},
+		},
+		{
+			name: "追加・変更・削除",
+			registered: []*discordgo.ApplicationCommand{
+				{Name: "hello", Type: discordgo.ChatApplicationCommand, Description: "古い説明"},
+				{Name: "old", Type: discordgo.ChatApplicationCommand, Description: "不要なコマンド"},
+				{Name: recordReceiptCommandName, Type: discordgo.MessageApplicationCommand},
+			},
+			want: CommandDiff{Added: []string{"retry-failed"}, Changed: []string{"hello"}, Removed: []string{"old"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffCommands(tt.registered, desired)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("DiffCommands() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRegisterCommands - 変更がある場合だけ一括上書きするテスト
+func TestRegisterCommands(t *testing.T) {
+	desired := []*discordgo.ApplicationCommand{{Name: "hello", Description: "挨拶を返します"}}
+	const listPath = "GET /api/v9/applications/app/guilds/guild/commands"
+
+	tests := []struct {
+		name      string
+		current   string
+		wantPaths []string
+	}{
+		{"変更なしなら登録しない", `[{"id":"1","name":"hello","type":1,"description":"挨拶を返します"}]`, []string{listPath}},
+		{"変更があれば一括上書き", `[]`, []string{listPath, "PUT /api/v9/applications/app/guilds/guild/commands"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, transport := newRecordingSession(t)
+			transport.responses = map[string]string{"GET /api/v9/applications/app/guilds/guild/commands": tt.current, "PUT /api/v9/applications/app/guilds/guild/commands": "[]"}
+
+			if err := RegisterCommands(s, "app", "guild", desired); err != nil {
+				t.Fatalf("RegisterCommands() error = %v", err)
+			}
+			var paths []string
+			for _, req := range transport.requests {
+				paths = append(paths, req.Method+" "+req.Path)
+			}
+			if strings.Join(paths, ", ") != strings.Join(tt.wantPaths, ", ") {
+				t.Errorf("requests = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+// TestNormalizeCommandText - テキストコマンドの正規化のテスト
+func TestNormalizeCommandText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"そのまま", "!ping", "!ping"},
+		{"末尾の空白", "いくら ", "いくら"},
+		{"全角スペース", "　いくら　", "いくら"},
+		{"大文字", "!PING", "!ping"},
+		{"全角英字", "！ｗｈｏａｍｉ", "!whoami"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeCommandText(tt.input); got != tt.want {
+				t.Errorf("normalizeCommandText(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadTextAliases - テキストコマンドの別名の設定のテスト
+func TestLoadTextAliases(t *testing.T) {
+	t.Run("デフォルト", func(t *testing.T) {
+		t.Setenv("TEXT_COMMAND_ALIASES", "")
+		aliases := LoadTextAliases()
+		for alias, name := range map[string]string{"!ping": "ping", "!whoami": "whoami", "いくら": "amount"} {
+			if cmd, ok := aliases[alias]; !ok || cmd.Command.Name != name {
+				t.Errorf("aliases[%q] = %v, want /%s", alias, cmd, name)
+			}
+		}
+	})
+
+	t.Run("上書きと無効化", func(t *testing.T) {
+		t.Setenv("TEXT_COMMAND_ALIASES", "ping=!ping, !ピン ;amount=")
+		aliases := LoadTextAliases()
+		if cmd, ok := aliases["!ピン"]; !ok || cmd.Command.Name != "ping" {
+			t.Errorf("aliases[!ピン] = %v, want /ping", cmd)
+		}
+		if _, ok := aliases["いくら"]; ok {
+			t.Error("いくら should be disabled")
+		}
+		if _, ok := aliases["!whoami"]; !ok {
+			t.Error("!whoami should keep the default alias")
+		}
+	})
+}
+
+// TestTextAndSlashCommands - テキストコマンドとスラッシュコマンドが同じ処理を呼ぶテスト
+func TestTextAndSlashCommands(t *testing.T) {
+	t.Setenv("TEXT_COMMAND_ALIASES", "")
+
+	t.Run("テキストコマンド（大文字・空白あり）", func(t *testing.T) {
+		s, transport := newRecordingSession(t)
+		m := &discordgo.MessageCreate{Message: &discordgo.Message{ChannelID: "ch", Content: " !PING ", Author: &discordgo.User{ID: "u1"}}}
+		if !handleTextCommand(s, m) {
+			t.Fatal("handleTextCommand() = false, want true")
+		}
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, "Pong!") {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+	})
+
+	t.Run("テキストコマンド以外", func(t *testing.T) {
+		s, _ := newRecordingSession(t)
+		m := &discordgo.MessageCreate{Message: &discordgo.Message{ChannelID: "ch", Content: "こんにちは", Author: &discordgo.User{ID: "u1"}}}
+		if handleTextCommand(s, m) {
+			t.Error("handleTextCommand() = true, want false")
+		}
+	})
+
+	t.Run("/whoamiは本人にだけ表示", func(t *testing.T) {
+		s, transport := newRecordingSession(t)
+		router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: "whoami"}))
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, `"flags":64`) {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+	})
+}
+
+// TestMessageCatalog - メッセージカタログの全てのキーに日本語と英語があり、引数の数が同じかのテスト
+func TestMessageCatalog(t *testing.T) {
+	for key, translations := range messageCatalog {
+		ja, okJa := translations[LangJapanese]
+		en, okEn := translations[LangEnglish]
+		if !okJa || !okEn {
+			t.Errorf("%s: ja=%v, en=%v", key, okJa, okEn)
+			continue
+		}
+		if strings.Count(ja, "%") != strings.Count(en, "%") {
+			t.Errorf("%s: 引数の数が異なります ja=%q, en=%q", key, ja, en)
+		}
+	}
+}
+
+// TestResolveLang - メッセージの言語の優先順位のテスト
+func TestResolveLang(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultLang string
+		guildLangs  string
+		guildID     string
+		locale      discordgo.Locale
+		want        Lang
+	}{
+		{"設定なし", "", "", "g1", "", LangJapanese},
+		{"BOT_LANGUAGE", "en", "", "g1", "", LangEnglish},
+		{"GUILD_LANGUAGES", "ja", "g1:en,g2:ja", "g1", "", LangEnglish},
+		{"別のサーバー", "ja", "g1:en", "g2", "", LangJapanese},
+		{"ユーザーの言語設定を優先", "ja", "g1:ja", "g1", discordgo.EnglishUS, LangEnglish},
+		{"未対応の言語設定は無視", "en", "", "g1", discordgo.French, LangEnglish},
+		{"不正な設定は無視", "fr", "g1:xx", "g1", "", LangJapanese},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BOT_LANGUAGE", tt.defaultLang)
+			t.Setenv("GUILD_LANGUAGES", tt.guildLangs)
+			if got := ResolveLang(tt.guildID, tt.locale); got != tt.want {
+				t.Errorf("ResolveLang(%q, %q) = %q, want %q", tt.guildID, tt.locale, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLocalizeError - エラーメッセージの翻訳のテスト
+func TestLocalizeError(t *testing.T) {
+	err := fmt.Errorf("upload: %w", NewLocalizedError("download.status", 404))
+
+	if got := err.Error(); !strings.Contains(got, "404") {
+		t.Errorf("Error() = %q, want to contain 404", got)
+	}
+	if got, want := LocalizeError(LangEnglish, err), T(LangEnglish, "download.status", 404); got != want {
+		t.Errorf("LocalizeError(en) = %q, want %q", got, want)
+	}
+	if got := LocalizeError(LangEnglish, fmt.Errorf("plain")); got != "plain" {
+		t.Errorf("LocalizeError(plain) = %q", got)
+	}
+
+	// 引数のエラーもメッセージと同じ言語で表示する
+	got := T(LangEnglish, "receipt.upload_failed", NewLocalizedError("dify.api_key_missing"))
+	want := fmt.Sprintf("Upload to Dify failed: %s", T(LangEnglish, "dify.api_key_missing"))
+	if got != want {
+		t.Errorf("T() = %q, want %q", got, want)
+	}
+
+	if got := T(LangEnglish, "no.such.key"); got != "no.such.key" {
+		t.Errorf("T(unknown) = %q", got)
+	}
+
+	// 引数のエラーをerrors.Is・errors.Asで確認できる
+	cause := &url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}
+	wrapped := fmt.Errorf("download: %w", NewLocalizedError("download.error", cause))
+	if !errors.Is(wrapped, context.DeadlineExceeded) {
+		t.Errorf("errors.Is(%v, DeadlineExceeded) = false", wrapped)
+	}
+	var urlErr *url.Error
+	if !errors.As(wrapped, &urlErr) || urlErr != cause {
+		t.Errorf("errors.As(*url.Error) = %v", urlErr)
+	}
+	if errors.Unwrap(NewLocalizedError("download.status", 404)) != nil {
+		t.Error("Unwrap() without an error argument != nil")
+	}
+}
+
+// TestEnglishMessages - 英語のステータス・結果メッセージのテスト
+func TestEnglishMessages(t *testing.T) {
+	b := &BatchStatus{lang: LangEnglish, color: statusColorRunning, items: []statusItem{{Name: "a.jpg"}}}
+	b.Set(0, StageWorkflow, "")
+	if embed := b.embed(); embed.Title != "🖼️ Processing 1 image(s)..." || !strings.Contains(embed.Description, "🤖 Analyzing — **a.jpg**") {
+		t.Errorf("embed = %q / %q", embed.Title, embed.Description)
+	}
+	b.Finish(0, 1)
+	if embed := b.embed(); !strings.Contains(embed.Description, "❌ Failed: 1") {
+		t.Errorf("description = %q", embed.Description)
+	}
+
+	result := &ReceiptResult{Parsed: true, Amount: Money{Minor: 500, Currency: "JPY"}}
+	embed := ReceiptResultEmbed(LangEnglish, "a.jpg", "", receiptOutcome{Stage: StageDone, Result: result}, "")
+	if embed.Title != "🧾 Receipt" || embed.Fields[0].Name != "💰 Amount" {
+		t.Errorf("title/field = %q/%q", embed.Title, embed.Fields[0].Name)
+	}
+}
+
+// TestNormalizeCurrency - 通貨コード・通貨記号の正規化のテスト
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{"usd", "USD", true},
+		{" EUR ", "EUR", true},
+		{"$", "USD", true},
+		{"円", "JPY", true},
+		{"₩", "KRW", true},
+		{"US", "", false},
+		{"U$D", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := NormalizeCurrency(tt.input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("NormalizeCurrency(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestParseMoney - 金額の読み取りのテスト
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Money
+		wantErr bool
+	}{
+		{"数字のみ", "1234", Money{1234, "JPY"}, false},
+		{"円記号とカンマ", "¥1,234", Money{1234, "JPY"}, false},
+		{"円", "1234円", Money{1234, "JPY"}, false},
+		{"全角", "￥１，２３４", Money{1234, "JPY"}, false},
+		{"マイナス", "-500", Money{-500, "JPY"}, false},
+		{"円記号の後ろのマイナス", "¥-500", Money{-500, "JPY"}, false},
+		{"全角マイナス", "−５００円", Money{-500, "JPY"}, false},
+		{"三角", "△500", Money{-500, "JPY"}, false},
+		{"かっこ", "(500)", Money{-500, "JPY"}, false},
+		{"ドル", "$12.5", Money{1250, "USD"}, false},
+		{"通貨コード", "12.50 USD", Money{1250, "USD"}, false},
+		{"通貨コードが前", "EUR 1,000.99", Money{100099, "EUR"}, false},
+		{"ウォン", "₩15,000", Money{15000, "KRW"}, false},
+		{"小数の四捨五入", "1234.5", Money{1235, "JPY"}, false},
+		{"前後の空白", "　1,234 ", Money{1234, "JPY"}, false},
+		{"文字混入", "12a34", Money{}, true},
+		{"カンマの位置", "12,34", Money{}, true},
+		{"空", "", Money{}, true},
+		{"二重のマイナス", "--5", Money{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.input, "JPY")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseMoney(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseMoneyAmbiguousAlias - "US$" と "$" のように重なる通貨記号を毎回同じように読み取るテスト
+func TestParseMoneyAmbiguousAlias(t *testing.T) {
+	for i := 1; i < len(currencyAliases); i++ {
+		if len(currencyAliases[i-1].alias) < len(currencyAliases[i].alias) {
+			t.Errorf("currencyAliases[%d] %q is shorter than %q", i-1, currencyAliases[i-1].alias, currencyAliases[i].alias)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  Money
+	}{
+		{"US$が後ろ", "12 US$", Money{1200, "USD"}},
+		{"US$が前", "US$12.50", Money{1250, "USD"}},
+		{"US$の後ろに空白", "US$ 1,000", Money{100000, "USD"}},
+		{"$が後ろ", "12$", Money{1200, "USD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 探す順番が実行ごとに変わらないことを確かめるため、何度も読み取る
+			for i := 0; i < 100; i++ {
+				got, err := ParseMoney(tt.input, "JPY")
+				if err != nil || got != tt.want {
+					t.Fatalf("ParseMoney(%q) = %+v, %v, want %+v", tt.input, got, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestMoneyFormat - 言語ごとの金額表記のテスト
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		money Money
+		ja    string
+		en    string
+	}{
+		{"円", Money{31828, "JPY"}, "31,828円", "¥31,828"},
+		{"マイナスの円", Money{-1234, "JPY"}, "-1,234円", "-¥1,234"},
+		{"ドル", Money{123450, "USD"}, "1,234.50 USD", "$1,234.50"},
+		{"1未満", Money{5, "EUR"}, "0.05 EUR", "€0.05"},
+		{"ウォン", Money{15000, "KRW"}, "15,000 KRW", "₩15,000"},
+		{"記号のない通貨", Money{-1230, "CHF"}, "-12.30 CHF", "-12.30 CHF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.money.Format(LangJapanese); got != tt.ja {
+				t.Errorf("Format(ja) = %q, want %q", got, tt.ja)
+			}
+			if got := tt.money.Format(LangEnglish); got != tt.en {
+				t.Errorf("Format(en) = %q, want %q", got, tt.en)
+			}
+		})
+	}
+}
+
+// TestMoneyCalculation - 金額の計算（誤差が出ないこと）のテスト
+func TestMoneyCalculation(t *testing.T) {
+	// 0.1 + 0.2 が 0.3 になる
+	sum, ok := Money{10, "USD"}.Add(Money{20, "USD"})
+	if !ok || sum != (Money{30, "USD"}) {
+		t.Errorf("Add() = %+v, %v", sum, ok)
+	}
+	if _, ok := (Money{10, "USD"}).Add(Money{10, "JPY"}); ok {
+		t.Error("Add(別の通貨) ok = true, want false")
+	}
+
+	tests := []struct {
+		name  string
+		money Money
+		rate  float64
+		want  Money
+	}{
+		{"ドルから円", Money{1005, "USD"}, 150.1, Money{1509, "JPY"}},
+		{"四捨五入", Money{150, "USD"}, 150.5, Money{226, "JPY"}},
+		{"マイナス", Money{-150, "USD"}, 150.5, Money{-226, "JPY"}},
+		{"円からドル", Money{1000, "JPY"}, 0.0067, Money{670, "USD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.money.Convert(tt.rate, tt.want.Currency); got != tt.want {
+				t.Errorf("Convert(%v) = %+v, want %+v", tt.rate, got, tt.want)
+			}
+		})
+	}
+
+	// 数値だけで保存された古い処理履歴も読める
+	var legacy ReceiptResult
+	if err := json.Unmarshal([]byte(`{"amount": 1280, "base_amount": {"minor": 1280, "currency": "JPY"}}`), &legacy); err != nil || legacy.Amount != (Money{1280, "JPY"}) {
+		t.Errorf("Unmarshal() = %+v, %v", legacy.Amount, err)
+	}
+}
+
+// TestFormatReportLine - いくらの記録の言語ごとの表記のテスト
+func TestFormatReportLine(t *testing.T) {
+	t.Setenv("BASE_CURRENCY", "")
+	if got := FormatReportLine(LangJapanese, "食費：31828"); got != "食費：31,828" {
+		t.Errorf("FormatReportLine(ja) = %q", got)
+	}
+	if got := FormatReportLine(LangEnglish, "食費：-31828"); got != "食費: -¥31,828" {
+		t.Errorf("FormatReportLine(en) = %q", got)
+	}
+	if got := FormatReportLine(LangEnglish, "合計"); got != "合計" {
+		t.Errorf("FormatReportLine(en) = %q", got)
+	}
+}
+
+// TestRateTable - 為替レート表の読み込み・保存・換算のテスト
+func TestRateTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(`{"usd": 150.5, "KRW": 0.11, "XX": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadRateTable(path)
+	if err != nil {
+		t.Fatalf("LoadRateTable() error = %v", err)
+	}
+	if rates := table.List(); len(rates) != 2 || rates[0].Currency != "KRW" || rates[1].Currency != "USD" {
+		t.Fatalf("List() = %+v", rates)
+	}
+
+	if converted, rate, ok := table.Convert(Money{Minor: 1234, Currency: "USD"}, "JPY"); !ok || rate != 150.5 || converted != (Money{Minor: 1857, Currency: "JPY"}) {
+		t.Errorf("Convert(USD) = %v, %v, %v", converted, rate, ok)
+	}
+	if converted, rate, ok := table.Convert(Money{Minor: 500, Currency: "JPY"}, "JPY"); !ok || rate != 1 || converted.Minor != 500 {
+		t.Errorf("Convert(JPY) = %v, %v, %v", converted, rate, ok)
+	}
+	if _, _, ok := table.Convert(Money{Minor: 1000, Currency: "EUR"}, "JPY"); ok {
+		t.Error("Convert(EUR) ok = true, want false")
+	}
+
+	// /rate set で設定したレートはファイルに保存され、再起動後も使える
+	if _, err := table.Set("EUR", 162, "hoshi"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	reloaded, err := LoadRateTable(path)
+	if err != nil {
+		t.Fatalf("LoadRateTable() error = %v", err)
+	}
+	if rate, ok := reloaded.Get("EUR"); !ok || rate.Rate != 162 || rate.UpdatedBy != "hoshi" {
+		t.Errorf("Get(EUR) = %+v, %v", rate, ok)
+	}
+
+	// 保存できない場合はエラーを返し、設定を元に戻す
+	broken := NewRateTable(unwritablePath(t, "rates.json"))
+	if _, err := broken.Set("EUR", 162, "hoshi"); err == nil {
+		t.Error("Set() error = nil, want save error")
+	}
+	if rate, ok := broken.Get("EUR"); ok {
+		t.Errorf("Get(EUR) after failed save = %+v, want none", rate)
+	}
+}
+
+// unwritablePath - 保存先のディレクトリを作れないパス（テスト用）
+func unwritablePath(t *testing.T, name string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(file, name)
+}
+
+// TestReceiptResultCurrency - 外貨のレシートの換算と表示のテスト
+func TestReceiptResultCurrency(t *testing.T) {
+	output := `{"insertedData":{"store":"Cafe","amount":"1,234.5","currency":"usd"}}`
+	body, _ := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"outputs": map[string]interface{}{"output": []string{output}}}})
+	rates := NewRateTable("")
+	rates.Set("USD", 150, "")
+
+	t.Run("換算できる", func(t *testing.T) {
+		result := ParseReceiptResult(string(body))
+		result.ApplyRate(rates, "JPY")
+		if result.Amount != (Money{Minor: 123450, Currency: "USD"}) || result.BaseAmount != (Money{Minor: 185175, Currency: "JPY"}) {
+			t.Fatalf("result = %+v", result)
+		}
+		if got := result.DisplayAmount(LangJapanese); got != "185,175円" {
+			t.Errorf("DisplayAmount() = %q", got)
+		}
+		if got := result.AmountText(LangJapanese); got != "1,234.50 USD\n≒ 185,175円（1 USD = 150 JPY）" {
+			t.Errorf("AmountText() = %q", got)
+		}
+	})
+
+	t.Run("Difyが記録した外貨の注意", func(t *testing.T) {
+		result := ParseReceiptResult(string(body))
+		result.ApplyRate(rates, "JPY")
+		hasNote := func(outcome receiptOutcome) bool {
+			for _, field := range ReceiptResultEmbed(LangJapanese, "a.jpg", "", outcome, "").Fields {
+				if strings.Contains(field.Value, "LEDGER_WRITER=bot") {
+					return true
+				}
+			}
+			return false
+		}
+		if !hasNote(receiptOutcome{Stage: StageDone, Result: &result, DifyLedger: true}) {
+			t.Error("Difyが記録した外貨のレシートに注意がない")
+		}
+		if hasNote(receiptOutcome{Stage: StageDone, Result: &result}) {
+			t.Error("Botが記録した外貨のレシートに注意がある")
+		}
+		yen := ReceiptResult{Parsed: true, Amount: Money{Minor: 500, Currency: "JPY"}, BaseAmount: Money{Minor: 500, Currency: "JPY"}}
+		if hasNote(receiptOutcome{Stage: StageDone, Result: &yen, DifyLedger: true}) {
+			t.Error("基準通貨のレシートに注意がある")
+		}
+	})
+
+	t.Run("レート未設定", func(t *testing.T) {
+		result := ParseReceiptResult(string(body))
+		result.ApplyRate(NewRateTable(""), "JPY")
+		if result.Rate != 0 || result.DisplayAmount(LangJapanese) != "1,234.50 USD" {
+			t.Errorf("result = %+v", result)
+		}
+		if got := result.AmountText(LangEnglish); !strings.Contains(got, "No exchange rate is set for USD") {
+			t.Errorf("AmountText() = %q", got)
+		}
+	})
+
+	t.Run("通貨なしは基準通貨", func(t *testing.T) {
+		result := ReceiptResult{Parsed: true, Amount: Money{Minor: 500, Currency: "JPY"}}
+		result.ApplyRate(rates, "JPY")
+		if result.Foreign() || result.BaseAmount.Minor != 500 || result.AmountText(LangJapanese) != "500円" {
+			t.Errorf("result = %+v", result)
+		}
+	})
+}
+
+// TestRateCommand - /rate set・/rates のテスト
+func TestRateCommand(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BASE_CURRENCY", "")
+	original := exchangeRates
+	exchangeRates = NewRateTable("")
+	defer func() { exchangeRates = original }()
+
+	rateSet := func(currency string, rate float64) discordgo.ApplicationCommandInteractionData {
+		return discordgo.ApplicationCommandInteractionData{Name: "rate", Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+			Name: "set",
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "currency", Type: discordgo.ApplicationCommandOptionString, Value: currency},
+				{Name: "rate", Type: discordgo.ApplicationCommandOptionNumber, Value: rate},
+			},
+		}}}
+	}
+
+	tests := []struct {
+		name     string
+		data     discordgo.ApplicationCommandInteractionData
+		wantBody string
+	}{
+		{"設定", rateSet("usd", 150.5), "1 USD = 150.5 JPY"},
+		{"不正な通貨", rateSet("dollar", 150), "通貨コードが不正です"},
+		{"基準通貨", rateSet("JPY", 1), "基準通貨のため"},
+		{"一覧", discordgo.ApplicationCommandInteractionData{Name: "rates"}, "1 USD = 150.5 JPY"},
+	}
+
+	// レートの設定は管理権限を持つメンバーに限り、表示は誰でもできる
+	if rateCommand.DefaultMemberPermissions == nil || *rateCommand.DefaultMemberPermissions != discordgo.PermissionManageGuild {
+		t.Errorf("rate DefaultMemberPermissions = %v, want ManageGuild", rateCommand.DefaultMemberPermissions)
+	}
+	if ratesCommand.DefaultMemberPermissions != nil {
+		t.Errorf("rates DefaultMemberPermissions = %v, want nil", *ratesCommand.DefaultMemberPermissions)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, transport := newRecordingSession(t)
+			router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, tt.data))
+			if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, tt.wantBody) {
+				t.Errorf("requests = %+v, want body containing %q", transport.requests, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("保存できない", func(t *testing.T) {
+		exchangeRates = NewRateTable(unwritablePath(t, "rates.json"))
+		s, transport := newRecordingSession(t)
+		router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, rateSet("eur", 162)))
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, "保存できなかったため") {
+			t.Errorf("requests = %+v, want save error", transport.requests)
+		}
+		if rate, ok := exchangeRates.Get("EUR"); ok {
+			t.Errorf("Get(EUR) = %+v, want none", rate)
+		}
+	})
+}
+
+// newDoneJob - 記録済みのジョブを作成する（テスト用）
+func newDoneJob(store *JobStore, createdAt time.Time, result ReceiptResult) string {
+	job := store.Create("ch", "msg", "u1", "hoshi", ReceiptSource{Filename: "a.jpg"})
+	result.Parsed = true
+	store.Update(job.ID, JobStatusDone, "", &result)
+	store.jobs[job.ID].CreatedAt = createdAt
+	return job.ID
+}
+
+// TestReceiptLedgerEntry - 家計簿に記録する1行（基準通貨の金額と外貨の元の金額）のテスト
+func TestReceiptLedgerEntry(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	created := time.Date(2025, time.January, 10, 12, 0, 0, 0, loc)
+	job := func(result ReceiptResult) ReceiptJob {
+		result.Parsed = true
+		return ReceiptJob{ID: "j1", UserID: "u1", Username: "hoshi", CreatedAt: created, Result: &result}
+	}
+
+	tests := []struct {
+		name    string
+		job     ReceiptJob
+		want    LedgerEntry
+		wantErr string
+	}{
+		{
+			"円のレシート",
+			job(ReceiptResult{Store: "スーパー", Item: "食材", Category: "食費", Date: "2025/01/05", Amount: Money{Minor: 1280, Currency: "JPY"}, BaseAmount: Money{Minor: 1280, Currency: "JPY"}, Rate: 1}),
+			LedgerEntry{Date: "2025/01/05", Store: "スーパー", Item: "食材", Category: "食費", Amount: 1280, Payer: "S", ID: "j1"},
+			"",
+		},
+		{
+			"外貨は換算した金額と元の金額",
+			job(ReceiptResult{Store: "Cafe", Date: "2025-01-06", Payer: "Y", Amount: Money{Minor: 1250, Currency: "USD"}, BaseAmount: Money{Minor: 1875, Currency: "JPY"}, Rate: 150}),
+			LedgerEntry{Date: "2025/01/06", Store: "Cafe", Amount: 1875, Payer: "Y", Currency: "USD", OriginalAmount: 12.5, Rate: 150, ID: "j1"},
+			"",
+		},
+		{
+			"日付がなければ処理した日",
+			job(ReceiptResult{Store: "コンビニ", Amount: Money{Minor: 300, Currency: "JPY"}}),
+			LedgerEntry{Date: "2025/01/10", Store: "コンビニ", Amount: 300, Payer: "S", ID: "j1"},
+			"",
+		},
+		{
+			"返品・返金は元の記録に紐付けたマイナスの金額",
+			job(ReceiptResult{Store: "スーパー", Date: "2025/01/07", Amount: Money{Minor: -1280, Currency: "JPY"}, BaseAmount: Money{Minor: -1280, Currency: "JPY"}, Rate: 1, Refund: true, RefundOf: "j0"}),
+			LedgerEntry{Date: "2025/01/07", Store: "スーパー", Amount: -1280, Payer: "S", ID: "j1", Refund: true, RefundOf: "j0"},
+			"",
+		},
+		{
+			"レートが未設定の外貨",
+			job(ReceiptResult{Store: "Cafe", Amount: Money{Minor: 1250, Currency: "USD"}, BaseAmount: Money{Currency: "JPY"}}),
+			LedgerEntry{},
+			"USD の為替レートが未設定",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.job.LedgerEntry("JPY", loc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(LocalizeError(LangJapanese, err), tt.wantErr) {
+					t.Errorf("LedgerEntry() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("LedgerEntry() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestProcessReceiptRecordsLedger - Difyの結果を換算してからBotが家計簿に記録するテスト（LEDGER_WRITER=bot）
+func TestProcessReceiptRecordsLedger(t *testing.T) {
+	t.Setenv("LEDGER_WRITER", "bot")
+	t.Setenv("RECEIPT_THREADS", "false")
+	t.Setenv("RECEIPT_REACTIONS", "false")
+	t.Setenv("BASE_CURRENCY", "")
+	t.Setenv("DIFY_API_KEY", "test")
+
+	var receipt bytes.Buffer
+	if err := jpeg.Encode(&receipt, makeNoiseImage(40, 40), nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(receipt.Bytes())
+	}))
+	defer images.Close()
+
+	workflowRuns := 0
+	output, _ := json.Marshal(map[string]interface{}{"insertedData": map[string]interface{}{"store": "Cafe", "amount": 12.5, "currency": "USD", "category": "食費", "date": "2025/01/05"}})
+	dify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/upload":
+			w.Write([]byte(`{"id":"f1"}`))
+		case "/workflows/run":
+			workflowRuns++
+			body, _ := json.Marshal(map[string]interface{}{"workflow_run_id": "run1", "data": map[string]interface{}{"outputs": map[string]interface{}{"output": []string{string(output)}}}})
+			w.Write(body)
+		}
+	}))
+	defer dify.Close()
+	t.Setenv("DIFY_ENDPOINT", dify.URL)
+
+	var appended []map[string]interface{}
+	gasStatus := "success"
+	gas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		appended = append(appended, request)
+		w.Write([]byte(`{"status":"` + gasStatus + `","message":"シートがありません"}`))
+	}))
+	defer gas.Close()
+	t.Setenv("GAS_ENDPOINT", gas.URL)
+
+	originalJobs, originalRates := receiptJobs, exchangeRates
+	defer func() { receiptJobs, exchangeRates = originalJobs, originalRates }()
+
+	run := func(rate float64) ReceiptJob {
+		appended = nil
+		workflowRuns = 0
+		receiptJobs = NewJobStore("")
+		exchangeRates = NewRateTable("")
+		if rate > 0 {
+			exchangeRates.Set("USD", rate, "u1")
+		}
+		s, _ := newRecordingSession(t)
+		origin := &discordgo.Message{ID: "m1", ChannelID: "c1"}
+		processReceipts(s, LangJapanese, origin, &discordgo.User{ID: "u1", Username: "hoshi"}, []ReceiptSource{{URL: images.URL + "/receipt.jpg", Filename: "receipt.jpg", ContentType: "image/jpeg"}})
+		for _, job := range receiptJobs.jobs {
+			return *job
+		}
+		t.Fatal("処理履歴がありません")
+		return ReceiptJob{}
+	}
+
+	t.Run("換算した金額と元の金額を記録", func(t *testing.T) {
+		job := run(150)
+		if job.Status != JobStatusDone {
+			t.Fatalf("Status = %s (%s), want done", job.Status, job.Error)
+		}
+		if len(appended) != 1 {
+			t.Fatalf("appended = %v, want 1 request", appended)
+		}
+		got := appended[0]
+		if got["action"] != "append_entry" || got["id"] != job.ID || got["amount"] != 1875.0 || got["currency"] != "USD" || got["original_amount"] != 12.5 || got["rate"] != 150.0 || got["date"] != "2025/01/05" || got["payer"] != "S" {
+			t.Errorf("append_entry = %v", got)
+		}
+	})
+
+	// 再処理ボタンと同じように、失敗したジョブを再処理する
+	retry := func(job ReceiptJob) ReceiptJob {
+		claimed, ok := receiptJobs.Claim(job.ID)
+		if !ok {
+			t.Fatalf("Claim(%s) = false, status %s", job.ID, job.Status)
+		}
+		s, _ := newRecordingSession(t)
+		processReceiptJobs(s, LangJapanese, &discordgo.Message{ChannelID: "c1"}, &discordgo.User{ID: "u1", Username: "hoshi"}, []ReceiptJob{claimed})
+		retried, _ := receiptJobs.Get(job.ID)
+		return retried
+	}
+
+	t.Run("レートが未設定なら記録せずに失敗し、設定後の再処理で記録だけやり直す", func(t *testing.T) {
+		job := run(0)
+		if job.Status != JobStatusLedgerFailed || !strings.Contains(job.Error, "USD の為替レートが未設定") {
+			t.Errorf("Status = %s, Error = %q, want ledger_failed with a rate hint", job.Status, job.Error)
+		}
+		if len(appended) != 0 {
+			t.Errorf("appended = %v, want none", appended)
+		}
+
+		exchangeRates.Set("USD", 150, "u1")
+		workflowRuns = 0
+		job = retry(job)
+		if job.Status != JobStatusDone || workflowRuns != 0 {
+			t.Errorf("retry: Status = %s, workflow runs = %d, want done without running Dify", job.Status, workflowRuns)
+		}
+		if len(appended) != 1 || appended[0]["amount"] != 1875.0 {
+			t.Errorf("retry: appended = %v, want 1875 JPY", appended)
+		}
+	})
+
+	t.Run("家計簿への記録に失敗したら、Difyを実行し直さずに記録だけやり直す", func(t *testing.T) {
+		gasStatus = "error"
+		job := run(150)
+		gasStatus = "success"
+		if job.Status != JobStatusLedgerFailed || !strings.Contains(job.Error, "家計簿への記録に失敗しました") || job.Result == nil {
+			t.Fatalf("Status = %s, Error = %q, Result = %v", job.Status, job.Error, job.Result)
+		}
+
+		appended = nil
+		workflowRuns = 0
+		job = retry(job)
+		if job.Status != JobStatusDone || workflowRuns != 0 || len(appended) != 1 {
+			t.Errorf("retry: Status = %s, workflow runs = %d, appended = %d, want done with one entry and no workflow run", job.Status, workflowRuns, len(appended))
+		}
+	})
+
+	t.Run("デフォルトではDifyのワークフローが記録するためBotは記録しない", func(t *testing.T) {
+		t.Setenv("LEDGER_WRITER", "")
+		job := run(0)
+		if job.Status != JobStatusDone || workflowRuns != 1 || len(appended) != 0 {
+			t.Errorf("Status = %s, workflow runs = %d, appended = %v, want done without append_entry", job.Status, workflowRuns, appended)
+		}
+	})
+}
+
+// TestRefundDetection - 返品・返金のレシートの判定のテスト
+func TestRefundDetection(t *testing.T) {
+	t.Setenv("REFUND_KEYWORDS", "")
+	tests := []struct {
+		name       string
+		inserted   string
+		wantRefund bool
+		wantMinor  int64
+	}{
+		{"通常の購入", `{"store":"スーパー","item":"食材","amount":1280}`, false, 1280},
+		{"書類の種類が返品", `{"store":"スーパー","item":"食材","document_type":"返品レシート","amount":1280}`, true, -1280},
+		{"タイトルが返金", `{"store":"スーパー","title":"返金伝票","amount":"¥1,280"}`, true, -1280},
+		{"注意書きの返品不可", `{"store":"スーパー","item":"食材","note":"返品不可","amount":1280}`, false, 1280},
+		{"項目の返品不可", `{"store":"スーパー","item":"セール品（返品不可）","amount":1280}`, false, 1280},
+		{"返品・交換の案内", `{"store":"スーパー","item":"食材","category":"食費","message":"返品・交換はレシート持参で","amount":1280}`, false, 1280},
+		{"refundフラグ", `{"store":"スーパー","amount":500,"refund":true}`, true, -500},
+		{"マイナスの金額", `{"store":"スーパー","amount":"-500円"}`, true, -500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, _ := json.Marshal(map[string]json.RawMessage{"insertedData": json.RawMessage(tt.inserted)})
+			body, _ := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"outputs": map[string]interface{}{"output": []string{string(output)}}}})
+			got := ParseReceiptResult(string(body))
+			if got.Refund != tt.wantRefund || got.Amount.Minor != tt.wantMinor {
+				t.Errorf("Refund = %v, Amount = %+v, want %v, %d", got.Refund, got.Amount, tt.wantRefund, tt.wantMinor)
+			}
+		})
+	}
+}
+
+// TestRefundLink - 返品・返金と元の記録の紐付けのテスト
+func TestRefundLink(t *testing.T) {
+	now := time.Now()
+	store := NewJobStore("")
+	yen := func(minor int64) Money { return Money{Minor: minor, Currency: "JPY"} }
+
+	oldMatch := newDoneJob(store, now.Add(-3*time.Hour), ReceiptResult{Store: "スーパー", Item: "食材", Amount: yen(1280)})
+	newMatch := newDoneJob(store, now.Add(-2*time.Hour), ReceiptResult{Store: "スーパー", Item: "食材", Amount: yen(1280)})
+	sameItem := newDoneJob(store, now.Add(-time.Hour), ReceiptResult{Store: "スーパー", Item: "洗剤", Amount: yen(500)})
+	otherStore := newDoneJob(store, now.Add(-time.Hour), ReceiptResult{Store: "コンビニ", Item: "食材", Amount: yen(1280)})
+
+	refund := func(result ReceiptResult) string {
+		result.MarkRefund()
+		return newDoneJob(store, now, result)
+	}
+
+	t.Run("金額が一致する新しい記録", func(t *testing.T) {
+		job, ok := store.LinkRefund(refund(ReceiptResult{Store: "スーパー", Amount: yen(1280)}))
+		if !ok || job.Result.RefundOf != newMatch {
+			t.Errorf("RefundOf = %q, want %q", job.Result.RefundOf, newMatch)
+		}
+	})
+
+	t.Run("紐付け済みの記録は使わない", func(t *testing.T) {
+		job, _ := store.LinkRefund(refund(ReceiptResult{Store: "スーパー", Amount: yen(1280)}))
+		if job.Result.RefundOf != oldMatch {
+			t.Errorf("RefundOf = %q, want %q", job.Result.RefundOf, oldMatch)
+		}
+	})
+
+	t.Run("一部の返品は項目で探す", func(t *testing.T) {
+		job, _ := store.LinkRefund(refund(ReceiptResult{Store: "スーパー", Item: "洗剤", Amount: yen(200)}))
+		if job.Result.RefundOf != sameItem {
+			t.Errorf("RefundOf = %q, want %q", job.Result.RefundOf, sameItem)
+		}
+	})
+
+	t.Run("見つからない", func(t *testing.T) {
+		job, _ := store.LinkRefund(refund(ReceiptResult{Store: "ドラッグストア", Amount: yen(1280)}))
+		if job.Result.RefundOf != "" {
+			t.Errorf("RefundOf = %q, want empty", job.Result.RefundOf)
+		}
+	})
+
+	t.Run("ボタンで返品・返金にする", func(t *testing.T) {
+		purchase := newDoneJob(store, now, ReceiptResult{Store: "コンビニ", Item: "返品する商品", Amount: yen(1280)})
+		job, ok, err := store.MarkRefund(purchase)
+		if !ok || err != nil || !job.Result.Refund || job.Result.Amount.Minor != -1280 || job.Result.RefundOf != otherStore {
+			t.Errorf("MarkRefund() = %+v, %v, %v", job.Result, ok, err)
+		}
+		if _, ok, _ := store.MarkRefund(purchase); ok {
+			t.Error("MarkRefund() 2回目 ok = true, want false")
+		}
+	})
+
+	t.Run("保存できない場合は変更しない", func(t *testing.T) {
+		purchase := newDoneJob(store, now, ReceiptResult{Store: "書店", Amount: yen(800)})
+		store.path = unwritablePath(t, "jobs.json")
+		defer func() { store.path = "" }()
+		if _, ok, err := store.MarkRefund(purchase); !ok || err == nil {
+			t.Errorf("MarkRefund() = %v, %v, want save error", ok, err)
+		}
+		if job, _ := store.Get(purchase); job.Result.Refund || job.Result.Amount.Minor != 800 {
+			t.Errorf("Result = %+v, want unchanged", job.Result)
+		}
+	})
+}
+
+// TestAmountCommandRefunds - いくらで家計簿の返品・返金を差し引いた合計を表示するテスト
+func TestAmountCommandRefunds(t *testing.T) {
+	t.Setenv("BASE_CURRENCY", "")
+	entries := ""
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		requested = append(requested, request["action"])
+		if request["action"] == "get_entries" {
+			w.Write([]byte(`{"status":"success","data":[` + entries + `]}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","currentMonth":"2025年1月","data":["食費：1800"]}`))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	tests := []struct {
+		name    string
+		entries string
+		want    string
+	}{
+		{
+			"返品・返金あり",
+			`{"date":"2025/01/05","store":"スーパー","category":"食費","amount":3000,"id":"j0"},` +
+				`{"date":"2025/01/07","store":"スーパー","category":"食費","amount":-1200,"id":"j1","refund":true,"refund_of":"j0"}`,
+			"↩️ 今月の返品・返金: -1,200円（1件）\n🧾 返品・返金を差し引いた今月の合計: 1,800円",
+		},
+		{
+			"購入として記録した返品・返金をボタンで直した",
+			`{"date":"2025/01/05","store":"スーパー","category":"食費","amount":3000,"id":"j0"},` +
+				`{"date":"2025/01/07","store":"スーパー","category":"食費","amount":1200,"id":"j1"},` +
+				`{"date":"2025/01/07","store":"スーパー","category":"食費","amount":-1200,"id":"j1","cancels":"j1"},` +
+				`{"date":"2025/01/07","store":"スーパー","category":"食費","amount":-1200,"id":"j1","refund":true,"refund_of":"j0"}`,
+			"↩️ 今月の返品・返金: -1,200円（1件）\n🧾 返品・返金を差し引いた今月の合計: 1,800円",
+		},
+		{"返品・返金なし", `{"date":"2025/01/05","store":"スーパー","category":"食費","amount":1800}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, requested = tt.entries, nil
+			got := runAmountCommand(commandRequest{User: &discordgo.User{ID: "u1"}, Lang: LangJapanese})
+			if strings.Join(requested, ",") != "get_latest_amount,get_entries" {
+				t.Errorf("actions = %v", requested)
+			}
+			if tt.want != "" && !strings.HasSuffix(got, tt.want) {
+				t.Errorf("runAmountCommand() = %q, want suffix %q", got, tt.want)
+			}
+			if tt.want == "" && strings.Contains(got, "返品・返金") {
+				t.Errorf("runAmountCommand() = %q, want no refund line", got)
+			}
+		})
+	}
+}
+
+// TestRefundButton - 「返品・返金」ボタンで家計簿に打ち消す行と返品・返金の行を追加するテスト
+func TestRefundButton(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	original := receiptJobs
+	receiptJobs = NewJobStore("")
+	defer func() { receiptJobs = original }()
+
+	var appends []map[string]interface{}
+	failAt := 0 // 何件目の記録を失敗させるか（0: 失敗させない）
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		appends = append(appends, request)
+		if len(appends) == failAt {
+			w.Write([]byte(`{"status":"error","message":"シートがありません"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	yen := func(minor int64) Money { return Money{Minor: minor, Currency: "JPY"} }
+	purchase := newDoneJob(receiptJobs, time.Now().Add(-time.Hour), ReceiptResult{Store: "スーパー", Category: "食費", Amount: yen(1280), BaseAmount: yen(1280), Rate: 1})
+	jobID := newDoneJob(receiptJobs, time.Now(), ReceiptResult{Store: "スーパー", Date: "2025/01/07", Amount: yen(1280), BaseAmount: yen(1280), Rate: 1})
+	pressJob := func(id string) *recordingTransport {
+		appends = nil
+		s, transport := newRecordingSession(t)
+		router.Handle(s, newTestInteraction(discordgo.InteractionMessageComponent, discordgo.MessageComponentInteractionData{CustomID: refundReceiptCustomIDPrefix + id}))
+		return transport
+	}
+	press := func() *recordingTransport { return pressJob(jobID) }
+
+	t.Run("家計簿に記録できなければ元に戻す", func(t *testing.T) {
+		failAt = 1
+		defer func() { failAt = 0 }()
+		transport := press()
+		if job, _ := receiptJobs.Get(jobID); job.Result.Refund || job.Result.Amount.Minor != 1280 {
+			t.Errorf("Result = %+v, want unchanged", job.Result)
+		}
+		if len(appends) != 1 || len(transport.requests) != 3 || !strings.Contains(transport.requests[1].Body, refundReceiptCustomIDPrefix+jobID) ||
+			!strings.Contains(transport.requests[2].Body, "家計簿に返品・返金を記録できませんでした") || !strings.Contains(transport.requests[2].Body, `"flags":64`) {
+			t.Errorf("appends = %v, requests = %+v", appends, transport.requests)
+		}
+	})
+
+	t.Run("購入の行を打ち消し、マイナスの金額で元の記録に紐付けて記録する", func(t *testing.T) {
+		transport := press()
+		if len(appends) != 2 {
+			t.Fatalf("appends = %v, want 2 requests", appends)
+		}
+		cancel, refund := appends[0], appends[1]
+		if cancel["action"] != "append_entry" || cancel["cancels"] != jobID || cancel["amount"] != -1280.0 || cancel["refund"] != nil {
+			t.Errorf("cancel = %v", cancel)
+		}
+		if refund["action"] != "append_entry" || refund["id"] != jobID || refund["amount"] != -1280.0 || refund["refund"] != true || refund["refund_of"] != purchase || refund["category"] != "食費" || refund["cancels"] != nil {
+			t.Errorf("refund = %v", refund)
+		}
+		if len(transport.requests) != 2 || !strings.Contains(transport.requests[0].Body, `"type":6`) || !strings.Contains(transport.requests[1].Body, "↩️ 返品・返金: スーパー") {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+	})
+
+	t.Run("既に返品・返金", func(t *testing.T) {
+		transport := press()
+		if len(appends) != 0 || len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, "既に返品・返金として記録されています") {
+			t.Errorf("appends = %v, requests = %+v", appends, transport.requests)
+		}
+	})
+
+	t.Run("返品・返金の行だけ記録できなければ手で追加するよう伝える", func(t *testing.T) {
+		failAt = 2
+		defer func() { failAt = 0 }()
+		id := newDoneJob(receiptJobs, time.Now(), ReceiptResult{Store: "薬局", Date: "2025/01/08", Amount: yen(500), BaseAmount: yen(500), Rate: 1})
+		transport := pressJob(id)
+		if job, _ := receiptJobs.Get(id); !job.Result.Refund {
+			t.Errorf("Result = %+v, want a refund", job.Result)
+		}
+		if len(appends) != 2 || len(transport.requests) != 3 || !strings.Contains(transport.requests[1].Body, "↩️ 返品・返金: 薬局") ||
+			!strings.Contains(transport.requests[2].Body, "-500円 の行を追加してください") || !strings.Contains(transport.requests[2].Body, `"flags":64`) {
+			t.Errorf("appends = %v, requests = %+v", appends, transport.requests)
+		}
+	})
+
+	t.Run("Difyのワークフローが記録した場合も行を追加する", func(t *testing.T) {
+		t.Setenv("LEDGER_WRITER", "")
+		id := newDoneJob(receiptJobs, time.Now(), ReceiptResult{Store: "本屋", Date: "2025/01/09", Amount: yen(800), BaseAmount: yen(800), Rate: 1})
+		transport := pressJob(id)
+		if len(appends) != 2 || appends[0]["amount"] != -800.0 || appends[1]["amount"] != -800.0 || appends[1]["refund"] != true {
+			t.Errorf("appends = %v", appends)
+		}
+		if len(transport.requests) != 2 || !strings.Contains(transport.requests[1].Body, "↩️ 返品・返金: 本屋") {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+	})
+}
+
+// TestReceiptThread - レシート処理用スレッドの作成と、作成できない場合のテスト
+func TestReceiptThread(t *testing.T) {
+	const threadPath = "POST /api/v9/channels/c1/messages/m1/threads"
+	forbidden := `{"message":"Missing Permissions","code":50013}`
+	author := &discordgo.User{ID: "u1", Username: "hoshi"}
+
+	tests := []struct {
+		name      string
+		threads   string
+		origin    *discordgo.Message
+		status    int
+		want      string
+		wantPaths []string
+	}{
+		{"スレッドを作成", "", &discordgo.Message{ID: "m1", ChannelID: "c1"}, http.StatusOK, "t1", []string{threadPath}},
+		{"権限がなく作成に失敗", "", &discordgo.Message{ID: "m1", ChannelID: "c1"}, http.StatusForbidden, "c1", []string{threadPath}},
+		{"既にスレッドがあるメッセージ", "", &discordgo.Message{ID: "m1", ChannelID: "c1", Thread: &discordgo.Channel{ID: "t0"}}, http.StatusOK, "t0", nil},
+		{"スレッドを無効化", "false", &discordgo.Message{ID: "m1", ChannelID: "c1"}, http.StatusOK, "c1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RECEIPT_THREADS", tt.threads)
+			s, transport := newRecordingSession(t)
+			transport.responses = map[string]string{threadPath: `{"id":"t1","type":11}`}
+			if tt.status != http.StatusOK {
+				transport.responses[threadPath] = forbidden
+				transport.statuses = map[string]int{threadPath: tt.status}
+			}
+
+			if got := startReceiptThread(s, LangJapanese, tt.origin, author); got != tt.want {
+				t.Errorf("startReceiptThread() = %q, want %q", got, tt.want)
+			}
+			var paths []string
+			for _, req := range transport.requests {
+				paths = append(paths, req.Method+" "+req.Path)
+			}
+			if strings.Join(paths, ", ") != strings.Join(tt.wantPaths, ", ") {
+				t.Errorf("requests = %v, want %v", paths, tt.wantPaths)
+			}
+			if tt.status == http.StatusOK && len(transport.requests) == 1 && !strings.Contains(transport.requests[0].Body, "hoshi のレシート") {
+				t.Errorf("thread name = %s", transport.requests[0].Body)
+			}
+		})
+	}
+}
+
+// TestReceiptThreadMessages - スレッドへの投稿と、元のチャンネルに残すサマリーのテスト
+func TestReceiptThreadMessages(t *testing.T) {
+	t.Setenv("RECEIPT_THREADS", "")
+	original := receiptJobs
+	receiptJobs = NewJobStore("")
+	defer func() { receiptJobs = original }()
+
+	// ダウンロードに失敗する画像（Difyには送信しない）
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	const threadPath = "/api/v9/channels/c1/messages/m1/threads"
+	author := &discordgo.User{ID: "u1", Username: "hoshi"}
+
+	tests := []struct {
+		name         string
+		threadStatus int
+		wantChannel  string // ステータス・結果を投稿するチャンネル
+		wantMain     int    // 元のチャンネルに投稿するメッセージの数
+	}{
+		{"スレッドに投稿し、元のチャンネルにはサマリーだけ", http.StatusOK, "t1", 1},
+		{"スレッドを作成できない場合は元のチャンネルに投稿", http.StatusForbidden, "c1", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, transport := newRecordingSession(t)
+			transport.responses = map[string]string{
+				"POST " + threadPath:                `{"id":"t1","type":11}`,
+				"POST /api/v9/channels/c1/messages": `{"id":"s1","channel_id":"c1"}`,
+				"POST /api/v9/channels/t1/messages": `{"id":"s1","channel_id":"t1"}`,
+			}
+			if tt.threadStatus != http.StatusOK {
+				transport.responses["POST "+threadPath] = `{"message":"Missing Permissions","code":50013}`
+				transport.statuses = map[string]int{"POST " + threadPath: tt.threadStatus}
+			}
+
+			origin := &discordgo.Message{ID: "m1", ChannelID: "c1"}
+			processReceipts(s, LangJapanese, origin, author, []ReceiptSource{{URL: server.URL + "/receipt.jpg", Filename: "receipt.jpg"}})
+
+			var mainMessages, threadMessages []string
+			for _, req := range transport.requests {
+				if req.Method != http.MethodPost {
+					continue
+				}
+				switch req.Path {
+				case "/api/v9/channels/c1/messages":
+					mainMessages = append(mainMessages, req.Body)
+				case "/api/v9/channels/t1/messages":
+					threadMessages = append(threadMessages, req.Body)
+				}
+			}
+
+			if len(mainMessages) != tt.wantMain {
+				t.Errorf("元のチャンネルへの投稿 = %d件, want %d件: %v", len(mainMessages), tt.wantMain, mainMessages)
+			}
+			if tt.wantChannel == "t1" {
+				// ステータスメッセージと結果はスレッドに投稿する
+				if len(threadMessages) != 2 {
+					t.Errorf("スレッドへの投稿 = %d件, want 2件: %v", len(threadMessages), threadMessages)
+				}
+				if len(mainMessages) == 1 && (!strings.Contains(mainMessages[0], `\u003c#t1\u003e`) || !strings.Contains(mainMessages[0], `"message_id":"m1"`)) {
+					t.Errorf("サマリー = %s, want a reply with the thread link", mainMessages[0])
+				}
+			} else {
+				if len(threadMessages) != 0 {
+					t.Errorf("スレッドへの投稿 = %v, want none", threadMessages)
+				}
+				for _, body := range mainMessages {
+					if strings.Contains(body, `\u003c#`) {
+						t.Errorf("元のチャンネルの投稿 = %s, want no thread link", body)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestRefreshReceiptSource - 再処理時の添付ファイルのURLの取り直しのテスト
+func TestRefreshReceiptSource(t *testing.T) {
+	fresh := []ReceiptSource{
+		{URL: "https://cdn.discordapp.com/a/1/receipt.jpg?ex=new", ProxyURL: "https://media.discordapp.net/a/1/receipt.jpg?ex=new", Filename: "receipt.jpg", AttachmentID: "1"},
+		{URL: "https://cdn.discordapp.com/a/2/receipt.jpg?ex=new", Filename: "receipt.jpg", AttachmentID: "2"},
+		{URL: "https://i.imgur.com/other.jpg", Filename: "other.jpg"},
+	}
+
+	tests := []struct {
+		name   string
+		source ReceiptSource
+		want   string
+	}{
+		{"添付ファイルのIDで照合", ReceiptSource{URL: "https://cdn.discordapp.com/a/2/receipt.jpg?ex=old", Filename: "receipt.jpg", AttachmentID: "2"}, "https://cdn.discordapp.com/a/2/receipt.jpg?ex=new"},
+		{"IDのない古い履歴はファイル名で照合", ReceiptSource{URL: "https://cdn.discordapp.com/a/1/receipt.jpg?ex=old", Filename: "receipt.jpg"}, "https://cdn.discordapp.com/a/1/receipt.jpg?ex=new"},
+		{"削除された添付ファイルはそのまま", ReceiptSource{URL: "https://cdn.discordapp.com/a/3/gone.jpg?ex=old", Filename: "gone.jpg", AttachmentID: "3"}, "https://cdn.discordapp.com/a/3/gone.jpg?ex=old"},
+		{"本文のURLはそのまま", ReceiptSource{URL: "https://i.imgur.com/other.jpg", Filename: "other.jpg"}, "https://i.imgur.com/other.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshReceiptSource(tt.source, fresh); got.URL != tt.want {
+				t.Errorf("refreshReceiptSource().URL = %q, want %q", got.URL, tt.want)
+			}
+		})
+	}
+}
+
+// TestRetryReceiptJobsRefreshesURL - 再処理で期限切れの添付ファイルURLを使わないテスト
+func TestRetryReceiptJobsRefreshesURL(t *testing.T) {
+	t.Setenv("RECEIPT_THREADS", "false")
+	original := receiptJobs
+	receiptJobs = NewJobStore("")
+	defer func() { receiptJobs = original }()
+
+	var downloaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloaded = append(downloaded, r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	job := receiptJobs.Create("c1", "m1", "u1", "hoshi", ReceiptSource{URL: server.URL + "/expired/receipt.jpg", Filename: "receipt.jpg", AttachmentID: "a1"})
+	receiptJobs.Update(job.ID, JobStatusFailed, "ダウンロード失敗 (ステータス: 403)", nil)
+	claimed, _ := receiptJobs.Claim(job.ID)
+
+	s, transport := newRecordingSession(t)
+	origin, _ := json.Marshal(&discordgo.Message{ID: "m1", ChannelID: "c1", Attachments: []*discordgo.MessageAttachment{
+		{ID: "a1", URL: server.URL + "/fresh/receipt.jpg", Filename: "receipt.jpg"},
+	}})
+	transport.responses = map[string]string{"GET /api/v9/channels/c1/messages/m1": string(origin)}
+
+	retryReceiptJobs(s, LangJapanese, []ReceiptJob{claimed})
+
+	if strings.Join(downloaded, ",") != "/fresh/receipt.jpg" {
+		t.Errorf("downloaded = %v, want [/fresh/receipt.jpg]", downloaded)
+	}
+}
+
+// TestRetryFailedPermissions - /retry-failed をメッセージの管理権限を持つメンバーに限るテスト
+func TestRetryFailedPermissions(t *testing.T) {
+	permissions := retryFailedCommand.DefaultMemberPermissions
+	if permissions == nil || *permissions != discordgo.PermissionManageMessages {
+		t.Errorf("DefaultMemberPermissions = %v, want ManageMessages", permissions)
+	}
+	spec, _ := json.Marshal(newCommandSpec(retryFailedCommand))
+	if !strings.Contains(string(spec), `"default_member_permissions":8192`) {
+		t.Errorf("command spec = %s, want default_member_permissions", spec)
+	}
+}
+
+// TestRecordReceipt - 右クリックメニュー「Record this receipt」とPayer選択ボタンのテスト
+func TestRecordReceipt(t *testing.T) {
+	t.Setenv("IMAGE_URL_ALLOWED_HOSTS", "cdn.discordapp.com")
+
+	var started []string
+	original := startRecordReceipts
+	startRecordReceipts = func(s *discordgo.Session, lang Lang, target *discordgo.Message, payer *discordgo.User, sources []ReceiptSource) {
+		started = append(started, payer.ID)
+	}
+	defer func() { startRecordReceipts = original }()
+
+	attachment := []*discordgo.MessageAttachment{{ID: "a1", URL: "https://cdn.discordapp.com/1/receipt.jpg", Filename: "receipt.jpg", ContentType: "image/jpeg"}}
+	message := func(authorID string, attachments []*discordgo.MessageAttachment) *discordgo.Message {
+		return &discordgo.Message{ID: "m1", ChannelID: "c1", Author: &discordgo.User{ID: authorID, Username: "author-" + authorID}, Attachments: attachments}
+	}
+	runCommand := func(target *discordgo.Message) *recordingTransport {
+		s, transport := newRecordingSession(t)
+		router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{
+			Name:        recordReceiptCommandName,
+			CommandType: discordgo.MessageApplicationCommand,
+			TargetID:    target.ID,
+			Resolved:    &discordgo.ApplicationCommandInteractionDataResolved{Messages: map[string]*discordgo.Message{target.ID: target}},
+		}))
+		return transport
+	}
+	pressButton := func(customID string, target *discordgo.Message) *recordingTransport {
+		s, transport := newRecordingSession(t)
+		if target != nil {
+			body, _ := json.Marshal(target)
+			transport.responses = map[string]string{"GET /api/v9/channels/c1/messages/m1": string(body)}
+		}
+		router.Handle(s, newTestInteraction(discordgo.InteractionMessageComponent, discordgo.MessageComponentInteractionData{CustomID: customID}))
+		return transport
+	}
+
+	t.Run("自分の投稿はそのまま実行者の支払いとして記録", func(t *testing.T) {
+		started = nil
+		transport := runCommand(message("u1", attachment))
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, "1個の画像をレシートとして記録します") {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+		if strings.Join(started, ",") != "u1" {
+			t.Errorf("started = %v, want [u1]", started)
+		}
+	})
+
+	t.Run("他の人の投稿は支払った人を選ぶボタンを表示", func(t *testing.T) {
+		started = nil
+		transport := runCommand(message("u2", attachment))
+		if len(transport.requests) != 1 {
+			t.Fatalf("requests = %+v", transport.requests)
+		}
+		body := transport.requests[0].Body
+		for _, want := range []string{"支払ったのは誰ですか", "record_receipt:c1:m1:invoker", "record_receipt:c1:m1:author", "自分（hoshi）", "投稿者（author-u2）"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body = %s, want %q", body, want)
+			}
+		}
+		if len(started) != 0 {
+			t.Errorf("started = %v, want none before a payer is chosen", started)
+		}
+	})
+
+	t.Run("画像のないメッセージ", func(t *testing.T) {
+		started = nil
+		transport := runCommand(message("u2", nil))
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, "レシートとして処理できる画像・PDFがありません") {
+			t.Errorf("requests = %+v", transport.requests)
+		}
+		if len(started) != 0 {
+			t.Errorf("started = %v, want none", started)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		customID    string
+		target      *discordgo.Message
+		wantContent string
+		wantPayer   string
+	}{
+		{"自分が支払った", "record_receipt:c1:m1:invoker", message("u2", attachment), "hoshiさんの支払いとして1個の画像を記録します", "u1"},
+		{"投稿者が支払った", "record_receipt:c1:m1:author", message("u2", attachment), "author-u2さんの支払いとして1個の画像を記録します", "u2"},
+		{"CustomIDの項目が足りない", "record_receipt:c1:m1", nil, "ボタンの情報が不正です", ""},
+		{"CustomIDの項目が多い", "record_receipt:c1:m1:author:extra", nil, "ボタンの情報が不正です", ""},
+		{"画像が削除されたメッセージ", "record_receipt:c1:m1:author", message("u2", nil), "レシートとして処理できる画像・PDFがありません", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started = nil
+			transport := pressButton(tt.customID, tt.target)
+			last := transport.requests[len(transport.requests)-1]
+			if !strings.Contains(last.Body, tt.wantContent) {
+				t.Errorf("response = %s, want %q", last.Body, tt.wantContent)
+			}
+			if tt.target == nil && len(transport.requests) != 1 {
+				t.Errorf("requests = %+v, want only the response", transport.requests)
+			}
+			if strings.Join(started, ",") != tt.wantPayer {
+				t.Errorf("started = %v, want %q", started, tt.wantPayer)
+			}
+		})
+	}
+}
+
+// TestRecurringDueDate - 定期支出の予定日のテスト（その月にない日は月末）
+func TestRecurringDueDate(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	tests := []struct {
+		name  string
+		year  int
+		month time.Month
+		day   int
+		want  string
+	}{
+		{"通常", 2025, time.March, 25, "2025/03/25"},
+		{"2月31日は月末", 2025, time.February, 31, "2025/02/28"},
+		{"うるう年", 2024, time.February, 30, "2024/02/29"},
+		{"30日までの月", 2025, time.April, 31, "2025/04/30"},
+		{"13月は翌年1月", 2025, 13, 31, "2026/01/31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recurringDueDate(tt.year, tt.month, tt.day, loc)
+			if got.Format("2006/01/02") != tt.want || got.Hour() != recurringRunHour {
+				t.Errorf("recurringDueDate() = %v, want %s %d:00", got, tt.want, recurringRunHour)
+			}
+		})
+	}
+
+	t.Run("今月の予定日を過ぎていれば来月から", func(t *testing.T) {
+		now := time.Date(2025, time.January, 31, 12, 0, 0, 0, loc)
+		if got := firstRecurringRun(31, now); got.Format("2006/01/02") != "2025/02/28" {
+			t.Errorf("firstRecurringRun() = %v, want 2025/02/28", got)
+		}
+		if got := firstRecurringRun(31, now.Add(-4*time.Hour)); got.Format("2006/01/02") != "2025/01/31" {
+			t.Errorf("firstRecurringRun() = %v, want 2025/01/31", got)
+		}
+	})
+
+	t.Run("月末に丸めた後も元の日に戻る", func(t *testing.T) {
+		feb := recurringDueDate(2025, time.February, 31, loc)
+		if got := nextRecurringRun(feb, 31); got.Format("2006/01/02") != "2025/03/31" {
+			t.Errorf("nextRecurringRun() = %v, want 2025/03/31", got)
+		}
+	})
+}
+
+// TestRecurringStore - 定期支出の保存・読み込みのテスト
+func TestRecurringStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recurring.json")
+	now := time.Date(2025, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+	store, err := LoadRecurringStore(path)
+	if err != nil {
+		t.Fatalf("LoadRecurringStore() error = %v", err)
+	}
+	rent, err := store.Add(RecurringExpense{Name: "家賃", Amount: Money{Minor: 85000, Currency: "JPY"}, Category: "住居費", Day: 25, Payer: "hoshi"}, now)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	netflix, _ := store.Add(RecurringExpense{Name: "Netflix", Amount: Money{Minor: 1490, Currency: "JPY"}, Category: "娯楽", Day: 5, Payer: "hoshi"}, now)
+
+	if rent.NextRun.Format("2006/01/02") != "2025/01/25" || netflix.NextRun.Format("2006/01/02") != "2025/02/05" {
+		t.Errorf("NextRun = %v, %v", rent.NextRun, netflix.NextRun)
+	}
+	if ok, err := store.Advance(rent.ID, rent.NextRun); !ok || err != nil {
+		t.Fatalf("Advance() = %v, %v", ok, err)
+	}
+	// 同じ回を二重に進めない
+	if ok, _ := store.Advance(rent.ID, rent.NextRun); ok {
+		t.Error("Advance() of already advanced run = true")
+	}
+
+	reloaded, err := LoadRecurringStore(path)
+	if err != nil {
+		t.Fatalf("LoadRecurringStore() error = %v", err)
+	}
+	list := reloaded.List()
+	if len(list) != 2 || list[0].Name != "Netflix" || list[1].Name != "家賃" {
+		t.Fatalf("List() = %+v", list)
+	}
+	if list[1].NextRun.Format("2006/01/02") != "2025/02/25" || list[1].Amount.Minor != 85000 {
+		t.Errorf("reloaded = %+v", list[1])
+	}
+
+	if _, ok, err := reloaded.Remove(netflix.ID); !ok || err != nil {
+		t.Errorf("Remove() = %v, %v", ok, err)
+	}
+	if _, ok, _ := reloaded.Remove(netflix.ID); ok {
+		t.Error("Remove() of removed expense = true")
+	}
+
+	// 保存できない場合はエラーを返し、登録・削除を取り消す
+	reloaded.path = unwritablePath(t, "recurring.json")
+	if _, err := reloaded.Add(RecurringExpense{Name: "ジム", Amount: Money{Minor: 8000, Currency: "JPY"}, Category: "健康", Day: 1}, now); err == nil {
+		t.Error("Add() error = nil, want save error")
+	}
+	if _, ok, err := reloaded.Remove(rent.ID); !ok || err == nil {
+		t.Errorf("Remove() = %v, %v, want save error", ok, err)
+	}
+	if list := reloaded.List(); len(list) != 1 || list[0].ID != rent.ID {
+		t.Errorf("List() after failed saves = %+v", list)
+	}
+}
+
+// TestRunDueRecurringExpenses - 停止中に過ぎた予定日の分もまとめて記録するテスト
+func TestRunDueRecurringExpenses(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BUDGET_CHANNEL_ID", "budget")
+
+	var entries []LedgerEntry
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry LedgerEntry
+		json.NewDecoder(r.Body).Decode(&entry)
+		if fail {
+			w.Write([]byte(`{"status":"error","message":"シートがありません"}`))
+			return
+		}
+		entries = append(entries, entry)
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	original := recurringExpenses
+	recurringExpenses = NewRecurringStore("")
+	defer func() { recurringExpenses = original }()
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	created := time.Date(2025, time.January, 10, 12, 0, 0, 0, loc)
+	rent, _ := recurringExpenses.Add(RecurringExpense{Name: "家賃", Amount: Money{Minor: 85000, Currency: "JPY"}, Category: "住居費", Day: 31, Payer: "hoshi"}, created)
+
+	t.Run("記録に失敗したら進めない", func(t *testing.T) {
+		fail = true
+		defer func() { fail = false }()
+		s, transport := newRecordingSession(t)
+		runDueRecurringExpenses(s, time.Date(2025, time.February, 1, 12, 0, 0, 0, loc))
+		if len(transport.requests) != 0 {
+			t.Errorf("requests = %+v, want none", transport.requests)
+		}
+		if list := recurringExpenses.List(); !list[0].NextRun.Equal(rent.NextRun) {
+			t.Errorf("NextRun = %v, want %v", list[0].NextRun, rent.NextRun)
+		}
+	})
+
+	t.Run("予定日時を保存できなければ記録しない", func(t *testing.T) {
+		recurringExpenses.path = unwritablePath(t, "recurring.json")
+		defer func() { recurringExpenses.path = "" }()
+		s, transport := newRecordingSession(t)
+		runDueRecurringExpenses(s, time.Date(2025, time.February, 1, 12, 0, 0, 0, loc))
+		if len(entries) != 0 || len(transport.requests) != 0 {
+			t.Errorf("entries = %+v, requests = %+v, want none", entries, transport.requests)
+		}
+		if list := recurringExpenses.List(); !list[0].NextRun.Equal(rent.NextRun) {
+			t.Errorf("NextRun = %v, want %v", list[0].NextRun, rent.NextRun)
+		}
+	})
+
+	t.Run("停止中の3回分を記録", func(t *testing.T) {
+		s, transport := newRecordingSession(t)
+		runDueRecurringExpenses(s, time.Date(2025, time.April, 2, 12, 0, 0, 0, loc))
+
+		var dates []string
+		for _, entry := range entries {
+			dates = append(dates, entry.Date)
+			if entry.Amount != 85000 || entry.Category != "住居費" || entry.Payer != "hoshi" {
+				t.Errorf("entry = %+v", entry)
+			}
+		}
+		if want := "2025/01/31,2025/02/28,2025/03/31"; strings.Join(dates, ",") != want {
+			t.Errorf("dates = %v, want %s", dates, want)
+		}
+		if len(transport.requests) != 3 || transport.requests[0].Path != "/api/v9/channels/budget/messages" {
+			t.Fatalf("requests = %+v", transport.requests)
+		}
+		if body := transport.requests[0].Body; !strings.Contains(body, "家賃") || !strings.Contains(body, "85,000円") || !strings.Contains(body, "遅れて記録しました") {
+			t.Errorf("announce = %s", body)
+		}
+		if list := recurringExpenses.List(); list[0].NextRun.Format("2006/01/02") != "2025/04/30" {
+			t.Errorf("NextRun = %v, want 2025/04/30", list[0].NextRun)
+		}
+	})
+
+	t.Run("予定日前は記録しない", func(t *testing.T) {
+		entries = nil
+		s, transport := newRecordingSession(t)
+		runDueRecurringExpenses(s, time.Date(2025, time.April, 30, 8, 59, 0, 0, loc))
+		if len(entries) != 0 || len(transport.requests) != 0 {
+			t.Errorf("entries = %+v, requests = %+v", entries, transport.requests)
+		}
+	})
+}
+
+// TestRecurringCommand - /recurring add・list・remove のテスト
+func TestRecurringCommand(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BASE_CURRENCY", "")
+	original := recurringExpenses
+	recurringExpenses = NewRecurringStore("")
+	defer func() { recurringExpenses = original }()
+
+	subcommand := func(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) discordgo.ApplicationCommandInteractionData {
+		return discordgo.ApplicationCommandInteractionData{Name: "recurring", Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+			Name:    name,
+			Type:    discordgo.ApplicationCommandOptionSubCommand,
+			Options: options,
+		}}}
+	}
+	add := func(amount string) discordgo.ApplicationCommandInteractionData {
+		return subcommand("add",
+			&discordgo.ApplicationCommandInteractionDataOption{Name: "name", Type: discordgo.ApplicationCommandOptionString, Value: "家賃"},
+			&discordgo.ApplicationCommandInteractionDataOption{Name: "amount", Type: discordgo.ApplicationCommandOptionString, Value: amount},
+			&discordgo.ApplicationCommandInteractionDataOption{Name: "category", Type: discordgo.ApplicationCommandOptionString, Value: "住居費"},
+			&discordgo.ApplicationCommandInteractionDataOption{Name: "day", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(25)},
+			&discordgo.ApplicationCommandInteractionDataOption{Name: "payer", Type: discordgo.ApplicationCommandOptionString, Value: "共通"},
+		)
+	}
+	remove := func(id string) discordgo.ApplicationCommandInteractionData {
+		return subcommand("remove", &discordgo.ApplicationCommandInteractionDataOption{Name: "id", Type: discordgo.ApplicationCommandOptionString, Value: id})
+	}
+
+	run := func(t *testing.T, data discordgo.ApplicationCommandInteractionData, wantBody string) {
+		t.Helper()
+		s, transport := newRecordingSession(t)
+		router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, data))
+		if len(transport.requests) != 1 || !strings.Contains(transport.requests[0].Body, wantBody) {
+			t.Errorf("requests = %+v, want body containing %q", transport.requests, wantBody)
+		}
+	}
+
+	t.Run("一覧（空）", func(t *testing.T) { run(t, subcommand("list"), "登録されていません") })
+	t.Run("登録", func(t *testing.T) {
+		run(t, add("¥85,000"), "**家賃** 85,000円（毎月25日、住居費、共通）")
+	})
+	t.Run("不正な金額", func(t *testing.T) { run(t, add("0"), "金額として読み取れません") })
+	t.Run("基準通貨以外", func(t *testing.T) { run(t, add("$500"), "基準通貨（JPY）") })
+
+	list := recurringExpenses.List()
+	if len(list) != 1 {
+		t.Fatalf("List() = %+v", list)
+	}
+	t.Run("一覧", func(t *testing.T) { run(t, subcommand("list"), "["+list[0].ID+"] 毎月25日 家賃 85,000円") })
+	t.Run("見つからないID", func(t *testing.T) { run(t, remove("zzzz"), "見つかりませんでした") })
+	t.Run("削除", func(t *testing.T) { run(t, remove(list[0].ID), "定期支出を削除しました") })
+
+	t.Run("保存できない", func(t *testing.T) {
+		recurringExpenses = NewRecurringStore(unwritablePath(t, "recurring.json"))
+		run(t, add("¥85,000"), "保存できなかったため")
+		if list := recurringExpenses.List(); len(list) != 0 {
+			t.Errorf("List() = %+v, want empty", list)
+		}
+	})
+}
+
+// TestCronSchedule - cron形式のスケジュールの読み取りと次の実行日時のテスト
+func TestCronSchedule(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	// 2025/01/08 は水曜日
+	base := time.Date(2025, time.January, 8, 10, 30, 0, 0, loc)
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  string
+	}{
+		{"毎週月曜9時", "0 9 * * 1", base, "2025/01/13 09:00"},
+		{"毎月1日9時", "0 9 1 * *", base, "2025/02/01 09:00"},
+		{"15分ごと", "*/15 * * * *", base, "2025/01/08 10:45"},
+		{"同じ分は次の回", "30 10 * * *", base, "2025/01/09 10:30"},
+		{"範囲とリスト", "0 8-9,18 * * 1-5", base, "2025/01/08 18:00"},
+		{"日曜は7でも指定できる", "0 21 * * 7", base, "2025/01/12 21:00"},
+		{"日と曜日はどちらかに一致", "0 9 10 * 5", base, "2025/01/10 09:00"},
+		{"31日のない月は飛ばす", "0 0 31 * *", time.Date(2025, time.April, 1, 0, 0, 0, 0, loc), "2025/05/31 00:00"},
+		{"年をまたぐ", "0 9 1 1 *", base, "2026/01/01 09:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCronSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule(%q) error = %v", tt.spec, err)
+			}
+			got := schedule.Next(tt.after)
+			if got.Format("2006/01/02 15:04") != tt.want || got.Location() != loc {
+				t.Errorf("Next() = %v, want %s (Asia/Tokyo)", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("タイムゾーンごとに判定する", func(t *testing.T) {
+		schedule, _ := ParseCronSchedule("0 9 * * 1")
+		// 日本時間の月曜9時はUTCの月曜0時
+		got := schedule.Next(base.UTC())
+		if got.Format("2006/01/02 15:04") != "2025/01/13 09:00" {
+			t.Errorf("Next() = %v, want 2025/01/13 09:00 UTC", got)
+		}
+	})
+
+	t.Run("存在しない日付", func(t *testing.T) {
+		schedule, _ := ParseCronSchedule("0 0 30 2 *")
+		if got := schedule.Next(base); !got.IsZero() {
+			t.Errorf("Next() = %v, want zero", got)
+		}
+	})
+
+	for _, spec := range []string{"", "0 9 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		t.Run("不正: "+spec, func(t *testing.T) {
+			if _, err := ParseCronSchedule(spec); err == nil {
+				t.Errorf("ParseCronSchedule(%q) error = nil", spec)
+			}
+		})
+	}
+}
+
+// TestGetSummarySchedule - サマリーのスケジュールの設定のテスト
+func TestGetSummarySchedule(t *testing.T) {
+	t.Setenv("SUMMARY_WEEKLY_CRON", "")
+	t.Setenv("SUMMARY_MONTHLY_CRON", "off")
+
+	weekly, err := GetSummarySchedule(SummaryWeekly)
+	if err != nil || weekly.String() != "0 9 * * 1" {
+		t.Errorf("GetSummarySchedule(weekly) = %v, %v", weekly, err)
+	}
+	if monthly, err := GetSummarySchedule(SummaryMonthly); monthly != nil || err != nil {
+		t.Errorf("GetSummarySchedule(monthly) = %v, %v, want disabled", monthly, err)
+	}
+	t.Setenv("SUMMARY_MONTHLY_CRON", "0 25 1 * *")
+	if _, err := GetSummarySchedule(SummaryMonthly); err == nil {
+		t.Error("GetSummarySchedule(monthly) error = nil")
+	}
+}
+
+// TestSummaryRange - サマリーの対象期間のテスト
+func TestSummaryRange(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	tests := []struct {
+		name     string
+		kind     SummaryKind
+		postedAt time.Time
+		want     string // 前の期間の開始日, 開始日, 終了日（含まない）
+	}{
+		{"週間", SummaryWeekly, time.Date(2025, time.January, 13, 9, 0, 0, 0, loc), "2024/12/30 2025/01/06 2025/01/13"},
+		{"月間", SummaryMonthly, time.Date(2025, time.March, 1, 9, 0, 0, 0, loc), "2025/01/01 2025/02/01 2025/03/01"},
+		{"月間（年をまたぐ）", SummaryMonthly, time.Date(2025, time.January, 1, 9, 0, 0, 0, loc), "2024/11/01 2024/12/01 2025/01/01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, previousFrom := summaryRange(tt.kind, tt.postedAt)
+			got := previousFrom.Format("2006/01/02") + " " + from.Format("2006/01/02") + " " + to.Format("2006/01/02")
+			if got != tt.want {
+				t.Errorf("summaryRange() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPostLedgerSummary - 家計簿の記録を集計して投稿するテスト
+func TestPostLedgerSummary(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BASE_CURRENCY", "")
+	t.Setenv("SUMMARY_CHANNEL_ID", "summary")
+
+	var request map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"status":"success","count":7,"data":[
+			{"date":"2025/01/06","store":"スーパー","item":"食料品","category":"食費","amount":3000,"payer":"hoshi"},
+			{"date":"2025/01/08","store":"スーパー","item":"食料品","category":"食費","amount":2000,"payer":"partner"},
+			{"date":"2025/01/10","store":"ドラッグストア","item":"洗剤","category":"日用品","amount":1500,"payer":"hoshi"},
+			{"date":"2025-01-11T15:00:00.000Z","store":"スーパー","item":"返品","category":"食費","amount":-500,"payer":"hoshi"},
+			{"date":"2025/01/13","store":"カフェ","item":"コーヒー","category":"外食","amount":500,"payer":"hoshi"},
+			{"date":"2025/01/02","store":"スーパー","item":"食料品","category":"食費","amount":4000,"payer":"hoshi"},
+			{"date":"不明","store":"コンビニ","item":"お菓子","category":"食費","amount":200,"payer":"hoshi"}
+		]}`))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	s, transport := newRecordingSession(t)
+	if err := postLedgerSummary(s, SummaryWeekly, time.Date(2025, time.January, 13, 9, 0, 0, 0, loc)); err != nil {
+		t.Fatalf("postLedgerSummary() error = %v", err)
+	}
+
+	// 前の週の分もまとめて、両端を含む日付で取得する
+	if request["action"] != "get_entries" || request["from"] != "2024/12/30" || request["to"] != "2025/01/12" {
+		t.Errorf("request = %v", request)
+	}
+	if len(transport.requests) != 1 || transport.requests[0].Path != "/api/v9/channels/summary/messages" {
+		t.Fatalf("requests = %+v", transport.requests)
+	}
+
+	var message discordgo.MessageSend
+	if err := json.Unmarshal([]byte(transport.requests[0].Body), &message); err != nil || len(message.Embeds) != 1 {
+		t.Fatalf("body = %s, err = %v", transport.requests[0].Body, err)
+	}
+	embed := message.Embeds[0]
+	if embed.Title != "📊 週間サマリー（2025/01/06〜2025/01/12）" {
+		t.Errorf("Title = %q", embed.Title)
+	}
+	if !strings.Contains(embed.Description, "合計 **6,000円**（4件）") || !strings.Contains(embed.Description, "先週比 +2,000円 (+50.0%)") {
+		t.Errorf("Description = %q", embed.Description)
+	}
+	if len(embed.Fields) != 3 {
+		t.Fatalf("Fields = %+v", embed.Fields)
+	}
+	if want := "**食費** 4,500円 (+500円)\n**日用品** 1,500円"; embed.Fields[0].Value != want {
+		t.Errorf("categories = %q, want %q", embed.Fields[0].Value, want)
+	}
+	if want := "**hoshi** 4,000円 (±0円)\n**partner** 2,000円"; embed.Fields[1].Value != want {
+		t.Errorf("payers = %q, want %q", embed.Fields[1].Value, want)
+	}
+	if want := "1. スーパー 4,500円（3回）\n2. ドラッグストア 1,500円（1回）"; embed.Fields[2].Value != want {
+		t.Errorf("stores = %q, want %q", embed.Fields[2].Value, want)
+	}
+
+	t.Run("記録がない期間", func(t *testing.T) {
+		embed := LedgerSummaryEmbed(LangEnglish, SummaryMonthly, time.Date(2025, time.January, 1, 0, 0, 0, 0, loc), time.Date(2025, time.February, 1, 0, 0, 0, 0, loc), LedgerSummary{}, LedgerSummary{})
+		if embed.Title != "📊 Monthly summary (2025/01/01 – 2025/01/31)" || embed.Description != "No entries in this period" || len(embed.Fields) != 0 {
+			t.Errorf("embed = %+v", embed)
+		}
+	})
+}
+
+// TestPostDueSummaries - 停止中に過ぎたサマリーの回の投稿のテスト
+func TestPostDueSummaries(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BASE_CURRENCY", "")
+	t.Setenv("SUMMARY_CHANNEL_ID", "summary")
+
+	var requests []map[string]string
+	failFrom := "2024/12/30"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		if request["from"] == failFrom {
+			w.Write([]byte(`{"status":"error","message":"一時的なエラー"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","count":0,"data":[]}`))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	original := summaryState
+	defer func() { summaryState = original }()
+	path := filepath.Join(t.TempDir(), "summary.json")
+	summaryState = NewSummaryState(path)
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	schedule, _ := ParseCronSchedule("0 9 * * 1")
+	if err := summaryState.MarkPosted(SummaryWeekly, time.Date(2025, time.January, 6, 9, 0, 0, 0, loc)); err != nil {
+		t.Fatalf("MarkPosted() error = %v", err)
+	}
+
+	// 1/13と1/20の回を過ぎたまま停止していた。1/13の回（前の期間の開始が12/30）の投稿に失敗したら、そこで止める
+	s, transport := newRecordingSession(t)
+	now := time.Date(2025, time.January, 21, 10, 0, 0, 0, loc)
+	if err := postDueSummaries(s, SummaryWeekly, schedule, now); err == nil {
+		t.Fatal("postDueSummaries() error = nil, want GAS error")
+	}
+	if len(transport.requests) != 0 || !summaryState.LastRun(SummaryWeekly).Equal(time.Date(2025, time.January, 6, 9, 0, 0, 0, loc)) {
+		t.Fatalf("requests = %+v, LastRun = %v", transport.requests, summaryState.LastRun(SummaryWeekly))
+	}
+
+	// 次に呼ばれた時は失敗した回から古い順に投稿する
+	failFrom = ""
+	requests = nil
+	if err := postDueSummaries(s, SummaryWeekly, schedule, now); err != nil {
+		t.Fatalf("postDueSummaries() error = %v", err)
+	}
+	if len(requests) != 2 || requests[0]["to"] != "2025/01/12" || requests[1]["to"] != "2025/01/19" {
+		t.Errorf("requests = %v", requests)
+	}
+	if len(transport.requests) != 2 {
+		t.Errorf("posted = %+v", transport.requests)
+	}
+
+	// 最後に投稿した回を保存し、次の起動で読み込める
+	reloaded, err := LoadSummaryState(path)
+	if err != nil {
+		t.Fatalf("LoadSummaryState() error = %v", err)
+	}
+	if want := time.Date(2025, time.January, 20, 9, 0, 0, 0, loc); !reloaded.LastRun(SummaryWeekly).Equal(want) {
+		t.Errorf("LastRun = %v, want %v", reloaded.LastRun(SummaryWeekly), want)
+	}
+
+	// 過ぎた回がなければ何も投稿しない
+	summaryState = reloaded
+	if err := postDueSummaries(s, SummaryWeekly, schedule, now); err != nil || len(transport.requests) != 2 {
+		t.Errorf("postDueSummaries() = %v, posted = %d", err, len(transport.requests))
+	}
+}
+
+// TestNiceChartMax - グラフの縦軸の最大値のテスト
+func TestNiceChartMax(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  float64
+	}{
+		{0, 1},
+		{8300, 10000},
+		{230, 250},
+		{76000, 100000},
+		{46500, 50000},
+		{1.5, 2},
+		{5000, 5000},
+	}
+	for _, tt := range tests {
+		if got := niceChartMax(tt.value); got != tt.want {
+			t.Errorf("niceChartMax(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestChartData - グラフにする集計のテスト
+func TestChartData(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	now := time.Date(2025, time.February, 5, 12, 0, 0, 0, loc)
+
+	t.Run("カテゴリが多い場合は「その他」にまとめる", func(t *testing.T) {
+		var entries []LedgerEntry
+		for i := 1; i <= 9; i++ {
+			entries = append(entries, LedgerEntry{Category: fmt.Sprintf("c%d", i), Amount: float64(i * 1000)})
+		}
+		entries = append(entries, LedgerEntry{Category: "返金", Amount: -500})
+
+		slices := chartCategories(LangJapanese, entries, "JPY")
+		if len(slices) != len(chartPalette) {
+			t.Fatalf("len = %d, want %d", len(slices), len(chartPalette))
+		}
+		if slices[0].Name != "c9" || slices[0].Color != chartPalette[0] {
+			t.Errorf("slices[0] = %+v", slices[0])
+		}
+		other := slices[len(slices)-1]
+		if other.Name != "その他" || other.Total.Minor != 6000 || other.Color != chartOtherColor {
+			t.Errorf("other = %+v, want その他 6000 (c1〜c3)", other)
+		}
+	})
+
+	entries := []LedgerEntry{
+		{Date: "2025/01/20", Amount: 30000},
+		{Date: "2025/02/01", Amount: 1000},
+		{Date: "2025/02/03", Amount: 2000},
+		{Date: "2025/02/03", Amount: -500},
+		{Date: "2025/02/05", Amount: 300},
+		{Date: "読めない日付", Amount: 9999},
+	}
+
+	t.Run("今月は日ごとの累計", func(t *testing.T) {
+		values, labels := chartTrend(entries, ChartMonth, now, "JPY")
+		if fmt.Sprint(values) != "[1000 1000 2500 2500 2800]" || len(labels) != 28 {
+			t.Errorf("values = %v, labels = %d", values, len(labels))
+		}
+	})
+
+	t.Run("今年は月ごとの合計", func(t *testing.T) {
+		values, labels := chartTrend(entries, ChartYear, now, "JPY")
+		if fmt.Sprint(values) != "[30000 2800]" || len(labels) != 12 {
+			t.Errorf("values = %v, labels = %d", values, len(labels))
+		}
+	})
+
+	t.Run("期間の表示", func(t *testing.T) {
+		if got := chartPeriodLabel(LangJapanese, ChartMonth, now); got != "2025年2月" {
+			t.Errorf("ja = %q", got)
+		}
+		if got := chartPeriodLabel(LangEnglish, ChartMonth, now); got != "February 2025" {
+			t.Errorf("en = %q", got)
+		}
+	})
+}
+
+// TestRenderCharts - グラフの画像のテスト
+func TestRenderCharts(t *testing.T) {
+	slices := []chartSlice{
+		{Name: "食費", Total: Money{Minor: 6000, Currency: "JPY"}, Color: chartPalette[0]},
+		{Name: "日用品", Total: Money{Minor: 3000, Currency: "JPY"}, Color: chartPalette[1]},
+		{Name: "その他", Total: Money{Minor: 1000, Currency: "JPY"}, Color: chartOtherColor},
+	}
+	near := func(got color.Color, want color.RGBA) bool {
+		r, g, b, _ := got.RGBA()
+		diff := func(a uint32, b uint8) float64 { return math.Abs(float64(a>>8) - float64(b)) }
+		return diff(r, want.R) < 8 && diff(g, want.G) < 8 && diff(b, want.B) < 8
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		pixels map[image.Point]color.RGBA
+	}{
+		// 12時の位置から時計回りに 食費60%・日用品30%・その他10%
+		{"円グラフ", renderPieChart(slices), map[image.Point]color.RGBA{
+			{500, 150}: chartPalette[0].RGBA,
+			{300, 300}: chartPalette[1].RGBA,
+			{370, 60}:  chartOtherColor.RGBA,
+			{20, 20}:   chartBackground,
+		}},
+		// 縦軸は0〜10,000円
+		{"棒グラフ", renderBarChart(slices), map[image.Point]color.RGBA{
+			{203, 300}: chartPalette[0].RGBA,
+			{430, 350}: chartPalette[1].RGBA,
+			{430, 250}: chartBackground,
+			{657, 390}: chartOtherColor.RGBA,
+		}},
+		{"折れ線グラフ", renderLineChart([]float64{0, 5000, 10000}, []string{"1", "2", "3", "4", "5"}, "JPY"), map[image.Point]color.RGBA{
+			{chartPlotLeft + 170, 215}: chartPalette[0].RGBA,
+			{chartPlotLeft + 340, 30}:  chartPalette[0].RGBA,
+			{chartPlotLeft + 510, 60}:  chartBackground,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.img.Bounds() != image.Rect(0, 0, chartWidth, chartHeight) {
+				t.Fatalf("Bounds() = %v", tt.img.Bounds())
+			}
+			for point, want := range tt.pixels {
+				if got := tt.img.At(point.X, point.Y); !near(got, want) {
+					t.Errorf("At(%v) = %v, want %v", point, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestChartCommand - /chart のテスト
+func TestChartCommand(t *testing.T) {
+	t.Setenv("BOT_LANGUAGE", "")
+	t.Setenv("BASE_CURRENCY", "")
+
+	var request map[string]string
+	response := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	t.Setenv("GAS_ENDPOINT", server.URL)
+
+	chart := func(chartType, period string) discordgo.ApplicationCommandInteractionData {
+		options := []*discordgo.ApplicationCommandInteractionDataOption{{Name: "type", Type: discordgo.ApplicationCommandOptionString, Value: chartType}}
+		if period != "" {
+			options = append(options, &discordgo.ApplicationCommandInteractionDataOption{Name: "period", Type: discordgo.ApplicationCommandOptionString, Value: period})
+		}
+		return discordgo.ApplicationCommandInteractionData{Name: "chart", Options: options}
+	}
+	today := time.Now().In(GetTimezone()).Format("2006/01/02")
+
+	tests := []struct {
+		name     string
+		data     discordgo.ApplicationCommandInteractionData
+		response string
+		wantFrom string
+		wantBody []string
+	}{
+		{"円グラフ（今月）", chart("pie", ""),
+			`{"status":"success","data":[{"date":"` + today + `","category":"食費","amount":1200},{"date":"` + today + `","category":"日用品","amount":800}]}`,
+			time.Now().In(GetTimezone()).Format("2006/01") + "/01",
+			[]string{`filename="chart.png"`, "Content-Type: image/png", "attachment://chart.png", "🟦 **食費** 1,200円 (60.0%)"}},
+		{"折れ線グラフ（今年）", chart("line", "year"),
+			`{"status":"success","data":[{"date":"` + today + `","category":"食費","amount":1200}]}`,
+			time.Now().In(GetTimezone()).Format("2006") + "/01/01",
+			[]string{`filename="chart.png"`, "月ごとの支出"}},
+		{"記録なし", chart("bar", "month"), `{"status":"success","data":[]}`, "", []string{"グラフにできる記録がありません"}},
+		{"取得失敗", chart("bar", "month"), `not json`, "", []string{"❌"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response = tt.response
+			s, transport := newRecordingSession(t)
+			router.Handle(s, newTestInteraction(discordgo.InteractionApplicationCommand, tt.data))
+
+			if request["action"] != "get_entries" || (tt.wantFrom != "" && request["from"] != tt.wantFrom) {
+				t.Errorf("request = %v, want from %s", request, tt.wantFrom)
+			}
+			// 考え中の応答の後、元の応答を編集する
+			if len(transport.requests) != 2 || transport.requests[1].Method != http.MethodPatch {
+				t.Fatalf("requests = %+v", transport.requests)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(transport.requests[1].Body, want) {
+					t.Errorf("body does not contain %q: %.500s", want, transport.requests[1].Body)
+				}
+			}
+		})
+	}
+}
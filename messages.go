@@ -0,0 +1,599 @@
+package main
+
+// ユーザーに表示するメッセージのカタログ（キー → 言語 → メッセージ）
+// 書式指定子（%s, %d など）の数と順番は全ての言語で揃えること
+var messageCatalog = map[string]map[Lang]string{
+	// --- インタラクション共通 ---
+	"router.unknown_command": {
+		LangJapanese: "❌ このコマンドは現在使用できません",
+		LangEnglish:  "❌ This command is not available right now",
+	},
+	"router.panic": {
+		LangJapanese: "❌ 処理中にエラーが発生しました",
+		LangEnglish:  "❌ Something went wrong while processing your request",
+	},
+
+	// --- /hello ---
+	"hello.greeting": {
+		LangJapanese: "やっほー‼️‼️‼️\nボタンなどのメッセージコンポーネントは使えそうですか？",
+		LangEnglish:  "Hey there‼️‼️‼️\nAre you comfortable with buttons and other message components?",
+	},
+	"hello.yes": {
+		LangJapanese: "はい",
+		LangEnglish:  "Yes",
+	},
+	"hello.no": {
+		LangJapanese: "いいえ",
+		LangEnglish:  "No",
+	},
+	"hello.dont_know": {
+		LangJapanese: "わからない",
+		LangEnglish:  "I don't know",
+	},
+	"hello.answer_yes": {
+		LangJapanese: "👍 よかった！",
+		LangEnglish:  "👍 Glad to hear it!",
+	},
+	"hello.answer_no": {
+		LangJapanese: "👌 了解です",
+		LangEnglish:  "👌 Got it",
+	},
+
+	// --- /whoami・/amount ---
+	"whoami.body": {
+		LangJapanese: "👤 **あなたの情報**\n```\nユーザーID: %s\nユーザー名: %s\n表示名: %s\n現在のPayer: %s\n```\n💡 この情報を使ってPayerを設定できます！",
+		LangEnglish:  "👤 **Your info**\n```\nUser ID: %s\nUsername: %s\nDisplay name: %s\nCurrent payer: %s\n```\n💡 Use this info to configure your payer!",
+	},
+	"amount.title": {
+		LangJapanese: "**%sの記録**",
+		LangEnglish:  "**Records for %s**",
+	},
+	"amount.refunds": {
+		LangJapanese: "↩️ 今月の返品・返金: %s（%d件）\n🧾 返品・返金を差し引いた今月の合計: %s",
+		LangEnglish:  "↩️ Refunds this month: %s (%d)\n🧾 Net total this month after refunds: %s",
+	},
+	"gas.fetch_failed": {
+		LangJapanese: "データの取得に失敗しました",
+		LangEnglish:  "Failed to fetch the data",
+	},
+	"gas.read_failed": {
+		LangJapanese: "データの読み取りに失敗しました",
+		LangEnglish:  "Failed to read the data",
+	},
+	"gas.parse_failed": {
+		LangJapanese: "データの解析に失敗しました",
+		LangEnglish:  "Failed to parse the data",
+	},
+	"gas.append_failed": {
+		LangJapanese: "記録の追加に失敗しました: %v",
+		LangEnglish:  "Failed to add the entry: %v",
+	},
+
+	// --- Record this receipt ---
+	"record.target_not_found": {
+		LangJapanese: "❌ 対象のメッセージを取得できませんでした",
+		LangEnglish:  "❌ Could not load the target message",
+	},
+	"record.no_receipts": {
+		LangJapanese: "🙅 このメッセージにはレシートとして処理できる画像・PDFがありません",
+		LangEnglish:  "🙅 This message has no images or PDFs that can be recorded as receipts",
+	},
+	"record.recording": {
+		LangJapanese: "🧾 %d個の画像をレシートとして記録します",
+		LangEnglish:  "🧾 Recording %d image(s) as receipts",
+	},
+	"record.ask_payer": {
+		LangJapanese: "🧾 %d個の画像をレシートとして記録します。支払ったのは誰ですか？",
+		LangEnglish:  "🧾 Recording %d image(s) as receipts. Who paid?",
+	},
+	"record.payer_self": {
+		LangJapanese: "自分（%s）",
+		LangEnglish:  "Me (%s)",
+	},
+	"record.payer_author": {
+		LangJapanese: "投稿者（%s）",
+		LangEnglish:  "Author (%s)",
+	},
+	"record.invalid_button": {
+		LangJapanese: "❌ ボタンの情報が不正です",
+		LangEnglish:  "❌ This button is no longer valid",
+	},
+	"record.recording_for": {
+		LangJapanese: "🧾 %sさんの支払いとして%d個の画像を記録します",
+		LangEnglish:  "🧾 Recording %[2]d image(s) as paid by %[1]s",
+	},
+
+	// --- 再処理 ---
+	"jobs.interrupted": {
+		LangJapanese: "Botの再起動により処理が中断されました",
+		LangEnglish:  "Processing was interrupted by a bot restart",
+	},
+	"retry.button": {
+		LangJapanese: "再処理",
+		LangEnglish:  "Retry",
+	},
+	"retry.processing": {
+		LangJapanese: "⏳ このレシートは処理中です",
+		LangEnglish:  "⏳ This receipt is being processed",
+	},
+	"retry.already_done": {
+		LangJapanese: "✅ このレシートは既に記録済みです",
+		LangEnglish:  "✅ This receipt has already been recorded",
+	},
+	"retry.not_found": {
+		LangJapanese: "❌ 処理履歴が見つかりませんでした。画像をもう一度送信してください",
+		LangEnglish:  "❌ No processing history was found. Please upload the image again",
+	},
+	"retry.none": {
+		LangJapanese: "🙆 過去%d日間に失敗したレシートはありません",
+		LangEnglish:  "🙆 No receipts failed in the last %d day(s)",
+	},
+	"retry.started": {
+		LangJapanese: "🔁 過去%d日間に失敗した%d件のレシートを再処理します",
+		LangEnglish:  "🔁 Retrying receipts that failed in the last %d day(s): %d",
+	},
+
+	// --- 処理状況（ステータスメッセージ） ---
+	"stage.queued": {
+		LangJapanese: "⏸️ 待機中",
+		LangEnglish:  "⏸️ Queued",
+	},
+	"stage.downloading": {
+		LangJapanese: "📥 ダウンロード中",
+		LangEnglish:  "📥 Downloading",
+	},
+	"stage.compressing": {
+		LangJapanese: "🗜️ 圧縮中",
+		LangEnglish:  "🗜️ Compressing",
+	},
+	"stage.uploading": {
+		LangJapanese: "☁️ アップロード中",
+		LangEnglish:  "☁️ Uploading",
+	},
+	"stage.workflow": {
+		LangJapanese: "🤖 解析中",
+		LangEnglish:  "🤖 Analyzing",
+	},
+	"stage.done": {
+		LangJapanese: "✅ 完了",
+		LangEnglish:  "✅ Done",
+	},
+	"stage.warning": {
+		LangJapanese: "⚠️ Dify内部エラー",
+		LangEnglish:  "⚠️ Dify internal error",
+	},
+	"stage.failed": {
+		LangJapanese: "❌ 失敗",
+		LangEnglish:  "❌ Failed",
+	},
+	"status.title_processing": {
+		LangJapanese: "🖼️ %d個の画像を処理中です...",
+		LangEnglish:  "🖼️ Processing %d image(s)...",
+	},
+	"status.title_done": {
+		LangJapanese: "🖼️ %d個の画像の処理結果",
+		LangEnglish:  "🖼️ Results for %d image(s)",
+	},
+
+	// --- サマリー ---
+	"summary.all_success": {
+		LangJapanese: "🎉 全ての画像処理が完了しました！\n✅ 成功: %d個",
+		LangEnglish:  "🎉 All images were processed!\n✅ Succeeded: %d",
+	},
+	"summary.partial": {
+		LangJapanese: "⚠️ 一部の画像処理が完了しました。\n✅ 成功: %d個\n❌ 失敗: %d個",
+		LangEnglish:  "⚠️ Some images were processed.\n✅ Succeeded: %d\n❌ Failed: %d",
+	},
+	"summary.all_failed": {
+		LangJapanese: "❌ 全ての画像処理が失敗しました。\n✅ 成功: %d個\n❌ 失敗: %d個",
+		LangEnglish:  "❌ All images failed to process.\n✅ Succeeded: %d\n❌ Failed: %d",
+	},
+	"summary.thread_link": {
+		LangJapanese: "🧵 詳細: <#%s>",
+		LangEnglish:  "🧵 Details: <#%s>",
+	},
+	"receipt.thread_name": {
+		LangJapanese: "🧾 %s のレシート (%s)",
+		LangEnglish:  "🧾 %s's receipts (%s)",
+	},
+
+	// --- レシート処理の失敗 ---
+	"receipt.download_failed": {
+		LangJapanese: "ダウンロードに失敗しました: %v",
+		LangEnglish:  "Download failed: %v",
+	},
+	"receipt.unsupported_type": {
+		LangJapanese: "🙅 レシートとして読み取れない形式です（%s）。JPEG・PNG・HEIC などの画像かPDFを送ってください。",
+		LangEnglish:  "🙅 This file type cannot be read as a receipt (%s). Please send an image such as JPEG, PNG or HEIC, or a PDF.",
+	},
+	"receipt.convert_failed": {
+		LangJapanese: "変換に失敗しました: %v",
+		LangEnglish:  "Conversion failed: %v",
+	},
+	"receipt.compress_failed": {
+		LangJapanese: "圧縮に失敗しました: %v",
+		LangEnglish:  "Compression failed: %v",
+	},
+	"receipt.upload_failed": {
+		LangJapanese: "Difyアップロードに失敗しました: %v",
+		LangEnglish:  "Upload to Dify failed: %v",
+	},
+	"receipt.ledger_failed": {
+		LangJapanese: "家計簿への記録に失敗しました: %v（「🔁 再処理」では読み取った内容で記録だけやり直します）",
+		LangEnglish:  "could not record the receipt in the ledger: %v (Retry records the receipt again without re-reading it)",
+	},
+	"receipt.rate_missing": {
+		LangJapanese: "%s の為替レートが未設定のため換算できません。`/rate set` で設定してから再処理してください",
+		LangEnglish:  "no exchange rate is set for %s. Set one with `/rate set`, then retry",
+	},
+	"receipt.workflow_failed": {
+		LangJapanese: "Dify処理に失敗しました: %v",
+		LangEnglish:  "Dify processing failed: %v",
+	},
+
+	// --- レシートの結果（埋め込み） ---
+	"result.done_raw": {
+		LangJapanese: "✅ Dify処理が完了しました",
+		LangEnglish:  "✅ Dify processing completed",
+	},
+	"result.untitled": {
+		LangJapanese: "🧾 レシート",
+		LangEnglish:  "🧾 Receipt",
+	},
+	"result.amount": {
+		LangJapanese: "💰 金額",
+		LangEnglish:  "💰 Amount",
+	},
+	"result.category": {
+		LangJapanese: "🏷️ カテゴリ",
+		LangEnglish:  "🏷️ Category",
+	},
+	"result.date": {
+		LangJapanese: "📅 日付",
+		LangEnglish:  "📅 Date",
+	},
+	"result.payer": {
+		LangJapanese: "👤 支払った人",
+		LangEnglish:  "👤 Paid by",
+	},
+	"result.item": {
+		LangJapanese: "📝 項目",
+		LangEnglish:  "📝 Item",
+	},
+	"result.warning_title": {
+		LangJapanese: "⚠️ Difyワークフローは実行されましたが、内部でエラーが発生しました",
+		LangEnglish:  "⚠️ The Dify workflow ran but hit an internal error",
+	},
+	"result.failure_title": {
+		LangJapanese: "❌ レシートを記録できませんでした",
+		LangEnglish:  "❌ Could not record the receipt",
+	},
+	"result.refund_title": {
+		LangJapanese: "↩️ 返品・返金: %s",
+		LangEnglish:  "↩️ Refund: %s",
+	},
+	"result.refund_original": {
+		LangJapanese: "🔗 元の記録",
+		LangEnglish:  "🔗 Original entry",
+	},
+	"result.refund_no_original": {
+		LangJapanese: "見つかりませんでした（返品・返金だけを記録しました）",
+		LangEnglish:  "Not found (recorded the refund on its own)",
+	},
+	"result.converted": {
+		LangJapanese: "≒ %s（1 %s = %s）",
+		LangEnglish:  "≈ %s (1 %s = %s)",
+	},
+	"result.ledger_foreign": {
+		LangJapanese: "⚠️ 家計簿の金額",
+		LangEnglish:  "⚠️ Ledger amount",
+	},
+	"result.ledger_foreign_dify": {
+		LangJapanese: "家計簿にはDifyのワークフローが記録した外貨の金額のまま入っています。換算した金額と元の金額で記録するには `LEDGER_WRITER=bot` を設定してください",
+		LangEnglish:  "The ledger has the foreign-currency amount written by the Dify workflow. Set `LEDGER_WRITER=bot` to record the converted and original amounts",
+	},
+	"result.rate_missing": {
+		LangJapanese: "⚠️ %s の為替レートが未設定のため換算できません（`/rate set` で設定できます）",
+		LangEnglish:  "⚠️ No exchange rate is set for %s (use `/rate set`)",
+	},
+
+	// 返品・返金
+	"refund.button": {
+		LangJapanese: "返品・返金として記録",
+		LangEnglish:  "Record as refund",
+	},
+	"refund.already": {
+		LangJapanese: "↩️ このレシートは既に返品・返金として記録されています",
+		LangEnglish:  "↩️ This receipt is already recorded as a refund",
+	},
+	"refund.save_failed": {
+		LangJapanese: "❌ 処理履歴を保存できなかったため、返品・返金に変更できませんでした: %v",
+		LangEnglish:  "❌ Could not save the history, so the receipt was not changed to a refund: %v",
+	},
+	"refund.ledger_failed": {
+		LangJapanese: "❌ 家計簿に返品・返金を記録できませんでした: %v",
+		LangEnglish:  "❌ Could not record the refund in the ledger: %v",
+	},
+	"refund.ledger_partial": {
+		LangJapanese: "⚠️ 購入として記録した行は打ち消しましたが、返品・返金の行を記録できませんでした: %v\nスプレッドシートに %s の行を追加してください",
+		LangEnglish:  "⚠️ The purchase row was cancelled, but the refund row could not be recorded: %v\nPlease add a row of %s to the spreadsheet",
+	},
+	"refund.not_found": {
+		LangJapanese: "❌ 記録が見つかりませんでした（記録できたレシートだけ返品・返金にできます）",
+		LangEnglish:  "❌ No entry was found (only recorded receipts can be marked as refunds)",
+	},
+
+	// /recurring
+	"recurring.added": {
+		LangJapanese: "🔁 定期支出を登録しました: **%s** %s（毎月%d日、%s、%s）\n📅 次回の記録: %s ・ ID: `%s`",
+		LangEnglish:  "🔁 Recurring expense added: **%s** %s (day %d of each month, %s, %s)\n📅 Next entry: %s ・ ID: `%s`",
+	},
+	"recurring.invalid_amount": {
+		LangJapanese: "❌ 金額として読み取れません（0より大きい金額を指定してください）: %s",
+		LangEnglish:  "❌ Cannot read the amount (it must be greater than 0): %s",
+	},
+	"recurring.invalid_currency": {
+		LangJapanese: "❌ 定期支出の金額は基準通貨（%s）で指定してください",
+		LangEnglish:  "❌ Recurring expenses must be in the base currency (%s)",
+	},
+	"recurring.list_title": {
+		LangJapanese: "🔁 **定期支出**",
+		LangEnglish:  "🔁 **Recurring expenses**",
+	},
+	"recurring.list_item": {
+		LangJapanese: "[%s] 毎月%d日 %s %s（%s、%s）次回: %s",
+		LangEnglish:  "[%s] day %d %s %s (%s, %s) next: %s",
+	},
+	"recurring.list_empty": {
+		LangJapanese: "🔁 定期支出は登録されていません。`/recurring add` で登録できます",
+		LangEnglish:  "🔁 No recurring expenses. Use `/recurring add` to add one",
+	},
+	"recurring.not_found": {
+		LangJapanese: "❌ 定期支出が見つかりませんでした: %s",
+		LangEnglish:  "❌ Recurring expense not found: %s",
+	},
+	"recurring.save_failed": {
+		LangJapanese: "❌ 定期支出を保存できなかったため、変更を取り消しました: %v",
+		LangEnglish:  "❌ Could not save the recurring expenses, so the change was undone: %v",
+	},
+	"recurring.removed": {
+		LangJapanese: "🗑️ 定期支出を削除しました: **%s** %s",
+		LangEnglish:  "🗑️ Recurring expense removed: **%s** %s",
+	},
+	"recurring.recorded": {
+		LangJapanese: "🔁 定期支出を家計簿に記録しました: **%s** %s（%s、%s）・ %s",
+		LangEnglish:  "🔁 Recorded a recurring expense: **%s** %s (%s, %s) ・ %s",
+	},
+	"recurring.caught_up": {
+		LangJapanese: "⏰ Botの停止中に予定日を過ぎたため、遅れて記録しました",
+		LangEnglish:  "⏰ Recorded late because the bot was offline on the due date",
+	},
+
+	// 週間・月間サマリー
+	"report.weekly_title": {
+		LangJapanese: "📊 週間サマリー（%s〜%s）",
+		LangEnglish:  "📊 Weekly summary (%s – %s)",
+	},
+	"report.monthly_title": {
+		LangJapanese: "📊 月間サマリー（%s〜%s）",
+		LangEnglish:  "📊 Monthly summary (%s – %s)",
+	},
+	"report.total": {
+		LangJapanese: "💰 合計 **%s**（%d件）",
+		LangEnglish:  "💰 Total **%s** (%d entries)",
+	},
+	"report.vs_previous_weekly": {
+		LangJapanese: "📈 先週比 %s",
+		LangEnglish:  "📈 vs previous week %s",
+	},
+	"report.vs_previous_monthly": {
+		LangJapanese: "📈 先月比 %s",
+		LangEnglish:  "📈 vs previous month %s",
+	},
+	"report.categories": {
+		LangJapanese: "🏷️ カテゴリ別",
+		LangEnglish:  "🏷️ By category",
+	},
+	"report.payers": {
+		LangJapanese: "👤 支払った人別",
+		LangEnglish:  "👤 By payer",
+	},
+	"report.stores": {
+		LangJapanese: "🏪 よく使ったお店",
+		LangEnglish:  "🏪 Top stores",
+	},
+	"report.store_line": {
+		LangJapanese: "%d. %s %s（%d回）",
+		LangEnglish:  "%d. %s %s (%d visits)",
+	},
+	"report.more": {
+		LangJapanese: "ほか%d件",
+		LangEnglish:  "and %d more",
+	},
+	"report.unnamed": {
+		LangJapanese: "（未設定）",
+		LangEnglish:  "(none)",
+	},
+	"report.empty": {
+		LangJapanese: "この期間の記録はありません",
+		LangEnglish:  "No entries in this period",
+	},
+
+	// /chart
+	"chart.title_category": {
+		LangJapanese: "📊 カテゴリ別の支出（%s）",
+		LangEnglish:  "📊 Spending by category (%s)",
+	},
+	"chart.title_daily": {
+		LangJapanese: "📈 支出の累計の推移（%s）",
+		LangEnglish:  "📈 Cumulative spending (%s)",
+	},
+	"chart.title_monthly": {
+		LangJapanese: "📈 月ごとの支出（%s）",
+		LangEnglish:  "📈 Monthly spending (%s)",
+	},
+	"chart.period_month": {
+		LangJapanese: "%d年%d月",
+		LangEnglish:  "%[2]s %[1]d",
+	},
+	"chart.period_year": {
+		LangJapanese: "%d年",
+		LangEnglish:  "%d",
+	},
+	"chart.others": {
+		LangJapanese: "その他",
+		LangEnglish:  "Others",
+	},
+	"chart.no_data": {
+		LangJapanese: "📭 この期間にはグラフにできる記録がありません",
+		LangEnglish:  "📭 There are no entries to chart in this period",
+	},
+	"chart.render_failed": {
+		LangJapanese: "❌ グラフの画像を作成できませんでした: %v",
+		LangEnglish:  "❌ Could not render the chart image: %v",
+	},
+
+	// 金額
+	"money.invalid": {
+		LangJapanese: "金額として読み取れません: %q",
+		LangEnglish:  "Cannot read as an amount: %q",
+	},
+
+	// /rate
+	"rate.set": {
+		LangJapanese: "💱 為替レートを設定しました: 1 %s = %s",
+		LangEnglish:  "💱 Exchange rate set: 1 %s = %s",
+	},
+	"rate.invalid_currency": {
+		LangJapanese: "❌ 通貨コードが不正です（例: USD, EUR, KRW）: %s",
+		LangEnglish:  "❌ Invalid currency code (e.g. USD, EUR, KRW): %s",
+	},
+	"rate.base_currency": {
+		LangJapanese: "❌ %s は家計簿の基準通貨のためレートは設定できません",
+		LangEnglish:  "❌ %s is the base currency of the ledger and cannot have a rate",
+	},
+	"rate.save_failed": {
+		LangJapanese: "❌ 為替レート表を保存できなかったため、変更を取り消しました: %v",
+		LangEnglish:  "❌ Could not save the exchange rates, so the change was undone: %v",
+	},
+	"rate.invalid_rate": {
+		LangJapanese: "❌ レートには0より大きい数を指定してください",
+		LangEnglish:  "❌ The rate must be greater than 0",
+	},
+	"rate.list_title": {
+		LangJapanese: "💱 為替レート（基準通貨: %s）",
+		LangEnglish:  "💱 Exchange rates (base currency: %s)",
+	},
+	"rate.list_empty": {
+		LangJapanese: "💱 為替レートが設定されていません（基準通貨: %s）。`/rate set` で設定してください",
+		LangEnglish:  "💱 No exchange rates are set (base currency: %s). Use `/rate set` to add one",
+	},
+
+	// --- ダウンロード・画像処理のエラー ---
+	"download.error": {
+		LangJapanese: "ダウンロードエラー: %v",
+		LangEnglish:  "download error: %v",
+	},
+	"download.redirect_blocked": {
+		LangJapanese: "許可されていないURLへのリダイレクトのため取得を中止しました（%s）",
+		LangEnglish:  "stopped following a redirect to a URL that is not allowed (%s)",
+	},
+	"download.too_many_redirects": {
+		LangJapanese: "リダイレクトが多すぎます",
+		LangEnglish:  "too many redirects",
+	},
+	"download.status": {
+		LangJapanese: "ダウンロード失敗 (ステータス: %d)",
+		LangEnglish:  "download failed (status: %d)",
+	},
+	"download.content_type": {
+		LangJapanese: "ファイルの種類が一致しません（%s）",
+		LangEnglish:  "unexpected file type (%s)",
+	},
+	"download.too_large": {
+		LangJapanese: "ファイルサイズが上限を超えています（%d bytes > %d bytes）",
+		LangEnglish:  "file is too large (%d bytes > %d bytes)",
+	},
+	"download.too_large_limit": {
+		LangJapanese: "ファイルサイズが上限を超えています（上限: %d bytes）",
+		LangEnglish:  "file is too large (limit: %d bytes)",
+	},
+	"image.decode_error": {
+		LangJapanese: "画像読み込みエラー: %v",
+		LangEnglish:  "could not read the image: %v",
+	},
+	"image.encode_error": {
+		LangJapanese: "画像エンコードエラー: %v",
+		LangEnglish:  "could not encode the image: %v",
+	},
+	"image.target_size": {
+		LangJapanese: "画像を目標サイズ（%d bytes）以内に圧縮できませんでした",
+		LangEnglish:  "could not compress the image under the target size (%d bytes)",
+	},
+	"heic.no_proxy": {
+		LangJapanese: "HEIC画像はDiscordに直接添付した場合のみ変換できます（URLで貼り付けた画像は変換できません）。画像を添付し直すか、iPhoneの「設定 > カメラ > フォーマット」で「互換性優先」を選んでJPEGで送信してください",
+		LangEnglish:  "HEIC images can only be converted when attached directly to Discord (pasted image URLs cannot be converted). Attach the image again, or choose \"Most Compatible\" in iPhone Settings > Camera > Formats and send a JPEG",
+	},
+	"heic.url_error": {
+		LangJapanese: "HEIC変換用URLの解析エラー: %v",
+		LangEnglish:  "could not parse the HEIC conversion URL: %v",
+	},
+	"heic.convert_failed": {
+		LangJapanese: "HEIC画像のJPEG変換に失敗しました: %v",
+		LangEnglish:  "could not convert the HEIC image to JPEG: %v",
+	},
+	"heic.decode_failed": {
+		LangJapanese: "HEIC画像をデコードできませんでした。iPhoneの「設定 > カメラ > フォーマット」で「互換性優先」を選ぶか、スクリーンショットを送信してください",
+		LangEnglish:  "could not decode the HEIC image. Choose \"Most Compatible\" in iPhone Settings > Camera > Formats, or send a screenshot",
+	},
+	"pdf.compress_error": {
+		LangJapanese: "PDFページ画像の圧縮エラー: %v",
+		LangEnglish:  "could not compress a PDF page image: %v",
+	},
+
+	// --- Dify APIのエラー ---
+	"dify.api_key_missing": {
+		LangJapanese: "DIFY_API_KEYが設定されていません",
+		LangEnglish:  "DIFY_API_KEY is not set",
+	},
+	"dify.form_error": {
+		LangJapanese: "フォームパート作成エラー: %v",
+		LangEnglish:  "could not create the form part: %v",
+	},
+	"dify.copy_error": {
+		LangJapanese: "ファイルコピーエラー: %v",
+		LangEnglish:  "could not copy the file: %v",
+	},
+	"dify.writer_error": {
+		LangJapanese: "writer closeエラー: %v",
+		LangEnglish:  "could not close the writer: %v",
+	},
+	"dify.marshal_error": {
+		LangJapanese: "JSONマーシャルエラー: %v",
+		LangEnglish:  "could not encode JSON: %v",
+	},
+	"dify.request_error": {
+		LangJapanese: "リクエスト作成エラー: %v",
+		LangEnglish:  "could not create the request: %v",
+	},
+	"dify.send_error": {
+		LangJapanese: "リクエスト送信エラー: %v",
+		LangEnglish:  "could not send the request: %v",
+	},
+	"dify.read_error": {
+		LangJapanese: "レスポンス読み取りエラー: %v",
+		LangEnglish:  "could not read the response: %v",
+	},
+	"dify.upload_failed": {
+		LangJapanese: "アップロード失敗 (ステータス: %d): %s",
+		LangEnglish:  "upload failed (status: %d): %s",
+	},
+	"dify.json_error": {
+		LangJapanese: "JSONパースエラー: %v, レスポンス: %s",
+		LangEnglish:  "could not parse JSON: %v, response: %s",
+	},
+	"dify.workflow_failed": {
+		LangJapanese: "ワークフロー実行失敗 (ステータス: %d): %s",
+		LangEnglish:  "workflow run failed (status: %d): %s",
+	},
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// APP2のICCプロファイルの識別子
+const jpegICCProfilePrefix = "ICC_PROFILE\x00"
+
+// JPEGの取り除くメタデータ系セグメント（APP1: EXIF/XMP, APP2: FlashPixなど, APP13: IPTC, COM: コメント）
+// APP2のICCプロファイルは個人情報ではなく色の再現に必要なため残す
+func isJPEGMetadataSegment(marker byte, payload []byte) bool {
+	switch marker {
+	case 0xE1, 0xED, 0xFE:
+		return true
+	case 0xE2:
+		return !bytes.HasPrefix(payload, []byte(jpegICCProfilePrefix))
+	}
+	return false
+}
+
+// JPEGからEXIF/XMPなどのメタデータを再エンコードせずに取り除く
+// EXIFのOrientationだけは残し（向き補正をしない設定でも表示側で回転できるように）、ICCプロファイルも残す
+// JPEGとして解析できない場合は元のデータをそのまま返す
+func StripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+
+	orientation := ReadJPEGOrientation(data)
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data
+		}
+		marker := data[pos+1]
+
+		// SOS以降は画像データなので残り全てをコピーする
+		if marker == 0xDA {
+			out.Write(data[pos:])
+			return out.Bytes()
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return data
+		}
+
+		payload := data[pos+4 : end]
+		if !isJPEGMetadataSegment(marker, payload) {
+			out.Write(data[pos:end])
+		} else if marker == 0xE1 && orientation != 1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			// EXIFはOrientationだけの最小のものに置き換える
+			out.Write(JPEGOrientationSegment(orientation))
+			orientation = 1
+		}
+		pos = end
+	}
+
+	return data
+}
+
+// EXIFのOrientationだけを持つAPP1セグメント（マーカーを含む）
+func JPEGOrientationSegment(orientation int) []byte {
+	// TIFF(ビッグエンディアン): ヘッダー + IFD0（Orientationの1項目のみ）
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// JPEGのICCプロファイルのセグメント（マーカーを含む）を取り出す
+func JPEGICCSegments(data []byte) [][]byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	var segments [][]byte
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA {
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return segments
+		}
+		if data[pos+1] == 0xE2 && bytes.HasPrefix(data[pos+4:end], []byte(jpegICCProfilePrefix)) {
+			segments = append(segments, data[pos:end])
+		}
+		pos = end
+	}
+	return segments
+}
+
+// JPEGのSOIの直後にセグメントを挿入する（再エンコードした画像に向き・ICCプロファイルを付け直す）
+func InsertJPEGSegments(data []byte, segments ...[]byte) []byte {
+	if len(segments) == 0 || len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	for _, segment := range segments {
+		out.Write(segment)
+	}
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+// PNGのメタデータ系チャンク（eXIf, tEXt, iTXt, zTXt, tIME）
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "tEXt": true, "iTXt": true, "zTXt": true, "tIME": true,
+}
+
+// PNGからEXIF・テキストなどのメタデータチャンクを取り除く
+// PNGとして解析できない場合は元のデータをそのまま返す
+func StripPNGMetadata(data []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return data
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+
+	pos := len(signature)
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + length // 長さ(4) + 種別(4) + データ + CRC(4)
+		if length < 0 || end > len(data) {
+			return data
+		}
+
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+
+		if chunkType == "IEND" {
+			return out.Bytes()
+		}
+	}
+
+	return data
+}
+
+// JPEGのEXIFからOrientation（1〜8）を読み取る。見つからない場合は1を返す
+func ReadJPEGOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA {
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+
+		segment := data[pos+4 : end]
+		if data[pos+1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return readTIFFOrientation(segment[6:])
+		}
+		pos = end
+	}
+
+	return 1
+}
+
+// TIFFヘッダー＋IFD0からOrientationタグ（0x0112）を探す
+func readTIFFOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
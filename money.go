@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 通貨記号・日本語の通貨名と通貨コードの対応
+// 金額の前後から探す時に "US$" が "$" より先に一致するよう、長いものから順に並べる
+var currencyAliases = []struct {
+	alias string
+	code  string
+}{
+	{"ユーロ", "EUR"},
+	{"ウォン", "KRW"},
+	{"ポンド", "GBP"},
+	{"ドル", "USD"},
+	{"US$", "USD"},
+	{"円", "JPY"},
+	{"€", "EUR"},
+	{"₩", "KRW"},
+	{"¥", "JPY"},
+	{"£", "GBP"},
+	{"$", "USD"},
+}
+
+// 英語表記で金額の前に付ける通貨記号
+var currencySymbols = map[string]string{
+	"JPY": "¥",
+	"USD": "$",
+	"EUR": "€",
+	"KRW": "₩",
+	"GBP": "£",
+}
+
+// 小数点以下を使わない通貨
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+	"KRW": true,
+	"VND": true,
+	"TWD": true,
+}
+
+// 通貨コード（USDなど）・通貨記号を大文字の通貨コードにする
+// 通貨コードとして扱えない場合はfalseを返す
+func NormalizeCurrency(s string) (string, bool) {
+	s = strings.TrimSpace(foldWidth(s))
+	for _, a := range currencyAliases {
+		if a.alias == s {
+			return a.code, true
+		}
+	}
+	s = strings.ToUpper(s)
+	if len(s) != 3 {
+		return "", false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// 通貨の小数点以下の桁数
+func currencyDecimals(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+// 金額（通貨の最小単位の整数で持つため、小数の計算で誤差が出ない）
+type Money struct {
+	Minor    int64  `json:"minor"`    // 最小単位（円・セントなど）での金額
+	Currency string `json:"currency"` // 通貨コード
+}
+
+// 小数の金額から作成する（Difyが数値で返した金額など、最小単位で四捨五入する）
+func NewMoney(amount float64, currency string) Money {
+	scale := math.Pow10(currencyDecimals(currency))
+	return Money{Minor: int64(math.Round(amount * scale)), Currency: currency}
+}
+
+// 金額を読み取る
+// "¥1,234"・"1234円"・"１，２３４"・"-500"・"△500"・"(500)"・"12.50 USD"・"$12.5" などに対応する
+// 通貨が書かれていない場合はdefaultCurrencyとして扱う
+func ParseMoney(s, defaultCurrency string) (Money, error) {
+	text := strings.TrimSpace(foldWidth(s))
+	negative := false
+	currency := ""
+
+	// 会計でよく使うマイナスの書き方: (500)、△500、▲500
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		negative = true
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+
+	// 符号・通貨記号・通貨コードは数字の前後どちらにも書ける（"-¥1,234"、"¥-1,234"、"1,234円"、"USD 12.50"）
+	for changed := true; changed; {
+		changed = false
+		for _, sign := range []string{"-", "△", "▲"} {
+			if strings.HasPrefix(text, sign) {
+				if negative {
+					return Money{}, NewLocalizedError("money.invalid", s)
+				}
+				negative = true
+				text = strings.TrimSpace(strings.TrimPrefix(text, sign))
+				changed = true
+			}
+		}
+		if code, rest, ok := cutCurrencyPrefix(text); ok && currency == "" {
+			currency = code
+			text = rest
+			changed = true
+		}
+	}
+	if code, rest, ok := cutCurrencySuffix(text); ok && currency == "" {
+		currency = code
+		text = rest
+	}
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	minor, err := parseMinorUnits(text, currencyDecimals(currency))
+	if err != nil {
+		return Money{}, NewLocalizedError("money.invalid", s)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// 先頭の通貨記号・通貨コードを取り除く
+func cutCurrencyPrefix(text string) (string, string, bool) {
+	for _, a := range currencyAliases {
+		if strings.HasPrefix(text, a.alias) && !isCurrencyWord(a.alias) {
+			return a.code, strings.TrimSpace(strings.TrimPrefix(text, a.alias)), true
+		}
+	}
+	if len(text) > 3 {
+		if code, ok := NormalizeCurrency(text[:3]); ok && text[:3] == strings.ToUpper(text[:3]) {
+			rest := strings.TrimSpace(text[3:])
+			if rest != "" && !unicode.IsLetter(rune(rest[0])) {
+				return code, rest, true
+			}
+		}
+	}
+	return "", text, false
+}
+
+// 末尾の通貨記号・通貨名・通貨コードを取り除く
+func cutCurrencySuffix(text string) (string, string, bool) {
+	for _, a := range currencyAliases {
+		if strings.HasSuffix(text, a.alias) {
+			return a.code, strings.TrimSpace(strings.TrimSuffix(text, a.alias)), true
+		}
+	}
+	if len(text) > 3 {
+		suffix := text[len(text)-3:]
+		if code, ok := NormalizeCurrency(suffix); ok && suffix == strings.ToUpper(suffix) {
+			return code, strings.TrimSpace(text[:len(text)-3]), true
+		}
+	}
+	return "", text, false
+}
+
+// 通貨名（円・ドルなど）は数字の後ろにだけ書く
+func isCurrencyWord(alias string) bool {
+	for _, r := range alias {
+		if unicode.In(r, unicode.Han, unicode.Katakana) {
+			return true
+		}
+	}
+	return false
+}
+
+// "1,234.56" を最小単位の整数にする（小数点以下が多い場合は四捨五入する）
+// 3桁区切りのカンマは任意だが、位置が正しくない場合はエラーにする
+func parseMinorUnits(text string, decimals int) (int64, error) {
+	intPart, fracPart, hasFrac := strings.Cut(text, ".")
+	if intPart == "" || (hasFrac && fracPart == "") {
+		return 0, fmt.Errorf("数字がありません: %q", text)
+	}
+
+	groups := strings.Split(intPart, ",")
+	for i, group := range groups {
+		if !isDigits(group) || (i > 0 && len(group) != 3) || (len(groups) > 1 && (len(groups[0]) == 0 || len(groups[0]) > 3)) {
+			return 0, fmt.Errorf("数字の形式が不正です: %q", text)
+		}
+	}
+	if hasFrac && !isDigits(fracPart) {
+		return 0, fmt.Errorf("数字の形式が不正です: %q", text)
+	}
+
+	// 最小単位の桁数に合わせる（足りない分は0を補い、多い分は四捨五入する）
+	roundUp := false
+	if len(fracPart) > decimals {
+		roundUp = fracPart[decimals] >= '5'
+		fracPart = fracPart[:decimals]
+	}
+	fracPart += strings.Repeat("0", decimals-len(fracPart))
+
+	digits := strings.TrimLeft(strings.Join(groups, "")+fracPart, "0")
+	if digits == "" {
+		digits = "0"
+	}
+	if len(digits) > 15 {
+		return 0, fmt.Errorf("金額が大きすぎます: %q", text)
+	}
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if roundUp {
+		minor++
+	}
+	return minor, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 全角英数字・記号を半角にする（￥は¥、マイナス記号（−）は-にする）
+func foldWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '！' && r <= '～':
+			return r - '！' + '!'
+		case r == '￥':
+			return '¥'
+		case r == '−' || r == '‐' || r == '‑':
+			return '-'
+		case r == '　':
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// 小数の金額（表示・グラフなど誤差が問題にならない用途のみ）
+func (m Money) Float() float64 {
+	return float64(m.Minor) / math.Pow10(currencyDecimals(m.Currency))
+}
+
+// 0円かどうか
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// マイナスの金額にする（返金など）
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+// 同じ通貨の金額を足す（通貨が異なる場合はfalseを返す）
+func (m Money) Add(other Money) (Money, bool) {
+	if m.Currency != other.Currency {
+		return m, false
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, true
+}
+
+// レートで別の通貨に換算する（換算先の最小単位で四捨五入する）
+func (m Money) Convert(rate float64, currency string) Money {
+	// レートは入力された10進数のまま計算する（150.1 などが2進数の誤差を含まないように）
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	if !ok {
+		return NewMoney(m.Float()*rate, currency)
+	}
+	value := new(big.Rat).SetFrac(big.NewInt(m.Minor), big.NewInt(int64(math.Pow10(currencyDecimals(m.Currency)))))
+	value.Mul(value, r)
+	value.Mul(value, new(big.Rat).SetInt64(int64(math.Pow10(currencyDecimals(currency)))))
+
+	// 0から遠い方向に四捨五入する
+	half := big.NewRat(1, 2)
+	if value.Sign() < 0 {
+		value.Sub(value, half)
+	} else {
+		value.Add(value, half)
+	}
+	minor := new(big.Int).Quo(value.Num(), value.Denom())
+	return Money{Minor: minor.Int64(), Currency: currency}
+}
+
+// 3桁区切りの数字だけの表記（例: "1,234"、"-12.50"）
+func (m Money) Number() string {
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	decimals := currencyDecimals(m.Currency)
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	intPart := digits[:len(digits)-decimals]
+	fracPart := digits[len(digits)-decimals:]
+
+	var result strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			result.WriteString(",")
+		}
+		result.WriteRune(digit)
+	}
+	if fracPart != "" {
+		result.WriteString("." + fracPart)
+	}
+	return sign + result.String()
+}
+
+// 言語に合わせた表記
+// 日本語: "1,234円"・"12.50 USD"、英語: "¥1,234"・"$12.50"・"12.50 CHF"
+func (m Money) Format(lang Lang) string {
+	number := m.Number()
+	if lang == LangEnglish {
+		if symbol, ok := currencySymbols[m.Currency]; ok {
+			if strings.HasPrefix(number, "-") {
+				return "-" + symbol + strings.TrimPrefix(number, "-")
+			}
+			return symbol + number
+		}
+		return number + " " + m.Currency
+	}
+	if m.Currency == "JPY" {
+		return number + "円"
+	}
+	return number + " " + m.Currency
+}
+
+// 通貨が空の古い形式（数値だけ）のJSONも読めるようにする
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount float64
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*m = NewMoney(amount, GetBaseCurrency())
+		return nil
+	}
+	type plain Money
+	return json.Unmarshal(data, (*plain)(m))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PDFの送信モード
+const (
+	PDFModeDocument = "document" // PDFをそのままドキュメントとして送信（デフォルト）
+	PDFModeScanned  = "scanned"  // 画像しか受け付けないワークフロー向け。スキャンPDFの各ページのJPEG画像を取り出して画像として送信
+)
+
+// 環境変数からPDFの送信モードを取得する（以前の名前の image も scanned として扱う）
+func GetPDFMode() string {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("DIFY_PDF_MODE"))) {
+	case PDFModeScanned, "image":
+		return PDFModeScanned
+	}
+	return PDFModeDocument
+}
+
+// 送信モードに合わせて、PDFを画像にして送信する場合のページ画像を返す
+// documentモードではnil（PDFのまま送信）を返す
+// scannedモードでもページを描画する機能はないため、スキャンPDF（各ページがページ全体の1枚のJPEG画像）以外の
+// テキスト・図形で書かれたPDF（ネットショップの領収書など）やロゴ入りのPDFはnilを返し、PDFのまま送信する
+func PDFPageImages(data []byte) [][]byte {
+	if GetPDFMode() != PDFModeScanned {
+		return nil
+	}
+	pages, ok := ExtractScannedPDFPages(data)
+	if !ok {
+		log.Printf("⚠️  スキャンPDFではないため、PDFのまま送信します")
+		return nil
+	}
+	return pages
+}
+
+// PDFをDifyにアップロードする関数
+// pagesがない場合はPDFをそのまま、ある場合は各ページの画像を圧縮して送信する
+func UploadPDFToDify(data []byte, pages [][]byte, filename string) ([]string, string, error) {
+	if len(pages) == 0 {
+		fileID, err := UploadImageToDify(data, filename)
+		if err != nil {
+			return nil, "", err
+		}
+		return []string{fileID}, GetDifyFileType("application/pdf"), nil
+	}
+
+	baseName := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	var fileIDs []string
+	for i, page := range pages {
+		compressed, err := CompressImage(page)
+		if err != nil {
+			return nil, "", NewLocalizedError("pdf.compress_error", err)
+		}
+
+		fileID, err := UploadImageToDify(compressed, fmt.Sprintf("%s_page%d.jpg", baseName, i+1))
+		if err != nil {
+			return nil, "", err
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	return fileIDs, "image", nil
+}
+
+// スキャンPDFの各ページの画像をJPEGとして取り出す関数
+// ページツリーをたどり、全ページがページ全体の1枚のJPEG画像（DCTDecode）の場合だけ取り出す
+// ページを描画するのではないため、それ以外のPDFはfalseを返す
+func ExtractScannedPDFPages(data []byte) ([][]byte, bool) {
+	doc := parsePDF(data)
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, false
+	}
+
+	images := make([][]byte, 0, len(pages))
+	for _, page := range pages {
+		image, ok := doc.scannedPageImage(page)
+		if !ok {
+			return nil, false
+		}
+		images = append(images, image)
+	}
+
+	log.Printf("📄 スキャンPDFから%dページ分の画像を抽出しました", len(images))
+	return images, true
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PDFのオブジェクトとページツリーを読み取る最小限のパーサー
+// スキャンPDFの各ページの画像を取り出すためのもので、ページの描画はしない
+
+// オブジェクトの開始（"12 0 obj"）とトレーラー
+var pdfObjectHeaderPattern = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+var pdfTrailerPattern = regexp.MustCompile(`\btrailer\b`)
+
+// オブジェクトストリームを展開する上限（壊れた・悪意のあるPDFでメモリを使い切らないように）
+const pdfMaxObjectStreamSize = 16 << 20
+
+// PDFのオブジェクト
+type pdfObject struct {
+	value  []byte // 値（辞書など。ストリームのデータは含まない）
+	stream []byte // ストリームのデータ（フィルターは解かない）
+}
+
+// 読み取ったPDF
+type pdfDocument struct {
+	objects map[int]pdfObject
+	root    []byte // カタログ（トレーラー・XRefストリームの /Root。後から追記されたものを優先）
+}
+
+// PDFの空白文字
+func isPDFWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+// PDFの区切り文字
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// 空白とコメントを読み飛ばす
+func pdfSkipSpace(b []byte, pos int) int {
+	for pos < len(b) {
+		switch {
+		case isPDFWhitespace(b[pos]):
+			pos++
+		case b[pos] == '%':
+			for pos < len(b) && b[pos] != '\n' && b[pos] != '\r' {
+				pos++
+			}
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// 次の字句を読む（文字列・16進文字列は1つの字句として返す）。終わりに達した場合はnilを返す
+func pdfNextToken(b []byte, pos int) ([]byte, int) {
+	pos = pdfSkipSpace(b, pos)
+	if pos >= len(b) {
+		return nil, pos
+	}
+	start := pos
+	switch c := b[pos]; {
+	case (c == '<' || c == '>') && pos+1 < len(b) && b[pos+1] == c:
+		return b[pos : pos+2], pos + 2
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		return b[pos : pos+1], pos + 1
+	case c == '(':
+		depth := 0
+		for ; pos < len(b); pos++ {
+			switch b[pos] {
+			case '\\':
+				pos++
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					return b[start : pos+1], pos + 1
+				}
+			}
+		}
+		return b[start:], len(b)
+	case c == '<':
+		end := bytes.IndexByte(b[pos:], '>')
+		if end < 0 {
+			return b[start:], len(b)
+		}
+		return b[start : pos+end+1], pos + end + 1
+	case c == '/':
+		pos++
+	}
+	for pos < len(b) && !isPDFWhitespace(b[pos]) && !isPDFDelimiter(b[pos]) {
+		pos++
+	}
+	if pos == start {
+		// 対応のない ")" や ">" は1文字の字句にする
+		pos++
+	}
+	return b[start:pos], pos
+}
+
+// 0以上の整数の字句かどうか
+func isPDFInteger(tok []byte) bool {
+	if len(tok) == 0 {
+		return false
+	}
+	for _, c := range tok {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 値を1つ読み、その範囲を返す（辞書・配列は対応する閉じ括弧まで、参照は "12 0 R" まで）
+func pdfReadValue(b []byte, pos int) ([]byte, int) {
+	tok, next := pdfNextToken(b, pos)
+	if tok == nil {
+		return nil, next
+	}
+	start := next - len(tok)
+	switch string(tok) {
+	case "<<", "[":
+		for depth := 1; depth > 0; {
+			t, n := pdfNextToken(b, next)
+			if t == nil {
+				return b[start:], len(b)
+			}
+			next = n
+			switch string(t) {
+			case "<<", "[":
+				depth++
+			case ">>", "]":
+				depth--
+			}
+		}
+		return b[start:next], next
+	}
+	if isPDFInteger(tok) {
+		gen, n1 := pdfNextToken(b, next)
+		r, n2 := pdfNextToken(b, n1)
+		if isPDFInteger(gen) && string(r) == "R" {
+			return b[start:n2], n2
+		}
+	}
+	return tok, next
+}
+
+// 辞書の値を取り出す（キーは先頭の / を除いた名前）。辞書でない場合はnilを返す
+func pdfDict(v []byte) map[string][]byte {
+	tok, pos := pdfNextToken(v, 0)
+	if string(tok) != "<<" {
+		return nil
+	}
+	dict := map[string][]byte{}
+	for {
+		key, next := pdfNextToken(v, pos)
+		if key == nil || string(key) == ">>" {
+			return dict
+		}
+		value, n := pdfReadValue(v, next)
+		if value == nil {
+			return dict
+		}
+		if key[0] == '/' {
+			dict[string(key[1:])] = value
+		}
+		pos = n
+	}
+}
+
+// 配列の要素を取り出す。配列でない場合はnilを返す
+func pdfArray(v []byte) [][]byte {
+	tok, pos := pdfNextToken(v, 0)
+	if string(tok) != "[" {
+		return nil
+	}
+	var items [][]byte
+	for {
+		item, next := pdfReadValue(v, pos)
+		if item == nil || string(item) == "]" {
+			return items
+		}
+		items = append(items, item)
+		pos = next
+	}
+}
+
+// 参照（"12 0 R"）のオブジェクト番号
+func pdfRef(v []byte) (int, bool) {
+	fields := strings.Fields(string(v))
+	if len(fields) != 3 || fields[2] != "R" {
+		return 0, false
+	}
+	num, err := strconv.Atoi(fields[0])
+	return num, err == nil
+}
+
+// 数値を読み取る（読み取れない場合は0）
+func pdfNumber(v []byte) float64 {
+	n, _ := strconv.ParseFloat(string(bytes.TrimSpace(v)), 64)
+	return n
+}
+
+// PDFのオブジェクトを読み取る
+// 相互参照表には頼らずにオブジェクトを先頭から探し、圧縮されたオブジェクトストリーム（PDF 1.5以降）の中も読む
+func parsePDF(data []byte) *pdfDocument {
+	doc := &pdfDocument{objects: map[int]pdfObject{}}
+
+	streamEnd := 0 // 直前のストリームの終わり（ストリームのデータの中はオブジェクトとして扱わない）
+	rootPos := -1
+	for _, loc := range pdfObjectHeaderPattern.FindAllSubmatchIndex(data, -1) {
+		if loc[0] < streamEnd {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		if err != nil {
+			continue
+		}
+		value, pos := pdfReadValue(data, loc[1])
+		obj := pdfObject{value: value}
+		dict := pdfDict(value)
+		if tok, next := pdfNextToken(data, pos); string(tok) == "stream" {
+			obj.stream, streamEnd = pdfStreamData(data, next, dict)
+		}
+		// 同じ番号のオブジェクトは後から追記されたものを使う
+		doc.objects[num] = obj
+		if root, ok := dict["Root"]; ok && string(dict["Type"]) == "/XRef" && loc[0] > rootPos {
+			doc.root, rootPos = root, loc[0]
+		}
+	}
+	for _, loc := range pdfTrailerPattern.FindAllIndex(data, -1) {
+		value, _ := pdfReadValue(data, loc[1])
+		if root, ok := pdfDict(value)["Root"]; ok && loc[0] > rootPos {
+			doc.root, rootPos = root, loc[0]
+		}
+	}
+
+	// オブジェクトストリームの中のオブジェクト（ファイルに直接書かれたものを優先）
+	var objectStreams []pdfObject
+	for _, obj := range doc.objects {
+		if obj.stream != nil && string(pdfDict(obj.value)["Type"]) == "/ObjStm" {
+			objectStreams = append(objectStreams, obj)
+		}
+	}
+	for _, obj := range objectStreams {
+		doc.loadObjectStream(obj)
+	}
+
+	return doc
+}
+
+// "stream" の後からストリームのデータを取り出し、データとその終わりの位置を返す
+func pdfStreamData(data []byte, pos int, dict map[string][]byte) ([]byte, int) {
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+	if n, err := strconv.Atoi(string(bytes.TrimSpace(dict["Length"]))); err == nil && n >= 0 && pos+n <= len(data) &&
+		bytes.HasPrefix(bytes.TrimLeft(data[pos+n:], "\r\n \t"), []byte("endstream")) {
+		return data[pos : pos+n], pos + n
+	}
+	// /Length が参照・不正な場合は endstream を探す
+	idx := bytes.Index(data[pos:], []byte("endstream"))
+	if idx < 0 {
+		return nil, len(data)
+	}
+	return bytes.TrimRight(data[pos:pos+idx], "\r\n"), pos + idx
+}
+
+// オブジェクトストリームを展開し、中のオブジェクトを読み込む
+func (doc *pdfDocument) loadObjectStream(obj pdfObject) {
+	dict := pdfDict(obj.value)
+	if !pdfHasOnlyFilter(doc.resolve(dict["Filter"]), "/FlateDecode") {
+		return
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, pdfMaxObjectStreamSize))
+	if err != nil && len(data) == 0 {
+		return
+	}
+
+	count := int(pdfNumber(doc.resolve(dict["N"])))
+	first := int(pdfNumber(doc.resolve(dict["First"])))
+	if first <= 0 || first > len(data) {
+		return
+	}
+	// 先頭は "オブジェクト番号 オフセット" の組が N 個並ぶ
+	pos := 0
+	for i := 0; i < count; i++ {
+		numTok, n1 := pdfNextToken(data[:first], pos)
+		offTok, n2 := pdfNextToken(data[:first], n1)
+		if !isPDFInteger(numTok) || !isPDFInteger(offTok) {
+			return
+		}
+		pos = n2
+		num, _ := strconv.Atoi(string(numTok))
+		offset, _ := strconv.Atoi(string(offTok))
+		if _, exists := doc.objects[num]; exists || first+offset >= len(data) {
+			continue
+		}
+		value, _ := pdfReadValue(data, first+offset)
+		doc.objects[num] = pdfObject{value: value}
+	}
+}
+
+// 参照をたどって値を返す
+func (doc *pdfDocument) resolve(v []byte) []byte {
+	for i := 0; i < 8; i++ {
+		num, ok := pdfRef(v)
+		if !ok {
+			return v
+		}
+		v = doc.objects[num].value
+	}
+	return nil
+}
+
+// 参照をたどって辞書を返す
+func (doc *pdfDocument) dict(v []byte) map[string][]byte {
+	return pdfDict(doc.resolve(v))
+}
+
+// /Filter が指定のフィルター1つだけかどうか（名前でも1要素の配列でもよい）
+func pdfHasOnlyFilter(filter []byte, name string) bool {
+	if items := pdfArray(filter); items != nil {
+		return len(items) == 1 && string(items[0]) == name
+	}
+	return string(filter) == name
+}
+
+// ページツリーをたどってページの辞書を順に返す（/Resources・/MediaBox は親から引き継ぐ）
+func (doc *pdfDocument) pages() []map[string][]byte {
+	catalog := doc.dict(doc.root)
+	if catalog == nil {
+		// トレーラーが読めない場合はカタログのオブジェクトを探す
+		for _, obj := range doc.objects {
+			if dict := pdfDict(obj.value); string(dict["Type"]) == "/Catalog" {
+				catalog = dict
+				break
+			}
+		}
+	}
+
+	var pages []map[string][]byte
+	visited := map[int]bool{}
+	var walk func(node []byte, inherited map[string][]byte, depth int)
+	walk = func(node []byte, inherited map[string][]byte, depth int) {
+		if depth > 64 {
+			return
+		}
+		if num, ok := pdfRef(node); ok {
+			if visited[num] {
+				return
+			}
+			visited[num] = true
+		}
+		dict := doc.dict(node)
+		if dict == nil {
+			return
+		}
+		attrs := map[string][]byte{}
+		for _, key := range []string{"Resources", "MediaBox"} {
+			if v, ok := dict[key]; ok {
+				attrs[key] = v
+			} else if v, ok := inherited[key]; ok {
+				attrs[key] = v
+			}
+		}
+		if kids, ok := dict["Kids"]; ok && string(dict["Type"]) != "/Page" {
+			for _, kid := range pdfArray(doc.resolve(kids)) {
+				walk(kid, attrs, depth+1)
+			}
+			return
+		}
+		for key, v := range attrs {
+			dict[key] = v
+		}
+		pages = append(pages, dict)
+	}
+	walk(catalog["Pages"], nil, 0)
+	return pages
+}
+
+// スキャン画像とみなす、ページの面積（ポイント）に対する画像の画素数の割合の下限
+// 72dpi以上でページ全体を取り込んだ画像はこれを超え、ロゴなどの小さな画像は下回る
+const pdfScanMinCoverage = 0.5
+
+// ページ全体のスキャン画像（JPEG）を返す
+// 画像がない（テキストで書かれたページ）、複数ある、JPEG以外、ページに対して小さい（ロゴなど）場合はfalseを返す
+func (doc *pdfDocument) scannedPageImage(page map[string][]byte) ([]byte, bool) {
+	resources := doc.dict(page["Resources"])
+	var image pdfObject
+	count := 0
+	for _, ref := range doc.dict(resources["XObject"]) {
+		num, ok := pdfRef(ref)
+		if !ok {
+			continue
+		}
+		if obj := doc.objects[num]; string(pdfDict(obj.value)["Subtype"]) == "/Image" {
+			image = obj
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, false
+	}
+
+	dict := pdfDict(image.value)
+	if !pdfHasOnlyFilter(doc.resolve(dict["Filter"]), "/DCTDecode") || !bytes.HasPrefix(image.stream, []byte{0xFF, 0xD8}) {
+		return nil, false
+	}
+	if box := pdfArray(doc.resolve(page["MediaBox"])); len(box) == 4 {
+		pageArea := (pdfNumber(box[2]) - pdfNumber(box[0])) * (pdfNumber(box[3]) - pdfNumber(box[1]))
+		imageArea := pdfNumber(doc.resolve(dict["Width"])) * pdfNumber(doc.resolve(dict["Height"]))
+		if pageArea < 0 {
+			pageArea = -pageArea
+		}
+		if imageArea < pageArea*pdfScanMinCoverage {
+			return nil, false
+		}
+	}
+	return image.stream, true
+}
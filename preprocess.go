@@ -0,0 +1,331 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// レシート向け前処理の設定（各ステップを個別にON/OFFできる）
+type PreprocessOptions struct {
+	Crop      bool // 背景を切り取り、レシート（紙）の領域だけを残す
+	Deskew    bool // 傾きを補正する
+	Grayscale bool // グレースケールに変換する
+	Contrast  bool // コントラストを強調する（明るさの分布を0〜255に引き伸ばす）
+	Threshold bool // 白黒2値化する
+}
+
+// 前処理のいずれかのステップが有効かどうか
+func (o PreprocessOptions) Enabled() bool {
+	return o.Crop || o.Deskew || o.Grayscale || o.Contrast || o.Threshold
+}
+
+// 環境変数から前処理の設定を読み込む（デフォルトは全て無効）
+func LoadPreprocessOptions() PreprocessOptions {
+	return PreprocessOptions{
+		Crop:      os.Getenv("IMAGE_PREPROCESS_CROP") == "true",
+		Deskew:    os.Getenv("IMAGE_PREPROCESS_DESKEW") == "true",
+		Grayscale: os.Getenv("IMAGE_PREPROCESS_GRAYSCALE") == "true",
+		Contrast:  os.Getenv("IMAGE_PREPROCESS_CONTRAST") == "true",
+		Threshold: os.Getenv("IMAGE_PREPROCESS_THRESHOLD") == "true",
+	}
+}
+
+// レシート画像にOCR向けの前処理を適用する
+// 傾き補正 → 切り抜き → グレースケール → コントラスト強調 → 2値化 の順で実行する
+func PreprocessReceipt(img image.Image, opts PreprocessOptions) image.Image {
+	if !opts.Enabled() {
+		return img
+	}
+
+	if opts.Deskew {
+		img = Deskew(img)
+	}
+	if opts.Crop {
+		img = CropToPaper(img)
+	}
+	if opts.Grayscale || opts.Contrast || opts.Threshold {
+		gray := toGray(img)
+		if opts.Contrast {
+			gray = StretchContrast(gray)
+		}
+		if opts.Threshold {
+			gray = Binarize(gray, otsuThreshold(gray))
+		}
+		img = gray
+	}
+
+	return img
+}
+
+// 画像をグレースケール（*image.Gray）に変換する
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	return gray
+}
+
+// 明るさのヒストグラムを作成する
+func grayHistogram(gray *image.Gray) [256]int {
+	var hist [256]int
+	for _, v := range gray.Pix {
+		hist[v]++
+	}
+	return hist
+}
+
+// 大津の方法で2値化のしきい値を求める
+func otsuThreshold(gray *image.Gray) uint8 {
+	hist := grayHistogram(gray)
+	total := len(gray.Pix)
+	if total == 0 {
+		return 128
+	}
+
+	var sum float64
+	for i, c := range hist {
+		sum += float64(i * c)
+	}
+
+	var sumBackground, maxVariance float64
+	var weightBackground int
+	threshold := 0
+	for i, c := range hist {
+		weightBackground += c
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+
+		sumBackground += float64(i * c)
+		meanBackground := sumBackground / float64(weightBackground)
+		meanForeground := (sum - sumBackground) / float64(weightForeground)
+		diff := meanBackground - meanForeground
+		variance := float64(weightBackground) * float64(weightForeground) * diff * diff
+		if variance > maxVariance {
+			maxVariance = variance
+			threshold = i
+		}
+	}
+	return uint8(threshold)
+}
+
+// 明るさの下位1%〜上位1%を0〜255に引き伸ばしてコントラストを強調する
+func StretchContrast(gray *image.Gray) *image.Gray {
+	hist := grayHistogram(gray)
+	total := len(gray.Pix)
+	clip := total / 100
+
+	low, high := 0, 255
+	for count := 0; low < 255; low++ {
+		count += hist[low]
+		if count > clip {
+			break
+		}
+	}
+	for count := 0; high > 0; high-- {
+		count += hist[high]
+		if count > clip {
+			break
+		}
+	}
+	if high <= low {
+		return gray
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := (i - low) * 255 / (high - low)
+		lut[i] = uint8(max(0, min(255, v)))
+	}
+
+	out := image.NewGray(gray.Rect)
+	for i, v := range gray.Pix {
+		out.Pix[i] = lut[v]
+	}
+	return out
+}
+
+// しきい値で白黒に2値化する
+func Binarize(gray *image.Gray, threshold uint8) *image.Gray {
+	out := image.NewGray(gray.Rect)
+	for i, v := range gray.Pix {
+		if v > threshold {
+			out.Pix[i] = 255
+		}
+	}
+	return out
+}
+
+// 解析用に縮小したグレースケール画像を作成する（長辺を最大maxSideピクセルに）
+func analysisImage(img image.Image, maxSide int) (*image.Gray, float64) {
+	bounds := img.Bounds()
+	scale := 1.0
+	if side := max(bounds.Dx(), bounds.Dy()); side > maxSide {
+		scale = float64(maxSide) / float64(side)
+		img = imaging.Resize(img, int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale), imaging.Box)
+	}
+	return toGray(img), scale
+}
+
+// 背景より明るい紙の領域を検出して切り抜く
+// 紙の領域が見つからない・小さすぎる場合は元の画像を返す
+func CropToPaper(img image.Image) image.Image {
+	small, scale := analysisImage(img, 400)
+	threshold := otsuThreshold(small)
+	width, height := small.Rect.Dx(), small.Rect.Dy()
+
+	// 行・列ごとに紙（しきい値より明るい）ピクセルの割合を数える
+	rowRatio := make([]float64, height)
+	colRatio := make([]float64, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if small.GrayAt(x, y).Y > threshold {
+				rowRatio[y]++
+				colRatio[x]++
+			}
+		}
+	}
+
+	const minRatio = 0.25
+	top, bottom := firstAbove(rowRatio, float64(width)*minRatio), lastAbove(rowRatio, float64(width)*minRatio)
+	left, right := firstAbove(colRatio, float64(height)*minRatio), lastAbove(colRatio, float64(height)*minRatio)
+	if top < 0 || left < 0 {
+		return img
+	}
+
+	// 面積が元画像の20%未満なら誤検出とみなす
+	if (bottom-top+1)*(right-left+1) < width*height/5 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	rect := image.Rect(
+		bounds.Min.X+int(float64(left)/scale),
+		bounds.Min.Y+int(float64(top)/scale),
+		bounds.Min.X+int(math.Ceil(float64(right+1)/scale)),
+		bounds.Min.Y+int(math.Ceil(float64(bottom+1)/scale)),
+	).Intersect(bounds)
+	if rect.Eq(bounds) {
+		return img
+	}
+
+	log.Printf("✂️  レシート領域を切り抜き: %dx%d -> %dx%d", bounds.Dx(), bounds.Dy(), rect.Dx(), rect.Dy())
+	return imaging.Crop(img, rect)
+}
+
+func firstAbove(values []float64, limit float64) int {
+	for i, v := range values {
+		if v >= limit {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastAbove(values []float64, limit float64) int {
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i] >= limit {
+			return i
+		}
+	}
+	return -1
+}
+
+// 文字の行の傾きを検出する（度、反時計回りが正）
+// 横方向のエッジ（文字の行や紙の上下端）を集め、-10〜10度の範囲で
+// 行方向の投影ヒストグラムが最も鋭くなる角度を探す
+func DetectSkewAngle(img image.Image) float64 {
+	small, _ := analysisImage(img, 400)
+	width, height := small.Rect.Dx(), small.Rect.Dy()
+
+	// 縦方向の明るさの変化が大きいピクセル（横向きのエッジ）の座標を集める
+	const edgeThreshold = 40
+	var xs, ys []float64
+	for y := 1; y < height-1; y++ {
+		for x := 0; x < width; x++ {
+			diff := int(small.GrayAt(x, y+1).Y) - int(small.GrayAt(x, y-1).Y)
+			if diff > edgeThreshold || diff < -edgeThreshold {
+				xs = append(xs, float64(x))
+				ys = append(ys, float64(y))
+			}
+		}
+	}
+	if len(xs) == 0 {
+		return 0
+	}
+
+	diagonal := int(math.Hypot(float64(width), float64(height))) + 1
+	bestAngle, bestScore := 0.0, -1.0
+	for step := -40; step <= 40; step++ {
+		angle := float64(step) * 0.25
+		rad := angle * math.Pi / 180
+		sin, cos := math.Sin(rad), math.Cos(rad)
+
+		bins := make([]float64, 2*diagonal)
+		for i := range xs {
+			row := int(ys[i]*cos+xs[i]*sin) + diagonal
+			bins[row]++
+		}
+
+		score := 0.0
+		for _, c := range bins {
+			score += c * c
+		}
+		if score > bestScore {
+			bestScore = score
+			bestAngle = angle
+		}
+	}
+
+	return bestAngle
+}
+
+// 検出した傾きを打ち消すように回転する（余白は画像の外周の平均色で埋める）
+func Deskew(img image.Image) image.Image {
+	angle := DetectSkewAngle(img)
+	if math.Abs(angle) < 0.5 {
+		return img
+	}
+
+	log.Printf("📐 傾き補正: %.2f度", angle)
+	return imaging.Rotate(img, -angle, borderColor(img))
+}
+
+// 画像の外周ピクセルの平均色（背景色の推定に使う）
+func borderColor(img image.Image) color.Color {
+	bounds := img.Bounds()
+	var r, g, b, n uint64
+	add := func(x, y int) {
+		cr, cg, cb, _ := img.At(x, y).RGBA()
+		r, g, b, n = r+uint64(cr>>8), g+uint64(cg>>8), b+uint64(cb>>8), n+1
+	}
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		add(x, bounds.Min.Y)
+		add(x, bounds.Max.Y-1)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		add(bounds.Min.X, y)
+		add(bounds.Max.X-1, y)
+	}
+	if n == 0 {
+		return color.White
+	}
+	return color.NRGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 家計簿の基準通貨を取得する（BASE_CURRENCY、デフォルト: JPY）
+func GetBaseCurrency() string {
+	if currency, ok := NormalizeCurrency(os.Getenv("BASE_CURRENCY")); ok {
+		return currency
+	}
+	return "JPY"
+}
+
+// 為替レート表のファイルパスを取得する（デフォルト: data/rates.json）
+func GetRateTablePath() string {
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join("data", "rates.json")
+}
+
+// 1通貨あたりの基準通貨での金額
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	UpdatedBy string    `json:"updated_by,omitempty"` // /rate set を実行したユーザー名
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 為替レート表（pathが空の場合はメモリ上のみ）
+type RateTable struct {
+	mu    sync.Mutex
+	path  string
+	rates map[string]ExchangeRate
+}
+
+// 為替レート表（起動時にLoadRateTableで差し替える）
+var exchangeRates = NewRateTable("")
+
+func NewRateTable(path string) *RateTable {
+	return &RateTable{path: path, rates: map[string]ExchangeRate{}}
+}
+
+// ファイルから為替レート表を読み込む（ファイルがない場合は空のレート表を返す）
+// ファイルは {"USD": 150.5, "EUR": 162} のような通貨コードとレートの対応でも書ける
+func LoadRateTable(path string) (*RateTable, error) {
+	table := NewRateTable(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return table, nil
+	}
+	if err != nil {
+		return table, fmt.Errorf("為替レート表の読み込みエラー: %v", err)
+	}
+
+	var rates []ExchangeRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		// 手で書きやすい {"USD": 150.5} の形式
+		var simple map[string]float64
+		if err := json.Unmarshal(data, &simple); err != nil {
+			return table, fmt.Errorf("為替レート表の解析エラー: %v", err)
+		}
+		for currency, rate := range simple {
+			rates = append(rates, ExchangeRate{Currency: currency, Rate: rate})
+		}
+	}
+
+	for _, rate := range rates {
+		currency, ok := NormalizeCurrency(rate.Currency)
+		if !ok || rate.Rate <= 0 {
+			log.Printf("⚠️  為替レートを読み飛ばしました: %+v", rate)
+			continue
+		}
+		rate.Currency = currency
+		table.rates[currency] = rate
+	}
+
+	log.Printf("💱 為替レート表を読み込みました: %d件 (%s)", len(table.rates), path)
+	return table, nil
+}
+
+// レートを設定して保存する
+// 保存に失敗した場合は設定を元に戻してエラーを返す
+func (rt *RateTable) Set(currency string, rate float64, updatedBy string) (ExchangeRate, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	previous, existed := rt.rates[currency]
+	entry := ExchangeRate{Currency: currency, Rate: rate, UpdatedBy: updatedBy, UpdatedAt: time.Now()}
+	rt.rates[currency] = entry
+	if err := rt.save(); err != nil {
+		if existed {
+			rt.rates[currency] = previous
+		} else {
+			delete(rt.rates, currency)
+		}
+		return ExchangeRate{}, err
+	}
+	return entry, nil
+}
+
+// レートを取得する
+func (rt *RateTable) Get(currency string) (ExchangeRate, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rate, ok := rt.rates[currency]
+	return rate, ok
+}
+
+// 全てのレートを通貨コード順に返す
+func (rt *RateTable) List() []ExchangeRate {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rates := make([]ExchangeRate, 0, len(rt.rates))
+	for _, rate := range rt.rates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(a, b int) bool {
+		return rates[a].Currency < rates[b].Currency
+	})
+	return rates
+}
+
+// 金額を基準通貨に換算する（基準通貨の最小単位で四捨五入）
+// レートが設定されていない場合はfalseを返す
+func (rt *RateTable) Convert(amount Money, base string) (converted Money, rate float64, ok bool) {
+	if amount.Currency == base {
+		return amount, 1, true
+	}
+	entry, ok := rt.Get(amount.Currency)
+	if !ok {
+		return Money{}, 0, false
+	}
+	return amount.Convert(entry.Rate, base), entry.Rate, true
+}
+
+// 為替レート表をファイルに保存する（呼び出し側でロックを取ること）
+func (rt *RateTable) save() error {
+	if rt.path == "" {
+		return nil
+	}
+
+	rates := make([]ExchangeRate, 0, len(rt.rates))
+	for _, rate := range rt.rates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(a, b int) bool {
+		return rates[a].Currency < rates[b].Currency
+	})
+	return writeJSONFileAtomic(rt.path, rates)
+}
+
+// レートの表示（例: "150.25 JPY"）
+func formatRate(rate float64, base string) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64) + " " + base
+}
+
+// /rate set を使える権限（全員の外貨のレシートの換算に使うレートを変えるため、サーバーの管理権限を持つメンバーに限る）
+var rateSetPermissions int64 = discordgo.PermissionManageGuild
+
+// 為替レートを設定するコマンドの定義
+// Discordの権限はコマンド単位のため、誰でも使える表示は /rates に分けている
+var rateCommand = &discordgo.ApplicationCommand{
+	Name:                     "rate",
+	Description:              "外貨のレシートを換算する為替レートを設定します",
+	DescriptionLocalizations: englishLocalizations("Set exchange rates for foreign-currency receipts"),
+	DefaultMemberPermissions: &rateSetPermissions,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     "set",
+			Description:              "1通貨あたりの基準通貨での金額を設定します",
+			DescriptionLocalizations: *englishLocalizations("Set how much one unit of a currency is worth in the base currency"),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "currency",
+					Description:              "通貨コード（例: USD, EUR, KRW）",
+					DescriptionLocalizations: *englishLocalizations("Currency code (e.g. USD, EUR, KRW)"),
+					Required:                 true,
+				},
+				{
+					Type:                     discordgo.ApplicationCommandOptionNumber,
+					Name:                     "rate",
+					Description:              "1通貨あたりの基準通貨での金額（例: 150.5）",
+					DescriptionLocalizations: *englishLocalizations("Value of one unit in the base currency (e.g. 150.5)"),
+					Required:                 true,
+				},
+			},
+		},
+	},
+}
+
+// 為替レートを表示するコマンドの定義
+var ratesCommand = &discordgo.ApplicationCommand{
+	Name:                     "rates",
+	Description:              "設定されている為替レートを表示します",
+	DescriptionLocalizations: englishLocalizations("Show the configured exchange rates"),
+}
+
+func init() {
+	router.HandleCommand(rateCommand, handleRateCommand, RouteOptions{})
+	router.HandleCommand(ratesCommand, handleRatesCommand, RouteOptions{})
+}
+
+// /rate が実行された時の処理
+func handleRateCommand(c *InteractionContext) {
+	options := c.ApplicationCommandData().Options
+	if len(options) > 0 && options[0].Name == "set" {
+		handleRateSetCommand(c, options[0].Options)
+	}
+}
+
+// /rate set: レートを設定する（全員が同じレートを使うため、結果はチャンネルに表示する）
+func handleRateSetCommand(c *InteractionContext, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	lang := c.Lang()
+	base := GetBaseCurrency()
+
+	var currencyText string
+	var rate float64
+	for _, option := range options {
+		switch option.Name {
+		case "currency":
+			currencyText = option.StringValue()
+		case "rate":
+			rate = option.FloatValue()
+		}
+	}
+
+	currency, ok := NormalizeCurrency(currencyText)
+	if !ok {
+		c.ReplyEphemeral(T(lang, "rate.invalid_currency", currencyText))
+		return
+	}
+	if currency == base {
+		c.ReplyEphemeral(T(lang, "rate.base_currency", currency))
+		return
+	}
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		c.ReplyEphemeral(T(lang, "rate.invalid_rate"))
+		return
+	}
+
+	if _, err := exchangeRates.Set(currency, rate, c.User().Username); err != nil {
+		log.Printf("❌ 為替レート表の保存失敗: %v", err)
+		c.ReplyEphemeral(T(lang, "rate.save_failed", err))
+		return
+	}
+	log.Printf("💱 為替レート設定 - UserID: %s, 1 %s = %s", c.User().ID, currency, formatRate(rate, base))
+	c.Reply(T(lang, "rate.set", currency, formatRate(rate, base)))
+}
+
+// /rates: 設定されているレートを表示する
+func handleRatesCommand(c *InteractionContext) {
+	lang := c.Lang()
+	base := GetBaseCurrency()
+
+	rates := exchangeRates.List()
+	if len(rates) == 0 {
+		c.ReplyEphemeral(T(lang, "rate.list_empty", base))
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(T(lang, "rate.list_title", base) + "\n```\n")
+	for _, rate := range rates {
+		message.WriteString(fmt.Sprintf("1 %s = %s", rate.Currency, formatRate(rate.Rate, base)))
+		if !rate.UpdatedAt.IsZero() {
+			message.WriteString(fmt.Sprintf("  (%s %s)", rate.UpdatedAt.Format("2006/01/02"), rate.UpdatedBy))
+		}
+		message.WriteString("\n")
+	}
+	message.WriteString("```")
+	c.ReplyEphemeral(message.String())
+}
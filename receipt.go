@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// レシートとして処理する画像・PDFの取得元（添付ファイル・埋め込み画像・画像URL）
+type ReceiptSource struct {
+	URL          string `json:"url"`                     // ダウンロード元URL
+	ProxyURL     string `json:"proxy_url,omitempty"`     // Discordのメディアプロキシ経由のURL（HEIC変換に使用）
+	Filename     string `json:"filename"`                // 表示・アップロード用のファイル名
+	ContentType  string `json:"content_type,omitempty"`  // Discordが提供するcontent_type（分かる場合のみ）
+	AttachmentID string `json:"attachment_id,omitempty"` // 添付ファイルのID（再処理時にURLを取り直すのに使用）
+}
+
+// URLとして扱う文字列（メッセージ本文から画像URLを抽出する）
+// 日本語の文章に空白なしで続く場合があるため、URLに使えるASCII文字のみを対象にする
+var messageURLPattern = regexp.MustCompile(`https?://[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`)
+
+// 画像URLとして受け付けるホストのデフォルト値
+var defaultAllowedImageHosts = []string{
+	"cdn.discordapp.com",
+	"media.discordapp.net",
+	"i.imgur.com",
+}
+
+// 環境変数から画像URLの取得を許可するホストを取得する（カンマ区切り）
+func GetAllowedImageHosts() []string {
+	hosts := os.Getenv("IMAGE_URL_ALLOWED_HOSTS")
+	if hosts == "" {
+		return defaultAllowedImageHosts
+	}
+
+	var allowed []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			allowed = append(allowed, host)
+		}
+	}
+	return allowed
+}
+
+// URLのホストが許可リストに含まれているか判定する（httpsのみ、サブドメインも許可）
+func IsAllowedImageURL(rawURL string, allowedHosts []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// URLのパスからファイル名を取り出す（取り出せない場合は "image"）
+func filenameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "image"
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "/" || name == "." {
+		return "image"
+	}
+	return name
+}
+
+// メッセージ本文からレシート形式（画像・PDF）の拡張子を持つURLを抽出する
+func ExtractImageURLs(content string) []string {
+	var urls []string
+	for _, match := range messageURLPattern.FindAllString(content, -1) {
+		// Markdownの括弧や句読点が末尾に付いている場合は取り除く
+		match = strings.TrimRight(match, ").,!?")
+		if IsSupportedReceiptType(GetMimeType(filenameFromURL(match))) {
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
+
+// メッセージから処理対象の画像を集める
+// 添付ファイル・埋め込み画像・本文中の画像URL・転送メッセージの内容を対象とし、
+// 添付ファイル以外は許可されたホストのURLのみを受け付ける
+func ReceiptSourcesFromMessage(m *discordgo.Message) []ReceiptSource {
+	allowedHosts := GetAllowedImageHosts()
+	seen := make(map[string]bool)
+	var sources []ReceiptSource
+
+	add := func(source ReceiptSource) {
+		if source.URL == "" || seen[source.URL] {
+			return
+		}
+		seen[source.URL] = true
+		sources = append(sources, source)
+	}
+
+	// 埋め込み・本文のURLは許可リストで確認し、可能ならDiscordのプロキシ経由で取得する
+	addURL := func(rawURL, proxyURL string) {
+		if !IsAllowedImageURL(rawURL, allowedHosts) {
+			if rawURL != "" {
+				log.Printf("🚫 許可されていないホストの画像URLをスキップ: %s", rawURL)
+			}
+			return
+		}
+		if seen[rawURL] {
+			return
+		}
+		seen[rawURL] = true
+
+		downloadURL := rawURL
+		if proxyURL != "" {
+			downloadURL = proxyURL
+			seen[proxyURL] = true
+		}
+		sources = append(sources, ReceiptSource{
+			URL:      downloadURL,
+			ProxyURL: proxyURL,
+			Filename: filenameFromURL(rawURL),
+		})
+	}
+
+	messages := []*discordgo.Message{m}
+	for _, snapshot := range m.MessageSnapshots {
+		if snapshot.Message != nil {
+			messages = append(messages, snapshot.Message)
+		}
+	}
+
+	for _, msg := range messages {
+		for _, attachment := range msg.Attachments {
+			add(ReceiptSource{
+				URL:          attachment.URL,
+				ProxyURL:     attachment.ProxyURL,
+				Filename:     attachment.Filename,
+				ContentType:  attachment.ContentType,
+				AttachmentID: attachment.ID,
+			})
+		}
+
+		for _, embed := range msg.Embeds {
+			if embed.Image != nil {
+				addURL(embed.Image.URL, embed.Image.ProxyURL)
+			}
+			// 画像リンクの埋め込み（type: image）は画像がサムネイルに入る
+			if embed.Type == discordgo.EmbedTypeImage && embed.Thumbnail != nil {
+				addURL(embed.Thumbnail.URL, embed.Thumbnail.ProxyURL)
+			}
+		}
+
+		for _, imageURL := range ExtractImageURLs(msg.Content) {
+			addURL(imageURL, "")
+		}
+	}
+
+	return sources
+}
+
+// レシート処理用のスレッドを使うかどうか（RECEIPT_THREADS=false で無効）
+func isReceiptThreadEnabled() bool {
+	return os.Getenv("RECEIPT_THREADS") != "false"
+}
+
+// 元のメッセージからレシート処理用のスレッドを作成し、そのチャンネルIDを返す
+// スレッドを作成できない場合（無効化・DM・スレッド内のメッセージなど）は元のチャンネルIDを返す
+func startReceiptThread(s *discordgo.Session, lang Lang, origin *discordgo.Message, author *discordgo.User) string {
+	if !isReceiptThreadEnabled() || origin.ID == "" {
+		return origin.ChannelID
+	}
+
+	// 既にスレッドがあるメッセージはそのスレッドを使う
+	if origin.Thread != nil {
+		return origin.Thread.ID
+	}
+
+	name := T(lang, "receipt.thread_name", author.Username, time.Now().Format("01/02 15:04"))
+	thread, err := s.MessageThreadStart(origin.ChannelID, origin.ID, name, 1440)
+	if err != nil {
+		log.Printf("⚠️  スレッド作成失敗（チャンネルに直接返信します）: %v", err)
+		return origin.ChannelID
+	}
+
+	log.Printf("🧵 レシート処理用スレッドを作成: %s", thread.ID)
+	return thread.ID
+}
+
+// 集めた画像を1つずつダウンロード → 圧縮 → Difyに送信する
+// 進捗は1つのステータスメッセージを編集して表示し、レシートごとの結果は埋め込みで送信する
+// 元のチャンネルには最終結果のサマリーだけを残す
+func processReceipts(s *discordgo.Session, lang Lang, origin *discordgo.Message, author *discordgo.User, sources []ReceiptSource) {
+	// 失敗時に再処理できるよう、画像ごとに処理履歴を作成する
+	jobs := make([]ReceiptJob, len(sources))
+	for i, source := range sources {
+		jobs[i] = *receiptJobs.Create(origin.ChannelID, origin.ID, author.ID, author.Username, source)
+	}
+	processReceiptJobs(s, lang, origin, author, jobs)
+}
+
+// 処理履歴のジョブを順に処理する（初回の処理と再処理で共通）
+func processReceiptJobs(s *discordgo.Session, lang Lang, origin *discordgo.Message, author *discordgo.User, jobs []ReceiptJob) {
+	sources := make([]ReceiptSource, len(jobs))
+	for i, job := range jobs {
+		sources[i] = job.Source
+	}
+
+	log.Printf("📷 画像アップロード処理開始 - User: %s, 画像数: %d", author.Username, len(sources))
+
+	// 元のメッセージに処理中のリアクションを付ける
+	setReceiptReaction(s, origin, reactionProcessing)
+
+	channelID := startReceiptThread(s, lang, origin, author)
+
+	// 処理状況はステータスメッセージ1つにまとめる
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		// ファイル名はログ・アップロードに使うため安全な形に整える
+		names[i] = SanitizeFilename(source.Filename)
+	}
+	status := NewBatchStatus(s, lang, channelID, names)
+
+	payer := payerLabel(author.ID, author.Username)
+
+	// 全ての添付ファイルを処理
+	successCount := 0
+	failureCount := 0
+	warningCount := 0 // failureCountのうちDify内部エラーの件数
+
+	for i, source := range sources {
+		log.Printf("📎 [%d/%d] 処理中: %s", i+1, len(sources), source.Filename)
+
+		var outcome receiptOutcome
+		ledgerOnly := jobs[i].LedgerOnly()
+		if ledgerOnly {
+			// 読み取り済みのレシートはDifyを実行し直さず、家計簿への記録だけやり直す
+			// 失敗した後に /rate set でレートが設定されている場合があるため、換算はやり直す
+			result := *jobs[i].Result
+			result.ApplyRate(exchangeRates, GetBaseCurrency())
+			outcome = receiptOutcome{Stage: StageDone, Result: &result}
+		} else {
+			outcome = processReceipt(status, lang, i, len(sources), source, names[i], author)
+		}
+		receiptJobs.Update(jobs[i].ID, outcome.JobStatus(), outcome.Message, outcome.Result)
+		if outcome.Result != nil && outcome.Result.Refund {
+			// 返品・返金は元の記録を探して紐付ける
+			if linked, ok := receiptJobs.LinkRefund(jobs[i].ID); ok {
+				outcome.Result = linked.Result
+			}
+		}
+		// LEDGER_WRITER=bot の場合だけBotが家計簿に記録する（デフォルトはDifyのワークフローが記録済み）
+		// 記録だけの再処理は、設定が変わっていてもワークフローでは記録されていないためBotが記録する
+		outcome.DifyLedger = GetLedgerWriter() != LedgerWriterBot && !ledgerOnly
+		if job, ok := receiptJobs.Get(jobs[i].ID); ok && !outcome.DifyLedger && outcome.Stage == StageDone && job.Result != nil && job.Result.Parsed {
+			if err := recordReceiptInLedger(job); err != nil {
+				// 読み取った内容は残し、再処理では家計簿への記録だけやり直せるようにする
+				log.Printf("❌ [%d/%d] 家計簿への記録失敗 (%s): %v", i+1, len(sources), names[i], err)
+				outcome = receiptOutcome{Stage: StageFailed, Message: T(lang, "receipt.ledger_failed", err), Result: outcome.Result, Thumbnail: outcome.Thumbnail}
+				receiptJobs.Update(jobs[i].ID, JobStatusLedgerFailed, outcome.Message, job.Result)
+			}
+		}
+		status.Set(i, outcome.Stage, outcome.StatusDetail(lang))
+		sendReceiptResult(s, lang, channelID, i, names[i], payer, jobs[i].ID, outcome)
+
+		if outcome.Stage == StageDone {
+			successCount++
+			log.Printf("✅ [%d/%d] 画像処理が完了しました: %s", i+1, len(sources), names[i])
+		} else {
+			failureCount++
+			if outcome.Stage == StageWarning {
+				warningCount++
+			}
+		}
+
+		// 複数画像処理時は適度に間隔を空ける（最後の画像以外）
+		if i < len(sources)-1 {
+			time.Sleep(2 * time.Second)
+			log.Printf("⏱️ 次の画像処理まで2秒待機...")
+		}
+	}
+
+	// ステータスメッセージに成功・失敗の件数を反映する
+	status.Finish(successCount, failureCount)
+
+	// 元のメッセージのリアクションを最終結果に付け替える
+	setReceiptReaction(s, origin, batchReaction(failureCount-warningCount, warningCount))
+
+	// スレッドで処理した場合は、元のチャンネルにスレッドへのリンク付きでサマリーを残す
+	if channelID != origin.ChannelID {
+		summary := receiptSummary(lang, successCount, failureCount) + "\n" + T(lang, "summary.thread_link", channelID)
+		s.ChannelMessageSendReply(origin.ChannelID, summary, origin.Reference())
+	}
+
+	log.Printf("📊 画像処理サマリー - 成功: %d, 失敗: %d, 合計: %d", successCount, failureCount, len(sources))
+	// ---------------------------------
+}
+
+// 1枚の画像・PDFをダウンロード → 圧縮 → Difyに送信し、結果を返す
+// 途中の段階はステータスメッセージに反映する
+func processReceipt(status *BatchStatus, lang Lang, i, total int, source ReceiptSource, fileName string, author *discordgo.User) receiptOutcome {
+	failed := func(key string, args ...interface{}) receiptOutcome {
+		return receiptOutcome{Stage: StageFailed, Message: T(lang, key, args...)}
+	}
+
+	// 画像をメモリ上にダウンロード（一時ファイルは使わない）
+	status.Set(i, StageDownloading, "")
+	data, err := DownloadImage(source.URL, source.ContentType)
+	if err != nil {
+		log.Printf("❌ [%d/%d] 画像ダウンロード失敗 (%s): %v", i+1, total, fileName, err)
+		return failed("receipt.download_failed", err)
+	}
+
+	// --- ファイルの中身から形式を判定し、レシート以外は早めに弾く ---
+	mimeType := ResolveMimeType(data, fileName, source.ContentType)
+	if !IsSupportedReceiptType(mimeType) {
+		log.Printf("🚫 [%d/%d] 未対応の形式 (%s): %s", i+1, total, fileName, mimeType)
+		return failed("receipt.unsupported_type", mimeType)
+	}
+
+	// --- HEIC/HEIF（iPhoneの写真）はJPEGに変換 ---
+	if mimeType == "image/heic" || mimeType == "image/heif" {
+		status.Set(i, StageCompressing, "")
+		data, err = ConvertHEICToJPEG(source.ProxyURL)
+		if err != nil {
+			log.Printf("❌ [%d/%d] HEIC変換失敗 (%s): %v", i+1, total, fileName, err)
+			return failed("receipt.convert_failed", err)
+		}
+		mimeType = "image/jpeg"
+	}
+
+	var fileIDs []string
+	var thumbnail []byte
+	fileType := "image"
+
+	if mimeType == "application/pdf" {
+		// --- PDFは画像圧縮を通さずにDifyへ送信（scannedモードではスキャンPDFをページ画像にして送信） ---
+		status.Set(i, StageUploading, "")
+		var err error
+		fileIDs, fileType, err = UploadPDFToDify(data, PDFPageImages(data), fileName)
+		if err != nil {
+			log.Printf("❌ [%d/%d] PDFアップロード失敗 (%s): %v", i+1, total, fileName, err)
+			return failed("receipt.upload_failed", err)
+		}
+	} else {
+		// --- 画像を圧縮 ---
+		status.Set(i, StageCompressing, "")
+		compressed, err := CompressImage(data)
+		if err != nil {
+			log.Printf("❌ [%d/%d] 画像圧縮失敗 (%s): %v", i+1, total, fileName, err)
+			return failed("receipt.compress_failed", err)
+		}
+
+		// 結果表示用のサムネイル（作れなくても処理は続ける）
+		thumbnail, err = MakeThumbnail(compressed)
+		if err != nil {
+			log.Printf("⚠️  [%d/%d] サムネイル作成失敗 (%s): %v", i+1, total, fileName, err)
+		}
+
+		// --- Dify APIに送信 ---
+		// 1. 画像をDifyにアップロード
+		status.Set(i, StageUploading, "")
+		fileID, err := UploadImageToDify(compressed, fileName)
+		if err != nil {
+			log.Printf("❌ [%d/%d] Difyアップロード失敗 (%s): %v", i+1, total, fileName, err)
+			return failed("receipt.upload_failed", err)
+		}
+		fileIDs = []string{fileID}
+	}
+
+	// 2. ワークフローを実行（画像・PDFを使用）
+	status.Set(i, StageWorkflow, "")
+	result, err := RunDifyWorkflowWithFiles(fileIDs, fileType, author.ID, author.Username)
+	if err != nil {
+		log.Printf("❌ [%d/%d] Difyワークフロー実行失敗 (%s): %v", i+1, total, fileName, err)
+		return failed("receipt.workflow_failed", err)
+	}
+
+	parsed := ParseReceiptResult(result)
+	if parsed.Parsed {
+		// 外貨のレシートは基準通貨に換算する
+		parsed.ApplyRate(exchangeRates, GetBaseCurrency())
+	}
+	if parsed.Error != "" {
+		return receiptOutcome{Stage: StageWarning, Message: parsed.Error, Result: &parsed, Thumbnail: thumbnail}
+	}
+	return receiptOutcome{Stage: StageDone, Result: &parsed, Thumbnail: thumbnail}
+}
+
+// 記録できたレシートを家計簿（GAS）に1行追加する（LEDGER_WRITER=bot の場合）
+// 金額は基準通貨に換算したもので、外貨は元の金額も一緒に記録する
+func recordReceiptInLedger(job ReceiptJob) error {
+	entry, err := receiptLedgerEntry(job)
+	if err != nil {
+		return err
+	}
+	if err := AppendLedgerEntry(entry); err != nil {
+		return err
+	}
+	log.Printf("📒 家計簿に記録しました: %s %s %v (JobID: %s)", entry.Date, entry.Store, entry.Amount, job.ID)
+	return nil
+}
+
+// レシートの処理履歴から家計簿に記録する1行を作る
+// カテゴリが読み取れなかった返品・返金は、カテゴリ別の集計で差し引けるよう元の記録のカテゴリにする
+func receiptLedgerEntry(job ReceiptJob) (LedgerEntry, error) {
+	entry, err := job.LedgerEntry(GetBaseCurrency(), GetTimezone())
+	if err != nil {
+		return entry, err
+	}
+	if entry.Category == "" && entry.RefundOf != "" {
+		if original, ok := receiptJobs.Get(entry.RefundOf); ok && original.Result != nil {
+			entry.Category = original.Result.Category
+		}
+	}
+	return entry, nil
+}
+
+// 結果に表示する支払った人（例: "hoshi（Y）"）
+func payerLabel(userID, username string) string {
+	return fmt.Sprintf("%s（%s）", username, getPayerFromDiscordUser(userID, username))
+}
+
+// レシート1枚の結果を埋め込みで送信する（サムネイルがあれば添付して表示する）
+// 失敗した場合は再処理ボタン、記録できた場合は返品・返金として記録し直すボタンを付ける
+func sendReceiptResult(s *discordgo.Session, lang Lang, channelID string, index int, fileName, payer, jobID string, outcome receiptOutcome) {
+	message := &discordgo.MessageSend{}
+	if outcome.Stage != StageDone {
+		message.Components = retryReceiptComponents(lang, jobID)
+	} else if outcome.Result != nil && outcome.Result.Parsed && !outcome.Result.Refund {
+		message.Components = refundReceiptComponents(lang, jobID)
+	}
+
+	thumbnailName := ""
+	if len(outcome.Thumbnail) > 0 {
+		thumbnailName = fmt.Sprintf("thumbnail_%d.jpg", index+1)
+		message.Files = []*discordgo.File{{
+			Name:        thumbnailName,
+			ContentType: "image/jpeg",
+			Reader:      bytes.NewReader(outcome.Thumbnail),
+		}}
+	}
+	message.Embeds = []*discordgo.MessageEmbed{ReceiptResultEmbed(lang, fileName, payer, outcome, thumbnailName)}
+
+	if _, err := s.ChannelMessageSendComplex(channelID, message); err != nil {
+		log.Printf("❌ 結果メッセージ送信失敗 (%s): %v", fileName, err)
+	}
+}
+
+// 全体の処理結果のサマリー文
+func receiptSummary(lang Lang, successCount, failureCount int) string {
+	if failureCount == 0 {
+		return T(lang, "summary.all_success", successCount)
+	} else if successCount > 0 {
+		return T(lang, "summary.partial", successCount, failureCount)
+	}
+	return T(lang, "summary.all_failed", successCount, failureCount)
+}
+
+// 元のメッセージに付ける処理状況のリアクション
+const (
+	reactionProcessing = "⏳"
+	reactionSuccess    = "✅"
+	reactionWarning    = "⚠️"
+	reactionFailure    = "❌"
+)
+
+var receiptReactions = []string{reactionProcessing, reactionSuccess, reactionWarning, reactionFailure}
+
+// リアクションで処理状況を表示するかどうか（RECEIPT_REACTIONS=false で無効）
+func isReceiptReactionEnabled() bool {
+	return os.Getenv("RECEIPT_REACTIONS") != "false"
+}
+
+// バッチ全体の結果を表すリアクション
+// 1つでも失敗があれば❌、Dify内部エラーだけなら⚠️、全て成功なら✅
+func batchReaction(failureCount, warningCount int) string {
+	switch {
+	case failureCount > 0:
+		return reactionFailure
+	case warningCount > 0:
+		return reactionWarning
+	default:
+		return reactionSuccess
+	}
+}
+
+// 元のメッセージのBotのリアクションを指定したものだけにする
+// 再処理した時に前回の結果のリアクションが残らないよう、他の状況のリアクションは外す
+func setReceiptReaction(s *discordgo.Session, origin *discordgo.Message, emoji string) {
+	if !isReceiptReactionEnabled() || origin.ID == "" {
+		return
+	}
+
+	if err := s.MessageReactionAdd(origin.ChannelID, origin.ID, emoji); err != nil {
+		log.Printf("⚠️  リアクション追加失敗 (%s): %v", emoji, err)
+	}
+
+	for _, reaction := range receiptReactions {
+		if reaction == emoji {
+			continue
+		}
+		// ⏳は今回の処理で付けたもの、それ以外は前回の処理で付けたものがあれば外す
+		if reaction != reactionProcessing && !hasOwnReaction(origin, reaction) {
+			continue
+		}
+		if err := s.MessageReactionRemove(origin.ChannelID, origin.ID, reaction, "@me"); err != nil {
+			log.Printf("⚠️  リアクション削除失敗 (%s): %v", reaction, err)
+		}
+	}
+}
+
+// メッセージにBot自身が付けたリアクションがあるかどうか
+func hasOwnReaction(m *discordgo.Message, emoji string) bool {
+	for _, reaction := range m.Reactions {
+		if reaction.Me && reaction.Emoji != nil && reaction.Emoji.Name == emoji {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// メッセージの右クリックメニュー「Record this receipt」のコマンド名
+const recordReceiptCommandName = "Record this receipt"
+
+// Payer選択ボタンのCustomIDの接頭辞（record_receipt:<channelID>:<messageID>:<invoker|author>）
+const recordReceiptCustomIDPrefix = "record_receipt:"
+
+// 支払った人の選択肢
+const (
+	recordPayerInvoker = "invoker" // コマンドを実行したユーザー
+	recordPayerAuthor  = "author"  // メッセージの投稿者
+)
+
+// メッセージのコンテキストメニューコマンドの定義
+var recordReceiptCommand = &discordgo.ApplicationCommand{
+	Name: recordReceiptCommandName,
+	Type: discordgo.MessageApplicationCommand,
+	NameLocalizations: &map[discordgo.Locale]string{
+		discordgo.Japanese: "このレシートを記録",
+	},
+}
+
+// インタラクションを実行したユーザーを取得する（サーバー内ならMember、DMならUser）
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// 選ばれた支払った人でレシートの処理を始める（テストでは差し替える）
+var startRecordReceipts = func(s *discordgo.Session, lang Lang, target *discordgo.Message, payer *discordgo.User, sources []ReceiptSource) {
+	go processReceipts(s, lang, target, payer, sources)
+}
+
+func init() {
+	router.HandleCommand(recordReceiptCommand, handleRecordReceiptCommand, RouteOptions{})
+	router.HandleComponent(recordReceiptCustomIDPrefix, handleRecordReceiptButton, RouteOptions{})
+}
+
+// 右クリックメニュー「Record this receipt」が実行された時の処理
+// 投稿者と実行者が異なる場合は、どちらが支払ったかをボタンで選んでもらう
+func handleRecordReceiptCommand(c *InteractionContext) {
+	data := c.ApplicationCommandData()
+	invoker := c.User()
+	lang := c.Lang()
+
+	var target *discordgo.Message
+	if data.Resolved != nil {
+		target = data.Resolved.Messages[data.TargetID]
+	}
+	if target == nil {
+		c.ReplyEphemeral(T(lang, "record.target_not_found"))
+		return
+	}
+
+	if target.ChannelID == "" {
+		target.ChannelID = c.ChannelID
+	}
+
+	sources := ReceiptSourcesFromMessage(target)
+	if len(sources) == 0 {
+		c.ReplyEphemeral(T(lang, "record.no_receipts"))
+		return
+	}
+
+	log.Printf("🖱️ Record this receipt実行 - UserID: %s, MessageID: %s, 画像数: %d", invoker.ID, target.ID, len(sources))
+
+	// 自分の投稿なら選択は不要なのでそのまま処理する
+	if target.Author == nil || target.Author.ID == invoker.ID {
+		c.ReplyEphemeral(T(lang, "record.recording", len(sources)))
+		startRecordReceipts(c.Session, lang, target, invoker, sources)
+		return
+	}
+
+	customID := func(payer string) string {
+		return recordReceiptCustomIDPrefix + target.ChannelID + ":" + target.ID + ":" + payer
+	}
+
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: T(lang, "record.ask_payer", len(sources)),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    T(lang, "record.payer_self", invoker.Username),
+							Style:    discordgo.PrimaryButton,
+							CustomID: customID(recordPayerInvoker),
+						},
+						discordgo.Button{
+							Label:    T(lang, "record.payer_author", target.Author.Username),
+							Style:    discordgo.SecondaryButton,
+							CustomID: customID(recordPayerAuthor),
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// Payer選択ボタンが押された時の処理
+func handleRecordReceiptButton(c *InteractionContext) {
+	lang := c.Lang()
+	parts := strings.Split(strings.TrimPrefix(c.MessageComponentData().CustomID, recordReceiptCustomIDPrefix), ":")
+	if len(parts) != 3 {
+		c.ReplyEphemeral(T(lang, "record.invalid_button"))
+		return
+	}
+	channelID, messageID, payerChoice := parts[0], parts[1], parts[2]
+
+	target, err := c.Session.ChannelMessage(channelID, messageID)
+	if err != nil {
+		log.Printf("❌ メッセージ取得失敗 (%s/%s): %v", channelID, messageID, err)
+		c.ReplyEphemeral(T(lang, "record.target_not_found"))
+		return
+	}
+
+	payerUser := c.User()
+	if payerChoice == recordPayerAuthor && target.Author != nil {
+		payerUser = target.Author
+	}
+
+	sources := ReceiptSourcesFromMessage(target)
+	if len(sources) == 0 {
+		c.ReplyEphemeral(T(lang, "record.no_receipts"))
+		return
+	}
+
+	// ボタン付きのメッセージを更新して二重送信を防ぐ
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    T(lang, "record.recording_for", payerUser.Username, len(sources)),
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+
+	startRecordReceipts(c.Session, lang, target, payerUser, sources)
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 定期支出を記録する時刻（予定日のこの時刻を過ぎたら記録する）
+const recurringRunHour = 9
+
+// 予定日を確認する間隔
+const recurringCheckInterval = 10 * time.Minute
+
+// 毎月決まった日に家計簿に記録する支出（家賃・サブスクリプション・光熱費など）
+type RecurringExpense struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Amount    Money     `json:"amount"`
+	Category  string    `json:"category"`
+	Day       int       `json:"day"` // 毎月の予定日（その月にない日は月末）
+	Payer     string    `json:"payer"`
+	GuildID   string    `json:"guild_id,omitempty"` // 告知の言語の決定に使う
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	NextRun   time.Time `json:"next_run"`           // 次に記録する日時
+	LastRun   time.Time `json:"last_run,omitempty"` // 最後に記録した回の予定日時
+}
+
+// 指定した月の予定日時（その月にない日は月末にする）
+func recurringDueDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month, day, recurringRunHour, 0, 0, 0, loc)
+}
+
+// 登録した時点から見た最初の予定日時（今月の予定日時を過ぎていれば来月）
+func firstRecurringRun(day int, now time.Time) time.Time {
+	due := recurringDueDate(now.Year(), now.Month(), day, now.Location())
+	if due.Before(now) {
+		due = recurringDueDate(now.Year(), now.Month()+1, day, now.Location())
+	}
+	return due
+}
+
+// 前回の予定日時の次の月の予定日時
+func nextRecurringRun(previous time.Time, day int) time.Time {
+	return recurringDueDate(previous.Year(), previous.Month()+1, day, previous.Location())
+}
+
+// 定期支出を保存するストア（pathが空の場合はメモリ上のみ）
+type RecurringStore struct {
+	mu       sync.Mutex
+	path     string
+	expenses map[string]*RecurringExpense
+}
+
+// 定期支出（起動時にLoadRecurringStoreで差し替える）
+var recurringExpenses = NewRecurringStore("")
+
+// 定期支出のファイルパスを取得する（デフォルト: data/recurring.json）
+func GetRecurringPath() string {
+	if path := os.Getenv("RECURRING_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join("data", "recurring.json")
+}
+
+func NewRecurringStore(path string) *RecurringStore {
+	return &RecurringStore{path: path, expenses: map[string]*RecurringExpense{}}
+}
+
+// ファイルから定期支出を読み込む（ファイルがない場合は空のストアを返す）
+func LoadRecurringStore(path string) (*RecurringStore, error) {
+	store := NewRecurringStore(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, fmt.Errorf("定期支出の読み込みエラー: %v", err)
+	}
+
+	var expenses []*RecurringExpense
+	if err := json.Unmarshal(data, &expenses); err != nil {
+		return store, fmt.Errorf("定期支出の解析エラー: %v", err)
+	}
+	for _, expense := range expenses {
+		store.expenses[expense.ID] = expense
+	}
+
+	log.Printf("📚 定期支出を読み込みました: %d件 (%s)", len(expenses), path)
+	return store, nil
+}
+
+// 定期支出を登録して保存する（最初の予定日時はnowから決める）
+// 保存に失敗した場合は登録を取り消してエラーを返す
+func (st *RecurringStore) Add(expense RecurringExpense, now time.Time) (RecurringExpense, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expense.ID = newShortID(func(id string) bool {
+		_, exists := st.expenses[id]
+		return exists
+	})
+	expense.CreatedAt = now
+	expense.NextRun = firstRecurringRun(expense.Day, now)
+	st.expenses[expense.ID] = &expense
+	if err := st.save(); err != nil {
+		delete(st.expenses, expense.ID)
+		return RecurringExpense{}, err
+	}
+	return expense, nil
+}
+
+// 定期支出を削除する（見つからない場合はfalseを返す）
+// 保存に失敗した場合は削除を取り消してエラーを返す
+func (st *RecurringStore) Remove(id string) (RecurringExpense, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expense, ok := st.expenses[id]
+	if !ok {
+		return RecurringExpense{}, false, nil
+	}
+	delete(st.expenses, id)
+	if err := st.save(); err != nil {
+		st.expenses[id] = expense
+		return RecurringExpense{}, true, err
+	}
+	return *expense, true, nil
+}
+
+// 全ての定期支出を予定日・名前順に返す
+func (st *RecurringStore) List() []RecurringExpense {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expenses := make([]RecurringExpense, 0, len(st.expenses))
+	for _, expense := range st.expenses {
+		expenses = append(expenses, *expense)
+	}
+	sort.Slice(expenses, func(a, b int) bool {
+		if expenses[a].Day != expenses[b].Day {
+			return expenses[a].Day < expenses[b].Day
+		}
+		return expenses[a].Name < expenses[b].Name
+	})
+	return expenses
+}
+
+// 予定日時を過ぎた定期支出を予定日時の古い順に返す
+func (st *RecurringStore) Due(now time.Time) []RecurringExpense {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var due []RecurringExpense
+	for _, expense := range st.expenses {
+		if !expense.NextRun.After(now) {
+			due = append(due, *expense)
+		}
+	}
+	sort.Slice(due, func(a, b int) bool {
+		return due[a].NextRun.Before(due[b].NextRun)
+	})
+	return due
+}
+
+// 予定日時runの回を記録するため、次の予定日時に進めて保存する
+// 既に進んでいる・削除された場合はfalseを返す（同じ回を二重に記録しないため）
+// 保存に失敗した場合は進めずにエラーを返す
+func (st *RecurringStore) Advance(id string, run time.Time) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expense, ok := st.expenses[id]
+	if !ok || !expense.NextRun.Equal(run) {
+		return false, nil
+	}
+	lastRun := expense.LastRun
+	expense.LastRun = run
+	expense.NextRun = nextRecurringRun(run, expense.Day)
+	if err := st.save(); err != nil {
+		expense.LastRun, expense.NextRun = lastRun, run
+		return false, err
+	}
+	return true, nil
+}
+
+// Advanceで進めた予定日時runの回を記録できなかったので、予定日時を元に戻して保存する
+// その後に進められた・削除された場合は何もしない
+func (st *RecurringStore) Rewind(id string, run, lastRun time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expense, ok := st.expenses[id]
+	if !ok || !expense.LastRun.Equal(run) || !expense.NextRun.Equal(nextRecurringRun(run, expense.Day)) {
+		return nil
+	}
+	expense.LastRun, expense.NextRun = lastRun, run
+	return st.save()
+}
+
+// 定期支出をファイルに保存する（呼び出し側でロックを取ること）
+func (st *RecurringStore) save() error {
+	if st.path == "" {
+		return nil
+	}
+
+	expenses := make([]*RecurringExpense, 0, len(st.expenses))
+	for _, expense := range st.expenses {
+		expenses = append(expenses, expense)
+	}
+	sort.Slice(expenses, func(a, b int) bool {
+		return expenses[a].CreatedAt.Before(expenses[b].CreatedAt)
+	})
+	return writeJSONFileAtomic(st.path, expenses)
+}
+
+// 定期支出の記録を開始する
+// 起動直後に停止中に過ぎた予定日の分を記録し、その後は一定間隔で予定日を確認する
+func StartRecurringScheduler(s *discordgo.Session) {
+	log.Printf("🔁 定期支出の記録を開始しました (%d件, %v間隔)", len(recurringExpenses.List()), recurringCheckInterval)
+
+	go func() {
+		runDueRecurringExpenses(s, time.Now())
+
+		ticker := time.NewTicker(recurringCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueRecurringExpenses(s, now)
+		}
+	}()
+}
+
+// 同時に記録処理が走らないようにする
+var recurringRunMu sync.Mutex
+
+// 予定日時を過ぎた定期支出を家計簿に記録し、家計簿チャンネルで告知する
+// 停止中に何回分も予定日を過ぎた場合は、古い回から順に全て記録する
+func runDueRecurringExpenses(s *discordgo.Session, now time.Time) {
+	recurringRunMu.Lock()
+	defer recurringRunMu.Unlock()
+
+	// 記録に失敗した定期支出は次の確認まで再試行しない
+	failed := map[string]bool{}
+	for {
+		var next *RecurringExpense
+		for _, expense := range recurringExpenses.Due(now) {
+			if !failed[expense.ID] {
+				next = &expense
+				break
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		if err := recordRecurringExpense(s, *next, now); err != nil {
+			log.Printf("❌ 定期支出の記録失敗 (%s, %s): %v", next.Name, next.NextRun.Format("2006/01/02"), err)
+			failed[next.ID] = true
+		}
+	}
+}
+
+// 定期支出の1回分を家計簿に記録して告知する
+// 再起動後の記録で同じ回を二重に記録しないよう、家計簿に記録する前に次の予定日時を保存する
+func recordRecurringExpense(s *discordgo.Session, expense RecurringExpense, now time.Time) error {
+	run := expense.NextRun
+	advanced, err := recurringExpenses.Advance(expense.ID, run)
+	if err != nil {
+		return fmt.Errorf("定期支出の保存失敗: %w", err)
+	}
+	if !advanced {
+		return nil
+	}
+	err = AppendLedgerEntry(LedgerEntry{
+		Date:     run.Format("2006/01/02"),
+		Store:    expense.Name,
+		Item:     expense.Name,
+		Category: expense.Category,
+		Amount:   expense.Amount.Float(),
+		Payer:    expense.Payer,
+	})
+	if err != nil {
+		// 次の確認で記録し直せるよう予定日時を元に戻す
+		if rewindErr := recurringExpenses.Rewind(expense.ID, run, expense.LastRun); rewindErr != nil {
+			log.Printf("❌ 定期支出の保存失敗 (%s, %s の記録は再試行されません): %v", expense.Name, run.Format("2006/01/02"), rewindErr)
+		}
+		return err
+	}
+	log.Printf("🔁 定期支出を記録しました: %s %s (%s)", expense.Name, expense.Amount.Format(LangJapanese), run.Format("2006/01/02"))
+
+	lang := ResolveLang(expense.GuildID, "")
+	message := T(lang, "recurring.recorded", expense.Name, expense.Amount.Format(lang), expense.Category, expense.Payer, run.Format("2006/01/02"))
+	// 予定日の翌日以降に記録した場合（停止中に予定日を過ぎた場合）はそのことを書き添える
+	if now.Sub(run) >= 24*time.Hour {
+		message += "\n" + T(lang, "recurring.caught_up")
+	}
+	if _, err := s.ChannelMessageSend(GetBudgetChannelID(), message); err != nil {
+		log.Printf("⚠️  定期支出の告知失敗: %v", err)
+	}
+	return nil
+}
+
+// 定期支出を登録・表示・削除するコマンドの定義
+var recurringCommand = &discordgo.ApplicationCommand{
+	Name:                     "recurring",
+	Description:              "家賃・サブスクなど毎月の定期支出を登録・表示・削除します",
+	DescriptionLocalizations: englishLocalizations("Add, list or remove monthly recurring expenses"),
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     "add",
+			Description:              "毎月の定期支出を登録します",
+			DescriptionLocalizations: *englishLocalizations("Add a monthly recurring expense"),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "name",
+					Description:              "名前（例: 家賃）",
+					DescriptionLocalizations: *englishLocalizations("Name (e.g. Rent)"),
+					Required:                 true,
+				},
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "amount",
+					Description:              "金額（例: 85000、¥85,000）",
+					DescriptionLocalizations: *englishLocalizations("Amount (e.g. 85000)"),
+					Required:                 true,
+				},
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "category",
+					Description:              "カテゴリ（例: 住居費）",
+					DescriptionLocalizations: *englishLocalizations("Category (e.g. Housing)"),
+					Required:                 true,
+				},
+				{
+					Type:                     discordgo.ApplicationCommandOptionInteger,
+					Name:                     "day",
+					Description:              "毎月の記録日（その月にない日は月末に記録します）",
+					DescriptionLocalizations: *englishLocalizations("Day of the month (months without that day use the last day)"),
+					Required:                 true,
+					MinValue:                 func() *float64 { v := 1.0; return &v }(),
+					MaxValue:                 31,
+				},
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "payer",
+					Description:              "支払う人（省略した場合は自分）",
+					DescriptionLocalizations: *englishLocalizations("Who pays (defaults to you)"),
+				},
+			},
+		},
+		{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     "list",
+			Description:              "登録されている定期支出を表示します",
+			DescriptionLocalizations: *englishLocalizations("Show the recurring expenses"),
+		},
+		{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     "remove",
+			Description:              "定期支出を削除します",
+			DescriptionLocalizations: *englishLocalizations("Remove a recurring expense"),
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:                     discordgo.ApplicationCommandOptionString,
+					Name:                     "id",
+					Description:              "削除する定期支出のID（/recurring list で確認できます）",
+					DescriptionLocalizations: *englishLocalizations("ID of the expense to remove (see /recurring list)"),
+					Required:                 true,
+				},
+			},
+		},
+	},
+}
+
+func init() {
+	router.HandleCommand(recurringCommand, handleRecurringCommand, RouteOptions{})
+}
+
+// /recurring が実行された時の処理
+func handleRecurringCommand(c *InteractionContext) {
+	options := c.ApplicationCommandData().Options
+	if len(options) == 0 {
+		handleRecurringListCommand(c)
+		return
+	}
+
+	values := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range options[0].Options {
+		values[option.Name] = option
+	}
+
+	switch options[0].Name {
+	case "add":
+		handleRecurringAddCommand(c, values)
+	case "remove":
+		handleRecurringRemoveCommand(c, values)
+	default:
+		handleRecurringListCommand(c)
+	}
+}
+
+// /recurring add: 定期支出を登録する
+func handleRecurringAddCommand(c *InteractionContext, values map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	lang := c.Lang()
+	base := GetBaseCurrency()
+	user := c.User()
+
+	amountText := values["amount"].StringValue()
+	amount, err := ParseMoney(amountText, base)
+	if err != nil || amount.Minor <= 0 {
+		c.ReplyEphemeral(T(lang, "recurring.invalid_amount", amountText))
+		return
+	}
+	if amount.Currency != base {
+		c.ReplyEphemeral(T(lang, "recurring.invalid_currency", base))
+		return
+	}
+
+	payer := getPayerFromDiscordUser(user.ID, user.Username)
+	if option, ok := values["payer"]; ok && strings.TrimSpace(option.StringValue()) != "" {
+		payer = strings.TrimSpace(option.StringValue())
+	}
+
+	expense, err := recurringExpenses.Add(RecurringExpense{
+		Name:      strings.TrimSpace(values["name"].StringValue()),
+		Amount:    amount,
+		Category:  strings.TrimSpace(values["category"].StringValue()),
+		Day:       int(values["day"].IntValue()),
+		Payer:     payer,
+		GuildID:   c.GuildID,
+		CreatedBy: user.Username,
+	}, time.Now().In(GetTimezone()))
+	if err != nil {
+		log.Printf("❌ 定期支出の保存失敗: %v", err)
+		c.ReplyEphemeral(T(lang, "recurring.save_failed", err))
+		return
+	}
+
+	log.Printf("🔁 定期支出登録 - UserID: %s, %s %s 毎月%d日 (ID: %s)", user.ID, expense.Name, expense.Amount.Format(LangJapanese), expense.Day, expense.ID)
+	c.Reply(T(lang, "recurring.added", expense.Name, expense.Amount.Format(lang), expense.Day, expense.Category, expense.Payer, expense.NextRun.Format("2006/01/02"), expense.ID))
+}
+
+// /recurring list: 登録されている定期支出を表示する
+func handleRecurringListCommand(c *InteractionContext) {
+	lang := c.Lang()
+
+	expenses := recurringExpenses.List()
+	if len(expenses) == 0 {
+		c.ReplyEphemeral(T(lang, "recurring.list_empty"))
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(T(lang, "recurring.list_title") + "\n```\n")
+	for _, expense := range expenses {
+		message.WriteString(T(lang, "recurring.list_item", expense.ID, expense.Day, expense.Name, expense.Amount.Format(lang), expense.Category, expense.Payer, expense.NextRun.Format("2006/01/02")) + "\n")
+	}
+	message.WriteString("```")
+	c.ReplyEphemeral(message.String())
+}
+
+// /recurring remove: 定期支出を削除する
+func handleRecurringRemoveCommand(c *InteractionContext, values map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	lang := c.Lang()
+	id := strings.TrimSpace(values["id"].StringValue())
+
+	expense, ok, err := recurringExpenses.Remove(id)
+	if !ok {
+		c.ReplyEphemeral(T(lang, "recurring.not_found", id))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ 定期支出の保存失敗: %v", err)
+		c.ReplyEphemeral(T(lang, "recurring.save_failed", err))
+		return
+	}
+
+	log.Printf("🗑️ 定期支出削除 - UserID: %s, %s (ID: %s)", c.User().ID, expense.Name, expense.ID)
+	c.Reply(T(lang, "recurring.removed", expense.Name, expense.Amount.Format(lang)))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 返品・返金として記録するボタンのCustomIDの接頭辞（後ろにジョブIDを付ける）
+const refundReceiptCustomIDPrefix = "refund_receipt:"
+
+// 返品・返金のレシートと判定するキーワードを取得する（REFUND_KEYWORDS、カンマ区切り）
+func GetRefundKeywords() []string {
+	value := os.Getenv("REFUND_KEYWORDS")
+	if value == "" {
+		value = "返品,返金"
+	}
+	var keywords []string
+	for _, keyword := range strings.Split(value, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, strings.ToLower(keyword))
+		}
+	}
+	return keywords
+}
+
+// 返品・返金の書類かどうかを判定する insertedData の項目
+var refundDocumentFields = []string{"document_type", "title"}
+
+// insertedData の書類の種類・タイトルが返品・返金のものかどうか
+func isRefundDocument(inserted map[string]interface{}) bool {
+	for _, field := range refundDocumentFields {
+		if IsRefundText(stringField(inserted, field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返品・返金のキーワードを含むかどうか
+func IsRefundText(s string) bool {
+	s = strings.ToLower(s)
+	for _, keyword := range GetRefundKeywords() {
+		if strings.Contains(s, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返品・返金として扱う（金額をマイナスにする）
+func (r *ReceiptResult) MarkRefund() {
+	r.Refund = true
+	if r.Amount.Minor > 0 {
+		r.Amount = r.Amount.Neg()
+	}
+	if r.BaseAmount.Minor > 0 {
+		r.BaseAmount = r.BaseAmount.Neg()
+	}
+}
+
+// 返品・返金の元の記録の候補かどうか
+// 同じ店舗で、金額が同じ（換算後の基準通貨、換算できていなければ元の通貨で比較）か項目が同じものを探す
+func refundMatches(original, refund *ReceiptResult) (exact, partial bool) {
+	if original.Refund || original.Store == "" || !strings.EqualFold(strings.TrimSpace(original.Store), strings.TrimSpace(refund.Store)) {
+		return false, false
+	}
+	if original.Rate > 0 && refund.Rate > 0 && original.BaseAmount.Currency == refund.BaseAmount.Currency {
+		exact = original.BaseAmount.Minor == -refund.BaseAmount.Minor
+	} else if original.Amount.Currency == refund.Amount.Currency {
+		exact = original.Amount.Minor == -refund.Amount.Minor
+	}
+	partial = refund.Item != "" && strings.EqualFold(strings.TrimSpace(original.Item), strings.TrimSpace(refund.Item))
+	return exact, partial
+}
+
+// 返品・返金の元の記録を探す（呼び出し側でロックを取ること）
+// 金額が一致するものを優先し、なければ項目が一致するもの。どちらも新しい記録を優先する
+// 既に別の返品・返金と紐付いている記録は対象にしない
+func (st *JobStore) findRefundOriginal(refundJob *ReceiptJob) string {
+	linked := map[string]bool{}
+	for _, job := range st.jobs {
+		if job.Result != nil && job.Result.RefundOf != "" && job.ID != refundJob.ID {
+			linked[job.Result.RefundOf] = true
+		}
+	}
+
+	var exactMatch, partialMatch *ReceiptJob
+	for _, job := range st.jobs {
+		if job.ID == refundJob.ID || job.Status != JobStatusDone || job.Result == nil || linked[job.ID] || job.CreatedAt.After(refundJob.CreatedAt) {
+			continue
+		}
+		exact, partial := refundMatches(job.Result, refundJob.Result)
+		if exact && (exactMatch == nil || job.CreatedAt.After(exactMatch.CreatedAt)) {
+			exactMatch = job
+		}
+		if partial && (partialMatch == nil || job.CreatedAt.After(partialMatch.CreatedAt)) {
+			partialMatch = job
+		}
+	}
+
+	if exactMatch != nil {
+		return exactMatch.ID
+	}
+	if partialMatch != nil {
+		return partialMatch.ID
+	}
+	return ""
+}
+
+// 返品・返金のジョブを元の記録に紐付けて保存する
+func (st *JobStore) LinkRefund(id string) (ReceiptJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok || job.Result == nil || !job.Result.Refund {
+		return ReceiptJob{}, false
+	}
+	job.Result.RefundOf = st.findRefundOriginal(job)
+	job.UpdatedAt = time.Now()
+	st.saveOrLog()
+	return *job, true
+}
+
+// 記録済みのジョブを返品・返金に変更し、元の記録に紐付けて保存する
+// 記録済みでない・既に返品・返金の場合はfalseを返す
+// 保存に失敗した場合は変更を取り消してエラーを返す
+func (st *JobStore) MarkRefund(id string) (ReceiptJob, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok || job.Status != JobStatusDone || job.Result == nil || job.Result.Refund {
+		return ReceiptJob{}, false, nil
+	}
+	previous, updatedAt := job.Result, job.UpdatedAt
+	result := *job.Result
+	result.MarkRefund()
+	job.Result = &result
+	job.Result.RefundOf = st.findRefundOriginal(job)
+	job.UpdatedAt = time.Now()
+	if err := st.save(); err != nil {
+		job.Result, job.UpdatedAt = previous, updatedAt
+		return ReceiptJob{}, true, err
+	}
+	return *job, true, nil
+}
+
+func init() {
+	router.HandleComponent(refundReceiptCustomIDPrefix, handleRefundReceiptButton, RouteOptions{})
+}
+
+// 購入として記録済みのレシートを返品・返金として家計簿に記録する（記録の方法によらずBotが行を追加する）
+// Difyのワークフローが記録した行はBotから探せないため書き換えず、購入の行を打ち消す行と返品・返金の行を追加する
+// 打ち消す行を追加できなかった場合は家計簿を変えずにエラーを、返品・返金の行だけ追加できなかった場合は partial=true でエラーを返す
+func recordRefundInLedger(purchase, refund ReceiptJob) (partial bool, err error) {
+	cancel, err := receiptLedgerEntry(purchase)
+	if err != nil {
+		return false, err
+	}
+	entry, err := receiptLedgerEntry(refund)
+	if err != nil {
+		return false, err
+	}
+
+	cancel.Amount, cancel.OriginalAmount = -cancel.Amount, -cancel.OriginalAmount
+	cancel.Cancels = purchase.ID
+	if err := AppendLedgerEntry(cancel); err != nil {
+		return false, err
+	}
+	if err := AppendLedgerEntry(entry); err != nil {
+		return true, err
+	}
+	log.Printf("📒 家計簿に返品・返金を記録しました: %s %v (JobID: %s, 元の記録: %s)", entry.Store, entry.Amount, refund.ID, entry.RefundOf)
+	return false, nil
+}
+
+// 記録できたレシートの結果メッセージに付ける「返品・返金」ボタン
+func refundReceiptComponents(lang Lang, jobID string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    T(lang, "refund.button"),
+					Style:    discordgo.SecondaryButton,
+					CustomID: refundReceiptCustomIDPrefix + jobID,
+					Emoji:    &discordgo.ComponentEmoji{Name: "↩️"},
+				},
+			},
+		},
+	}
+}
+
+// 「返品・返金」ボタンが押された時の処理
+func handleRefundReceiptButton(c *InteractionContext) {
+	jobID := strings.TrimPrefix(c.MessageComponentData().CustomID, refundReceiptCustomIDPrefix)
+	lang := c.Lang()
+
+	before, _ := receiptJobs.Get(jobID)
+	job, ok, err := receiptJobs.MarkRefund(jobID)
+	if !ok {
+		if existing, found := receiptJobs.Get(jobID); found && existing.Result != nil && existing.Result.Refund {
+			c.ReplyEphemeral(T(lang, "refund.already"))
+		} else {
+			c.ReplyEphemeral(T(lang, "refund.not_found"))
+		}
+		return
+	}
+	if err != nil {
+		// 処理履歴は変わっていないので、ボタンを残したまま押した人にだけ伝える
+		log.Printf("❌ 処理履歴の保存失敗 (JobID: %s): %v", jobID, err)
+		c.ReplyEphemeral(T(lang, "refund.save_failed", err))
+		return
+	}
+
+	// 家計簿にも記録する（GASの応答を待つ間に期限切れにならないよう先に応答しておく）
+	c.Defer(false)
+	notice := ""
+	if partial, err := recordRefundInLedger(before, job); partial {
+		// 打ち消した行は戻せないため処理履歴は返品・返金のままにし、残りを手で追加してもらう
+		log.Printf("❌ 家計簿への返品・返金の記録失敗 (JobID: %s): %v", job.ID, err)
+		notice = T(lang, "refund.ledger_partial", err, job.Result.DisplayAmount(lang))
+	} else if err != nil {
+		log.Printf("❌ 家計簿への返品・返金の記録失敗 (JobID: %s): %v", job.ID, err)
+		// 処理履歴を元に戻し、ボタンを残したまま押した人にだけ失敗を伝える
+		receiptJobs.Update(before.ID, before.Status, before.Error, before.Result)
+		c.Respond(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{Components: refundReceiptComponents(lang, job.ID)},
+		})
+		c.ReplyEphemeral(T(lang, "refund.ledger_failed", err))
+		return
+	}
+
+	log.Printf("↩️ 返品・返金として記録 - UserID: %s, JobID: %s, 元の記録: %s", c.User().ID, job.ID, job.Result.RefundOf)
+
+	// 結果の埋め込みを返品・返金の表示に差し替え、ボタンを外す（サムネイルはそのまま使う）
+	outcome := receiptOutcome{Stage: StageDone, Result: job.Result}
+	embed := ReceiptResultEmbed(lang, SanitizeFilename(job.Source.Filename), payerLabel(job.UserID, job.Username), outcome, "")
+	if c.Message != nil && len(c.Message.Embeds) > 0 {
+		embed.Thumbnail = c.Message.Embeds[0].Thumbnail
+	}
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+
+	// 家計簿に一部しか記録できなかった場合は、押した人にだけ手で直すよう伝える
+	if notice != "" {
+		c.ReplyEphemeral(notice)
+	}
+}
+
+// 返品・返金の元の記録の表示（例: "スーパー 2025/01/02 1,280円"）
+func refundOriginalText(lang Lang, jobID string) string {
+	original, ok := receiptJobs.Get(jobID)
+	if !ok || original.Result == nil {
+		return ""
+	}
+	parts := []string{original.Result.Store}
+	if original.Result.Date != "" {
+		parts = append(parts, original.Result.Date)
+	}
+	parts = append(parts, original.Result.DisplayAmount(lang))
+	return strings.Join(parts, " ")
+}
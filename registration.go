@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// コマンドを登録する先のサーバーID（開発用）
+// 設定するとそのサーバーにだけ登録するため、グローバル登録と違ってすぐに反映される
+func GetCommandGuildID() string {
+	return os.Getenv("DEV_GUILD_ID")
+}
+
+// 終了時に登録したコマンドを削除するかどうか（DELETE_COMMANDS_ON_SHUTDOWN=true で有効）
+func isDeleteCommandsOnShutdown() bool {
+	return os.Getenv("DELETE_COMMANDS_ON_SHUTDOWN") == "true"
+}
+
+// 比較に使うコマンドの定義（IDやバージョンなどDiscordが付ける値は含めない）
+type commandSpec struct {
+	Name                     string                                `json:"name"`
+	Type                     discordgo.ApplicationCommandType      `json:"type"`
+	Description              string                                `json:"description"`
+	NameLocalizations        map[discordgo.Locale]string           `json:"name_localizations,omitempty"`
+	DescriptionLocalizations map[discordgo.Locale]string           `json:"description_localizations,omitempty"`
+	DefaultMemberPermissions *int64                                `json:"default_member_permissions,omitempty"`
+	Options                  []*discordgo.ApplicationCommandOption `json:"options,omitempty"`
+}
+
+func newCommandSpec(c *discordgo.ApplicationCommand) commandSpec {
+	spec := commandSpec{
+		Name:                     c.Name,
+		Type:                     c.Type,
+		Description:              c.Description,
+		DefaultMemberPermissions: c.DefaultMemberPermissions,
+		Options:                  c.Options,
+	}
+	// Typeを省略した場合はスラッシュコマンドとして登録される
+	if spec.Type == 0 {
+		spec.Type = discordgo.ChatApplicationCommand
+	}
+	if c.NameLocalizations != nil && len(*c.NameLocalizations) > 0 {
+		spec.NameLocalizations = *c.NameLocalizations
+	}
+	if c.DescriptionLocalizations != nil && len(*c.DescriptionLocalizations) > 0 {
+		spec.DescriptionLocalizations = *c.DescriptionLocalizations
+	}
+	return spec
+}
+
+// 登録済みのコマンドと登録したいコマンドの差分（コマンド名）
+type CommandDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+func (d CommandDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// 登録済みのコマンドと登録したいコマンドを比較する
+func DiffCommands(registered, desired []*discordgo.ApplicationCommand) CommandDiff {
+	key := func(c *discordgo.ApplicationCommand) string {
+		return fmt.Sprintf("%d:%s", newCommandSpec(c).Type, c.Name)
+	}
+
+	current := map[string]string{}
+	for _, c := range registered {
+		data, _ := json.Marshal(newCommandSpec(c))
+		current[key(c)] = string(data)
+	}
+
+	var diff CommandDiff
+	wanted := map[string]bool{}
+	for _, c := range desired {
+		k := key(c)
+		wanted[k] = true
+		data, _ := json.Marshal(newCommandSpec(c))
+		existing, ok := current[k]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, c.Name)
+		case existing != string(data):
+			diff.Changed = append(diff.Changed, c.Name)
+		}
+	}
+	for _, c := range registered {
+		if !wanted[key(c)] {
+			diff.Removed = append(diff.Removed, c.Name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// コマンドを登録する
+// 登録済みのコマンドと比較し、変更がある場合だけ一括上書きする（不要になったコマンドも削除される）
+func RegisterCommands(s *discordgo.Session, appID, guildID string, commands []*discordgo.ApplicationCommand) error {
+	scope := "グローバル"
+	if guildID != "" {
+		scope = "サーバー " + guildID
+	}
+
+	registered, err := s.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return err
+	}
+
+	diff := DiffCommands(registered, commands)
+	if diff.Empty() {
+		log.Printf("✅ コマンドに変更はありません（%s, %d件）", scope, len(commands))
+		return nil
+	}
+
+	log.Printf("🔄 コマンドを一括登録します（%s） - 追加: %v, 変更: %v, 削除: %v", scope, diff.Added, diff.Changed, diff.Removed)
+	if _, err := s.ApplicationCommandBulkOverwrite(appID, guildID, commands); err != nil {
+		return err
+	}
+
+	if guildID == "" {
+		log.Println("💡 グローバルコマンドの反映には時間がかかることがあります（開発中は DEV_GUILD_ID を設定するとすぐに反映されます）")
+	}
+	return nil
+}
+
+// 登録したコマンドを全て削除する
+func UnregisterCommands(s *discordgo.Session, appID, guildID string) error {
+	if _, err := s.ApplicationCommandBulkOverwrite(appID, guildID, []*discordgo.ApplicationCommand{}); err != nil {
+		return err
+	}
+	log.Println("🧹 登録したコマンドを削除しました")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// レシートの日付の書き方（Difyが返す形式）
+var receiptDateLayouts = []string{
+	"2006/01/02",
+	"2006-01-02",
+	"2006/1/2",
+	"2006-1-2",
+	"2006年1月2日",
+	"2006.01.02",
+}
+
+// レシート・家計簿の日付を読み取る（読めない場合はfalseを返す）
+func parseReceiptDate(s string, loc *time.Location) (time.Time, bool) {
+	date := strings.TrimSpace(foldWidth(s))
+	for _, layout := range receiptDateLayouts {
+		if t, err := time.ParseInLocation(layout, date, loc); err == nil {
+			return t, true
+		}
+	}
+	// スプレッドシートの日付をそのままJSONにした形式（"2025-01-05T15:00:00.000Z"）
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), true
+	}
+	return time.Time{}, false
+}
+
+// 記録の日付（レシートの日付が読めない場合は処理した日）
+func (j *ReceiptJob) EntryDate(loc *time.Location) time.Time {
+	if j.Result != nil {
+		if t, ok := parseReceiptDate(j.Result.Date, loc); ok {
+			return t
+		}
+	}
+	return j.CreatedAt.In(loc)
+}
+
+// 家計簿に記録する金額（基準通貨）。換算できていない外貨はfalseを返す
+func (r *ReceiptResult) LedgerAmount(base string) (Money, bool) {
+	if r.Rate > 0 && r.BaseAmount.Currency == base {
+		return r.BaseAmount, true
+	}
+	if r.Amount.Currency == base {
+		return r.Amount, true
+	}
+	return Money{}, false
+}
+
+// 家計簿（GAS）に記録する1行
+// 外貨は換算した基準通貨の金額を記録し、元の通貨・金額・レートも一緒に記録する
+// 返品・返金はマイナスの金額で、元の記録のID（ジョブID）を refund_of として記録する
+// レートが未設定で換算できない外貨はエラーを返す（レートを設定してから再処理する）
+func (j *ReceiptJob) LedgerEntry(base string, loc *time.Location) (LedgerEntry, error) {
+	result := j.Result
+	amount, ok := result.LedgerAmount(base)
+	if !ok {
+		return LedgerEntry{}, NewLocalizedError("receipt.rate_missing", result.Amount.Currency)
+	}
+
+	payer := result.Payer
+	if payer == "" {
+		payer = getPayerFromDiscordUser(j.UserID, j.Username)
+	}
+	entry := LedgerEntry{
+		Date:     j.EntryDate(loc).Format("2006/01/02"),
+		Store:    result.Store,
+		Item:     result.Item,
+		Category: result.Category,
+		Amount:   amount.Float(),
+		Payer:    payer,
+		ID:       j.ID,
+		Refund:   result.Refund,
+		RefundOf: result.RefundOf,
+	}
+	if result.Amount.Currency != base {
+		entry.Currency = result.Amount.Currency
+		entry.OriginalAmount = result.Amount.Float()
+		entry.Rate = result.Rate
+	}
+	return entry, nil
+}
+
+// カテゴリごとの合計
+type CategoryTotal struct {
+	Name  string
+	Total Money // 返品・返金を差し引いた金額
+	Count int
+}
+
+// 今月の1日0時と来月の1日0時
+func currentMonthRange(now time.Time) (time.Time, time.Time) {
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return from, from.AddDate(0, 1, 0)
+}
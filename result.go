@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Difyワークフローの実行結果から取り出したレシートの内容
+// 処理履歴にも保存する（Difyのレスポンスそのものは保存しない）
+type ReceiptResult struct {
+	Store         string  `json:"store,omitempty"`           // 店舗
+	Item          string  `json:"item,omitempty"`            // 項目
+	Category      string  `json:"category,omitempty"`        // カテゴリ
+	Date          string  `json:"date,omitempty"`            // 日付
+	Payer         string  `json:"payer,omitempty"`           // 支払った人（Difyが返した場合のみ）
+	Amount        Money   `json:"amount"`                    // レシートに書かれた金額（通貨が分からない場合は基準通貨）
+	BaseAmount    Money   `json:"base_amount"`               // 基準通貨に換算した金額
+	Rate          float64 `json:"rate,omitempty"`            // 換算に使ったレート（0ならレート未設定で換算できていない）
+	WorkflowRunID string  `json:"workflow_run_id,omitempty"` // Difyのworkflow_run_id
+	Error         string  `json:"error,omitempty"`           // Dify内部エラー（空なら正常）
+	Refund        bool    `json:"refund,omitempty"`          // 返品・返金（金額はマイナス）
+	RefundOf      string  `json:"refund_of,omitempty"`       // 返品・返金の元の記録のジョブID（見つかった場合のみ）
+	Parsed        bool    `json:"parsed"`                    // insertedDataを解析できたかどうか
+	Raw           string  `json:"-"`                         // Difyのレスポンスそのもの
+}
+
+// Difyワークフローのレスポンスを解析する
+// data.outputs.output[0] に入っているJSON文字列の insertedData から店舗・金額などを取り出す
+func ParseReceiptResult(result string) ReceiptResult {
+	parsed := ReceiptResult{Raw: result}
+
+	var resultData map[string]interface{}
+	if err := json.Unmarshal([]byte(result), &resultData); err != nil {
+		return parsed
+	}
+
+	if v, ok := resultData["workflow_run_id"].(string); ok {
+		parsed.WorkflowRunID = v
+	}
+
+	// エラーがあるかチェック
+	if errorMsg, hasError := resultData["error"]; hasError {
+		parsed.Error = fmt.Sprintf("%v", errorMsg)
+		return parsed
+	}
+
+	data, ok := resultData["data"].(map[string]interface{})
+	if !ok {
+		return parsed
+	}
+	outputs, ok := data["outputs"].(map[string]interface{})
+	if !ok {
+		return parsed
+	}
+	outputArr, ok := outputs["output"].([]interface{})
+	if !ok || len(outputArr) == 0 {
+		return parsed
+	}
+
+	// outputArr[0]はstring型のJSON
+	str, ok := outputArr[0].(string)
+	if !ok {
+		return parsed
+	}
+	var outputObj map[string]interface{}
+	if err := json.Unmarshal([]byte(str), &outputObj); err != nil {
+		return parsed
+	}
+	inserted, ok := outputObj["insertedData"].(map[string]interface{})
+	if !ok {
+		return parsed
+	}
+
+	parsed.Parsed = true
+	parsed.Store = stringField(inserted, "store")
+	parsed.Item = stringField(inserted, "item")
+	parsed.Category = stringField(inserted, "category")
+	parsed.Date = stringField(inserted, "date")
+	parsed.Payer = stringField(inserted, "payer")
+
+	currency, ok := NormalizeCurrency(stringField(inserted, "currency"))
+	if !ok {
+		currency = GetBaseCurrency()
+	}
+	switch v := inserted["amount"].(type) {
+	case float64:
+		parsed.Amount = NewMoney(v, currency)
+	case string:
+		// "¥1,234" や "12.50 USD" のように通貨が書かれている場合はその通貨にする
+		amount, err := ParseMoney(v, currency)
+		if err != nil {
+			amount = Money{Currency: currency}
+		}
+		parsed.Amount = amount
+	default:
+		parsed.Amount = Money{Currency: currency}
+	}
+
+	// 返品・返金のレシート（refund が true、マイナスの金額、または書類の種類・タイトルに返品・返金のキーワードがある）
+	// 「返品不可」などのレシートの注意書きで判定しないよう、出力全体ではなく決まった項目だけを見る
+	if refund, _ := inserted["refund"].(bool); refund || parsed.Amount.Minor < 0 || isRefundDocument(inserted) {
+		parsed.MarkRefund()
+	}
+
+	return parsed
+}
+
+// 基準通貨に換算する
+// レートが設定されていない場合はRateが0のまま（BaseAmountは基準通貨の0）になる
+func (r *ReceiptResult) ApplyRate(rates *RateTable, base string) {
+	r.BaseAmount = Money{Currency: base}
+	if converted, rate, ok := rates.Convert(r.Amount, base); ok {
+		r.BaseAmount = converted
+		r.Rate = rate
+	}
+}
+
+// 外貨のレシートかどうか
+func (r *ReceiptResult) Foreign() bool {
+	return r.BaseAmount.Currency != "" && r.Amount.Currency != r.BaseAmount.Currency
+}
+
+// 一覧に表示する金額（換算できた外貨は基準通貨で表示する）
+func (r *ReceiptResult) DisplayAmount(lang Lang) string {
+	if r.Foreign() && r.Rate > 0 {
+		return r.BaseAmount.Format(lang)
+	}
+	return r.Amount.Format(lang)
+}
+
+// 結果の埋め込みに表示する金額（外貨は元の金額と換算した金額の両方）
+func (r *ReceiptResult) AmountText(lang Lang) string {
+	if !r.Foreign() {
+		return r.DisplayAmount(lang)
+	}
+	original := r.Amount.Format(lang)
+	if r.Rate == 0 {
+		return original + "\n" + T(lang, "result.rate_missing", r.Amount.Currency)
+	}
+	return original + "\n" + T(lang, "result.converted", r.BaseAmount.Format(lang), r.Amount.Currency, formatRate(r.Rate, r.BaseAmount.Currency))
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	if v, ok := m[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// 1枚のレシートの処理結果
+type receiptOutcome struct {
+	Stage     ReceiptStage   // StageDone / StageWarning / StageFailed
+	Message   string         // 失敗・エラー時の説明
+	Result    *ReceiptResult // Difyワークフローの結果（実行できた場合のみ）
+	Thumbnail []byte         // 圧縮後の画像のサムネイル（画像の場合のみ）
+	// 家計簿の行をDifyのワークフローが記録した（換算した金額・元の金額はBotからは家計簿に入らない）
+	DifyLedger bool
+}
+
+// 処理履歴に記録する状態
+func (o receiptOutcome) JobStatus() string {
+	switch o.Stage {
+	case StageDone:
+		return JobStatusDone
+	case StageWarning:
+		return JobStatusWarning
+	default:
+		return JobStatusFailed
+	}
+}
+
+// ステータスメッセージに表示する1行の補足
+func (o receiptOutcome) StatusDetail(lang Lang) string {
+	if o.Stage == StageDone && o.Result != nil && o.Result.Parsed {
+		return fmt.Sprintf("📍 %s ／ 💰 %s", o.Result.Store, o.Result.DisplayAmount(lang))
+	}
+	if o.Stage == StageDone {
+		return ""
+	}
+	return TruncateString(o.Message, 200)
+}
+
+// 結果の埋め込みの色
+const (
+	resultColorSuccess = 0x57F287 // 成功（緑）
+	resultColorWarning = 0xFEE75C // Dify内部エラー（黄）
+	resultColorFailure = 0xED4245 // 失敗（赤）
+	resultColorRefund  = 0x99AAB5 // 返品・返金（灰）
+)
+
+// レシート1枚の結果を表示する埋め込みを作成する
+// thumbnailNameを指定すると、同じメッセージに添付したサムネイル画像を表示する
+func ReceiptResultEmbed(lang Lang, fileName, payer string, outcome receiptOutcome, thumbnailName string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{}
+
+	footer := "📎 " + fileName
+	if outcome.Result != nil && outcome.Result.WorkflowRunID != "" {
+		footer += " ・ workflow_run_id: " + outcome.Result.WorkflowRunID
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: footer}
+
+	switch outcome.Stage {
+	case StageDone:
+		embed.Color = resultColorSuccess
+		result := outcome.Result
+		if result == nil || !result.Parsed {
+			// パースできない場合は生のレスポンスを表示
+			embed.Title = T(lang, "result.done_raw")
+			if result != nil {
+				embed.Description = fmt.Sprintf("```json\n%s\n```", TruncateString(result.Raw, 1200))
+			}
+			break
+		}
+
+		embed.Title = "🧾 " + result.Store
+		if result.Store == "" {
+			embed.Title = T(lang, "result.untitled")
+		}
+		if result.Refund {
+			embed.Color = resultColorRefund
+			embed.Title = T(lang, "result.refund_title", strings.TrimPrefix(embed.Title, "🧾 "))
+		}
+		if result.Payer != "" {
+			payer = result.Payer
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.amount"), Value: result.AmountText(lang), Inline: true})
+		if result.Category != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.category"), Value: result.Category, Inline: true})
+		}
+		if result.Date != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.date"), Value: result.Date, Inline: true})
+		}
+		if payer != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.payer"), Value: payer, Inline: true})
+		}
+		if result.Item != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.item"), Value: result.Item})
+		}
+		if outcome.DifyLedger && result.Foreign() {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.ledger_foreign"), Value: T(lang, "result.ledger_foreign_dify")})
+		}
+		if result.Refund {
+			original := T(lang, "result.refund_no_original")
+			if text := refundOriginalText(lang, result.RefundOf); text != "" {
+				original = text
+			}
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "result.refund_original"), Value: original})
+		}
+	case StageWarning:
+		embed.Color = resultColorWarning
+		embed.Title = T(lang, "result.warning_title")
+		embed.Description = fmt.Sprintf("```\n%s\n```", TruncateString(outcome.Message, 800))
+	default:
+		embed.Color = resultColorFailure
+		embed.Title = T(lang, "result.failure_title")
+		embed.Description = TruncateString(outcome.Message, 1500)
+	}
+
+	if thumbnailName != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: "attachment://" + thumbnailName}
+	}
+
+	return embed
+}
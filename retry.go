@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 再処理ボタンのCustomIDの接頭辞（retry_receipt:<jobID>）
+const retryReceiptCustomIDPrefix = "retry_receipt:"
+
+// /retry-failed のデフォルトの対象期間（日）
+const defaultRetryFailedDays = 3
+
+// /retry-failed を使える権限（サーバー全体の失敗を再処理するため、メッセージの管理権限を持つメンバーに限る）
+var retryFailedPermissions int64 = discordgo.PermissionManageMessages
+
+// 失敗したレシートをまとめて再処理するコマンドの定義
+var retryFailedCommand = &discordgo.ApplicationCommand{
+	Name:                     "retry-failed",
+	Description:              "最近失敗したレシートをまとめて再処理します",
+	DescriptionLocalizations: englishLocalizations("Retry recently failed receipts"),
+	DefaultMemberPermissions: &retryFailedPermissions,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:                     discordgo.ApplicationCommandOptionInteger,
+			Name:                     "days",
+			Description:              fmt.Sprintf("何日前までの失敗を再処理するか（デフォルト: %d日）", defaultRetryFailedDays),
+			DescriptionLocalizations: *englishLocalizations(fmt.Sprintf("How many days back to retry failures (default: %d)", defaultRetryFailedDays)),
+			MinValue:                 func() *float64 { v := 1.0; return &v }(),
+			MaxValue:                 jobHistoryRetention.Hours() / 24,
+		},
+	},
+}
+
+func init() {
+	router.HandleCommand(retryFailedCommand, handleRetryFailedCommand, RouteOptions{})
+	router.HandleComponent(retryReceiptCustomIDPrefix, handleRetryReceiptButton, RouteOptions{})
+}
+
+// 失敗したレシートの結果メッセージに付ける再処理ボタン
+func retryReceiptComponents(lang Lang, jobID string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    T(lang, "retry.button"),
+					Style:    discordgo.PrimaryButton,
+					CustomID: retryReceiptCustomIDPrefix + jobID,
+					Emoji:    &discordgo.ComponentEmoji{Name: "🔁"},
+				},
+			},
+		},
+	}
+}
+
+// 再処理ボタンが押された時の処理
+func handleRetryReceiptButton(c *InteractionContext) {
+	jobID := strings.TrimPrefix(c.MessageComponentData().CustomID, retryReceiptCustomIDPrefix)
+	lang := c.Lang()
+
+	job, ok := receiptJobs.Claim(jobID)
+	if !ok {
+		if existing, found := receiptJobs.Get(jobID); found && existing.Status == JobStatusProcessing {
+			c.ReplyEphemeral(T(lang, "retry.processing"))
+		} else if found && existing.Status == JobStatusDone {
+			c.ReplyEphemeral(T(lang, "retry.already_done"))
+		} else {
+			c.ReplyEphemeral(T(lang, "retry.not_found"))
+		}
+		return
+	}
+
+	log.Printf("🔁 再処理ボタン実行 - UserID: %s, JobID: %s, 試行回数: %d", c.User().ID, job.ID, job.Attempts)
+
+	// ボタンを外して二重に押されないようにする（埋め込みはそのまま残す）
+	c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+
+	go retryReceiptJobs(c.Session, lang, []ReceiptJob{job})
+}
+
+// /retry-failed が実行された時の処理
+func handleRetryFailedCommand(c *InteractionContext) {
+	days := defaultRetryFailedDays
+	for _, option := range c.ApplicationCommandData().Options {
+		if option.Name == "days" {
+			days = int(option.IntValue())
+		}
+	}
+
+	var jobs []ReceiptJob
+	for _, failed := range receiptJobs.FailedSince(time.Now().AddDate(0, 0, -days)) {
+		if job, ok := receiptJobs.Claim(failed.ID); ok {
+			jobs = append(jobs, job)
+		}
+	}
+
+	log.Printf("🔁 /retry-failed実行 - UserID: %s, 期間: %d日, 対象: %d件", c.User().ID, days, len(jobs))
+
+	if len(jobs) == 0 {
+		c.ReplyEphemeral(T(c.Lang(), "retry.none", days))
+		return
+	}
+
+	c.ReplyEphemeral(T(c.Lang(), "retry.started", days, len(jobs)))
+	go retryReceiptJobs(c.Session, c.Lang(), jobs)
+}
+
+// ジョブを元のメッセージ・支払った人ごとにまとめて再処理する
+func retryReceiptJobs(s *discordgo.Session, lang Lang, jobs []ReceiptJob) {
+	type batchKey struct{ channelID, messageID, userID string }
+	var keys []batchKey
+	batches := map[batchKey][]ReceiptJob{}
+	for _, job := range jobs {
+		key := batchKey{job.ChannelID, job.MessageID, job.UserID}
+		if _, exists := batches[key]; !exists {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], job)
+	}
+
+	for _, key := range keys {
+		batch := batches[key]
+
+		// 元のメッセージが消えている場合はチャンネルに直接結果を送る
+		origin, err := s.ChannelMessage(key.channelID, key.messageID)
+		if err != nil {
+			log.Printf("⚠️  元のメッセージを取得できませんでした (%s/%s): %v", key.channelID, key.messageID, err)
+			origin = &discordgo.Message{ChannelID: key.channelID}
+		} else {
+			// 添付ファイルのURLは署名付きで期限が切れるため、元のメッセージから取り直す
+			fresh := ReceiptSourcesFromMessage(origin)
+			for i := range batch {
+				batch[i].Source = refreshReceiptSource(batch[i].Source, fresh)
+			}
+		}
+
+		author := &discordgo.User{ID: batch[0].UserID, Username: batch[0].Username}
+		processReceiptJobs(s, lang, origin, author, batch)
+	}
+}
+
+// 保存していた画像の取得元を、取り直したメッセージの添付ファイルのURLに更新する
+// 添付ファイルのIDで照合し、IDを保存していない古い履歴はファイル名で照合する
+func refreshReceiptSource(source ReceiptSource, fresh []ReceiptSource) ReceiptSource {
+	for _, candidate := range fresh {
+		if candidate.AttachmentID == "" {
+			continue
+		}
+		if source.AttachmentID != "" && candidate.AttachmentID != source.AttachmentID {
+			continue
+		}
+		if source.AttachmentID == "" && candidate.Filename != source.Filename {
+			continue
+		}
+		source.URL = candidate.URL
+		source.ProxyURL = candidate.ProxyURL
+		source.AttachmentID = candidate.AttachmentID
+		return source
+	}
+	return source
+}
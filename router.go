@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// インタラクション（コマンド・ボタン・モーダル）のハンドラー
+type InteractionHandlerFunc func(c *InteractionContext)
+
+// ルートごとの応答の設定
+type RouteOptions struct {
+	Defer     bool // 時間のかかる処理のため、ハンドラーを呼ぶ前に「考え中...」の応答を返す
+	Ephemeral bool // Deferした応答を実行したユーザーにだけ表示する
+}
+
+type commandRoute struct {
+	command *discordgo.ApplicationCommand
+	handler InteractionHandlerFunc
+	options RouteOptions
+}
+
+type prefixRoute struct {
+	prefix  string
+	handler InteractionHandlerFunc
+	options RouteOptions
+}
+
+// コマンド名・ボタンのCustomID・モーダルのCustomIDからハンドラーを呼び出すルーター
+// 各ファイルのinit()で登録するため、コマンドを追加する時にmain.goを変更する必要はない
+type Router struct {
+	commands   map[string]commandRoute
+	order      []string // 登録順（コマンド登録時に使用）
+	components []prefixRoute
+	modals     []prefixRoute
+}
+
+// アプリ全体のルーター
+var router = NewRouter()
+
+func NewRouter() *Router {
+	return &Router{commands: map[string]commandRoute{}}
+}
+
+// スラッシュコマンド・コンテキストメニューを登録する
+func (r *Router) HandleCommand(command *discordgo.ApplicationCommand, handler InteractionHandlerFunc, options RouteOptions) {
+	if _, exists := r.commands[command.Name]; !exists {
+		r.order = append(r.order, command.Name)
+	}
+	r.commands[command.Name] = commandRoute{command: command, handler: handler, options: options}
+}
+
+// ボタン・セレクトメニューを登録する（CustomIDの接頭辞で判定）
+func (r *Router) HandleComponent(prefix string, handler InteractionHandlerFunc, options RouteOptions) {
+	r.components = append(r.components, prefixRoute{prefix: prefix, handler: handler, options: options})
+}
+
+// モーダルの送信を登録する（CustomIDの接頭辞で判定）
+func (r *Router) HandleModal(prefix string, handler InteractionHandlerFunc, options RouteOptions) {
+	r.modals = append(r.modals, prefixRoute{prefix: prefix, handler: handler, options: options})
+}
+
+// Discordに登録するコマンドの一覧（登録順）
+func (r *Router) Commands() []*discordgo.ApplicationCommand {
+	commands := make([]*discordgo.ApplicationCommand, 0, len(r.order))
+	for _, name := range r.order {
+		commands = append(commands, r.commands[name].command)
+	}
+	return commands
+}
+
+// インタラクションに対応するハンドラーを探す（nameはログ用の名前）
+func (r *Router) route(i *discordgo.InteractionCreate) (name string, handler InteractionHandlerFunc, options RouteOptions, ok bool) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		name = i.ApplicationCommandData().Name
+		if route, found := r.commands[name]; found {
+			return "/" + name, route.handler, route.options, true
+		}
+		return "/" + name, nil, RouteOptions{}, false
+	case discordgo.InteractionMessageComponent:
+		return matchPrefix(r.components, "button:", i.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		return matchPrefix(r.modals, "modal:", i.ModalSubmitData().CustomID)
+	}
+	return fmt.Sprintf("type:%d", i.Type), nil, RouteOptions{}, false
+}
+
+func matchPrefix(routes []prefixRoute, kind, customID string) (string, InteractionHandlerFunc, RouteOptions, bool) {
+	for _, route := range routes {
+		if strings.HasPrefix(customID, route.prefix) {
+			return kind + route.prefix, route.handler, route.options, true
+		}
+	}
+	return kind + customID, nil, RouteOptions{}, false
+}
+
+// discordgoのInteractionCreateハンドラー
+func (r *Router) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	name, handler, options, ok := r.route(i)
+	c := NewInteractionContext(s, i)
+	user := c.User()
+
+	if !ok {
+		// オートコンプリートなど応答できない種類は無視する
+		if i.Type == discordgo.InteractionApplicationCommand || i.Type == discordgo.InteractionMessageComponent || i.Type == discordgo.InteractionModalSubmit {
+			log.Printf("⚠️  未登録のインタラクション: %s (UserID: %s)", name, user.ID)
+			c.ReplyEphemeral(T(c.Lang(), "router.unknown_command"))
+		}
+		return
+	}
+
+	log.Printf("⚡ インタラクション実行: %s - UserID: %s, Username: %s", name, user.ID, user.Username)
+	start := time.Now()
+
+	// ハンドラー内でpanicしてもBot全体が落ちないようにする
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("💥 インタラクション処理中にpanic: %s: %v\n%s", name, rec, debug.Stack())
+			c.ReplyEphemeral(T(c.Lang(), "router.panic"))
+		}
+	}()
+
+	if options.Defer {
+		if err := c.Defer(options.Ephemeral); err != nil {
+			log.Printf("❌ 遅延応答失敗 (%s): %v", name, err)
+			return
+		}
+	}
+
+	handler(c)
+
+	if !c.Responded() {
+		log.Printf("⚠️  ハンドラーが応答しませんでした: %s", name)
+	}
+	log.Printf("✅ インタラクション完了: %s (%s)", name, time.Since(start).Round(time.Millisecond))
+}
+
+// 1つのインタラクションへの応答を管理する
+// Discordへの応答は1回だけなので、2回目以降や遅延応答後の応答は自動的に編集・フォローアップに切り替える
+type InteractionContext struct {
+	*discordgo.InteractionCreate
+	Session *discordgo.Session
+
+	mu        sync.Mutex
+	responded bool
+	deferred  bool
+}
+
+func NewInteractionContext(s *discordgo.Session, i *discordgo.InteractionCreate) *InteractionContext {
+	return &InteractionContext{InteractionCreate: i, Session: s}
+}
+
+// インタラクションを実行したユーザー（サーバー内ならMember、DMならUser）
+func (c *InteractionContext) User() *discordgo.User {
+	return interactionUser(c.InteractionCreate)
+}
+
+// メッセージの言語（ユーザーのDiscordの言語設定 → サーバーの言語設定 → デフォルト）
+func (c *InteractionContext) Lang() Lang {
+	return ResolveLang(c.GuildID, c.Locale)
+}
+
+// 既に応答したかどうか
+func (c *InteractionContext) Responded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.responded
+}
+
+// 「考え中...」の応答を返す（ボタンの場合はメッセージを更新する準備）
+func (c *InteractionContext) Defer(ephemeral bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.responded {
+		return nil
+	}
+
+	response := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}
+	if c.Type == discordgo.InteractionMessageComponent {
+		response.Type = discordgo.InteractionResponseDeferredMessageUpdate
+	}
+	if ephemeral {
+		response.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+
+	if err := c.Session.InteractionRespond(c.Interaction, response); err != nil {
+		return err
+	}
+	c.responded = true
+	c.deferred = true
+	return nil
+}
+
+// インタラクションに応答する（添付ファイルも送れる）
+// 遅延応答後は元の応答を編集し、応答済みの場合はフォローアップメッセージとして送信する
+func (c *InteractionContext) Respond(response *discordgo.InteractionResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	data := response.Data
+	if data == nil {
+		data = &discordgo.InteractionResponseData{}
+	}
+
+	switch {
+	case !c.responded:
+		err = c.Session.InteractionRespond(c.Interaction, response)
+	case c.deferred:
+		c.deferred = false
+		edit := &discordgo.WebhookEdit{Content: &data.Content}
+		if data.Embeds != nil {
+			edit.Embeds = &data.Embeds
+		}
+		if data.Components != nil {
+			edit.Components = &data.Components
+		}
+		edit.Files = data.Files
+		_, err = c.Session.InteractionResponseEdit(c.Interaction, edit)
+	default:
+		_, err = c.Session.FollowupMessageCreate(c.Interaction, true, &discordgo.WebhookParams{
+			Content:    data.Content,
+			Embeds:     data.Embeds,
+			Components: data.Components,
+			Files:      data.Files,
+			Flags:      data.Flags,
+		})
+	}
+
+	if err != nil {
+		log.Printf("❌ インタラクション応答失敗: %v", err)
+		return err
+	}
+	c.responded = true
+	return nil
+}
+
+// メッセージで応答する
+func (c *InteractionContext) Reply(content string) error {
+	return c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content},
+	})
+}
+
+// 実行したユーザーにだけ見えるメッセージで応答する
+func (c *InteractionContext) ReplyEphemeral(content string) error {
+	return c.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
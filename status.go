@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 画像ごとの処理段階
+type ReceiptStage int
+
+const (
+	StageQueued      ReceiptStage = iota // 待機中
+	StageDownloading                     // ダウンロード中
+	StageCompressing                     // 圧縮中
+	StageUploading                       // Difyへアップロード中
+	StageWorkflow                        // Difyワークフロー実行中
+	StageDone                            // 完了
+	StageWarning                         // Dify内部エラー
+	StageFailed                          // 失敗
+)
+
+// 処理段階の表示（絵文字とラベル）のメッセージカタログのキー
+var receiptStageLabels = map[ReceiptStage]string{
+	StageQueued:      "stage.queued",
+	StageDownloading: "stage.downloading",
+	StageCompressing: "stage.compressing",
+	StageUploading:   "stage.uploading",
+	StageWorkflow:    "stage.workflow",
+	StageDone:        "stage.done",
+	StageWarning:     "stage.warning",
+	StageFailed:      "stage.failed",
+}
+
+// 処理が終わった段階かどうか
+func (st ReceiptStage) Finished() bool {
+	return st == StageDone || st == StageWarning || st == StageFailed
+}
+
+// ステータスメッセージの色
+const (
+	statusColorRunning = 0x5865F2 // 処理中（青）
+	statusColorSuccess = 0x57F287 // 全て成功（緑）
+	statusColorWarning = 0xFEE75C // 一部失敗（黄）
+	statusColorFailure = 0xED4245 // 全て失敗（赤）
+)
+
+// 編集の間隔（これより短い間隔の途中経過はまとめて反映する）
+const statusEditInterval = 1500 * time.Millisecond
+
+type statusItem struct {
+	Name   string
+	Stage  ReceiptStage
+	Detail string
+}
+
+// 1回の投稿（バッチ）につき1つのステータスメッセージを送信し、編集して進捗を反映する
+type BatchStatus struct {
+	mu        sync.Mutex
+	session   *discordgo.Session
+	lang      Lang
+	channelID string
+	messageID string
+	items     []statusItem
+	summary   string
+	color     int
+	lastEdit  time.Time
+}
+
+// ステータスメッセージを送信する
+func NewBatchStatus(s *discordgo.Session, lang Lang, channelID string, names []string) *BatchStatus {
+	b := &BatchStatus{
+		session:   s,
+		lang:      lang,
+		channelID: channelID,
+		color:     statusColorRunning,
+	}
+	for _, name := range names {
+		b.items = append(b.items, statusItem{Name: name, Stage: StageQueued})
+	}
+
+	msg, err := s.ChannelMessageSendEmbed(channelID, b.embed())
+	if err != nil {
+		log.Printf("❌ ステータスメッセージ送信失敗: %v", err)
+	} else {
+		b.messageID = msg.ID
+		b.lastEdit = time.Now()
+	}
+	return b
+}
+
+// 画像の処理段階を更新する（detailは完了・失敗時の補足）
+func (b *BatchStatus) Set(index int, stage ReceiptStage, detail string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if index < 0 || index >= len(b.items) {
+		return
+	}
+	b.items[index].Stage = stage
+	b.items[index].Detail = detail
+
+	// 途中経過は間隔を空けて反映し、完了・失敗はすぐに反映する
+	if !stage.Finished() && time.Since(b.lastEdit) < statusEditInterval {
+		return
+	}
+	b.edit()
+}
+
+// 最終結果（成功・失敗の件数）を反映する
+func (b *BatchStatus) Finish(successCount, failureCount int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case failureCount == 0:
+		b.color = statusColorSuccess
+	case successCount > 0:
+		b.color = statusColorWarning
+	default:
+		b.color = statusColorFailure
+	}
+	b.summary = receiptSummary(b.lang, successCount, failureCount)
+	b.edit()
+}
+
+// ステータスメッセージを編集する（呼び出し側でロックを取ること）
+func (b *BatchStatus) edit() {
+	if b.messageID == "" {
+		return
+	}
+	if _, err := b.session.ChannelMessageEditEmbed(b.channelID, b.messageID, b.embed()); err != nil {
+		log.Printf("⚠️  ステータスメッセージ更新失敗: %v", err)
+		return
+	}
+	b.lastEdit = time.Now()
+}
+
+// ステータスメッセージの埋め込みを作成する
+func (b *BatchStatus) embed() *discordgo.MessageEmbed {
+	var lines []string
+	for i, item := range b.items {
+		line := fmt.Sprintf("`%d/%d` %s — **%s**", i+1, len(b.items), T(b.lang, receiptStageLabels[item.Stage]), item.Name)
+		if item.Detail != "" {
+			line += "\n" + item.Detail
+		}
+		lines = append(lines, line)
+	}
+
+	description := TruncateString(strings.Join(lines, "\n"), 3800)
+	if b.summary != "" {
+		description += "\n\n" + b.summary
+	}
+
+	title := T(b.lang, "status.title_processing", len(b.items))
+	if b.summary != "" {
+		title = T(b.lang, "status.title_done", len(b.items))
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       title,
+		Description: description,
+		Color:       b.color,
+	}
+}
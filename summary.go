@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 定期的に投稿するサマリーの種類
+type SummaryKind string
+
+const (
+	SummaryWeekly  SummaryKind = "weekly"  // 前の週（月曜〜日曜）のサマリー
+	SummaryMonthly SummaryKind = "monthly" // 前の月のサマリー
+)
+
+// サマリーの埋め込みの色
+const summaryColor = 0x5865F2
+
+// カテゴリ・支払った人の表示件数の上限（埋め込みのフィールドの文字数制限のため）
+const summaryMaxLines = 10
+
+// よく使ったお店の表示件数
+const summaryTopStores = 3
+
+// サマリーの投稿に失敗した場合に再試行するまでの間隔
+const summaryRetryInterval = 10 * time.Minute
+
+// サマリーを投稿するチャンネルのID（SUMMARY_CHANNEL_ID、デフォルト: 家計簿チャンネル）
+func GetSummaryChannelID() string {
+	if channelID := os.Getenv("SUMMARY_CHANNEL_ID"); channelID != "" {
+		return channelID
+	}
+	return GetBudgetChannelID()
+}
+
+// サマリーを投稿するスケジュール（SUMMARY_WEEKLY_CRON・SUMMARY_MONTHLY_CRON、cron形式）
+// デフォルトは週間が毎週月曜9時、月間が毎月1日9時。off を指定した場合はnilを返す
+func GetSummarySchedule(kind SummaryKind) (*CronSchedule, error) {
+	spec := os.Getenv("SUMMARY_" + strings.ToUpper(string(kind)) + "_CRON")
+	switch strings.ToLower(strings.TrimSpace(spec)) {
+	case "off", "false":
+		return nil, nil
+	case "":
+		if kind == SummaryWeekly {
+			spec = "0 9 * * 1"
+		} else {
+			spec = "0 9 1 * *"
+		}
+	}
+	return ParseCronSchedule(spec)
+}
+
+// 投稿日時から見たサマリーの対象期間（from以上to未満）と、比較する前の期間の開始日
+// 週間は投稿日の前日までの7日間、月間は投稿日の前の月
+func summaryRange(kind SummaryKind, postedAt time.Time) (from, to, previousFrom time.Time) {
+	if kind == SummaryMonthly {
+		to = time.Date(postedAt.Year(), postedAt.Month(), 1, 0, 0, 0, 0, postedAt.Location())
+		from = to.AddDate(0, -1, 0)
+		return from, to, from.AddDate(0, -1, 0)
+	}
+	to = time.Date(postedAt.Year(), postedAt.Month(), postedAt.Day(), 0, 0, 0, 0, postedAt.Location())
+	from = to.AddDate(0, 0, -7)
+	return from, to, from.AddDate(0, 0, -7)
+}
+
+// 家計簿の記録の集計
+type LedgerSummary struct {
+	Total       Money // 返品・返金を差し引いた合計
+	Count       int
+	Refunds     Money // 返品・返金（マイナスの記録）の合計
+	RefundCount int
+	Categories  []CategoryTotal
+	Payers      []CategoryTotal
+	Stores      []CategoryTotal
+}
+
+// 家計簿の記録を基準通貨で集計する（返品・返金のマイナスの記録も差し引く）
+// 購入の行を打ち消す行（cancels）は金額だけ差し引き、件数は打ち消した行と合わせて数えない
+func BuildLedgerSummary(entries []LedgerEntry, base string) LedgerSummary {
+	summary := LedgerSummary{Total: Money{Currency: base}, Refunds: Money{Currency: base}, Count: len(entries)}
+	for _, entry := range entries {
+		amount := NewMoney(entry.Amount, base)
+		summary.Total, _ = summary.Total.Add(amount)
+		switch {
+		case entry.Cancels != "":
+			summary.Count -= 2
+		case amount.Minor < 0:
+			summary.Refunds, _ = summary.Refunds.Add(amount)
+			summary.RefundCount++
+		}
+	}
+	summary.Count = max(summary.Count, 0)
+	summary.Categories = ledgerTotals(entries, base, func(e LedgerEntry) string { return e.Category })
+	summary.Payers = ledgerTotals(entries, base, func(e LedgerEntry) string { return e.Payer })
+	summary.Stores = ledgerTotals(entries, base, func(e LedgerEntry) string { return e.Store })
+	return summary
+}
+
+// 家計簿の記録をkeyごとに集計し、金額の大きい順（同じ金額は名前順）に返す
+func ledgerTotals(entries []LedgerEntry, base string, key func(LedgerEntry) string) []CategoryTotal {
+	totals := map[string]*CategoryTotal{}
+	for _, entry := range entries {
+		name := strings.TrimSpace(key(entry))
+		total, exists := totals[name]
+		if !exists {
+			total = &CategoryTotal{Name: name, Total: Money{Currency: base}}
+			totals[name] = total
+		}
+		total.Total, _ = total.Total.Add(NewMoney(entry.Amount, base))
+		if entry.Cancels != "" {
+			total.Count-- // 打ち消した購入の行の分
+		} else {
+			total.Count++
+		}
+	}
+
+	result := make([]CategoryTotal, 0, len(totals))
+	for _, total := range totals {
+		// 打ち消し合って何も残らないものは表示しない
+		if total.Count <= 0 && total.Total.Minor == 0 {
+			continue
+		}
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Total.Minor != result[b].Total.Minor {
+			return result[a].Total.Minor > result[b].Total.Minor
+		}
+		return result[a].Name < result[b].Name
+	})
+	return result
+}
+
+// 前の期間との差額の表示（例: "+3,000円"）
+func formatSummaryDiff(lang Lang, current, previous Money) string {
+	diff := Money{Minor: current.Minor - previous.Minor, Currency: current.Currency}
+	switch {
+	case diff.Minor > 0:
+		return "+" + diff.Format(lang)
+	case diff.Minor == 0:
+		return "±" + diff.Format(lang)
+	}
+	return diff.Format(lang)
+}
+
+// 前の期間との差額と増減率の表示（例: "+3,000円 (+12.5%)"）
+func formatSummaryChange(lang Lang, current, previous Money) string {
+	text := formatSummaryDiff(lang, current, previous)
+	if previous.Minor > 0 {
+		text += fmt.Sprintf(" (%+.1f%%)", float64(current.Minor-previous.Minor)/float64(previous.Minor)*100)
+	}
+	return text
+}
+
+// 集計の名前の表示（空の場合は「未設定」）
+func summaryName(lang Lang, name string) string {
+	if name == "" {
+		return T(lang, "report.unnamed")
+	}
+	return name
+}
+
+// 金額の一覧のフィールドの値（前の期間の金額があれば差額も表示する）
+func summaryTotalsText(lang Lang, totals []CategoryTotal, previous []CategoryTotal) string {
+	previousTotals := map[string]Money{}
+	for _, total := range previous {
+		previousTotals[total.Name] = total.Total
+	}
+
+	var lines []string
+	for i, total := range totals {
+		if i == summaryMaxLines {
+			lines = append(lines, T(lang, "report.more", len(totals)-summaryMaxLines))
+			break
+		}
+		line := fmt.Sprintf("**%s** %s", summaryName(lang, total.Name), total.Total.Format(lang))
+		if before, ok := previousTotals[total.Name]; ok {
+			line += fmt.Sprintf(" (%s)", formatSummaryDiff(lang, total.Total, before))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 定期サマリーの埋め込み
+func LedgerSummaryEmbed(lang Lang, kind SummaryKind, from, to time.Time, current, previous LedgerSummary) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: T(lang, "report."+string(kind)+"_title", from.Format("2006/01/02"), to.AddDate(0, 0, -1).Format("2006/01/02")),
+		Color: summaryColor,
+	}
+	if current.Count == 0 {
+		embed.Description = T(lang, "report.empty")
+		return embed
+	}
+
+	embed.Description = T(lang, "report.total", current.Total.Format(lang), current.Count)
+	if previous.Count > 0 {
+		embed.Description += "\n" + T(lang, "report.vs_previous_"+string(kind), formatSummaryChange(lang, current.Total, previous.Total))
+	}
+
+	embed.Fields = append(embed.Fields,
+		&discordgo.MessageEmbedField{Name: T(lang, "report.categories"), Value: summaryTotalsText(lang, current.Categories, previous.Categories)},
+		&discordgo.MessageEmbedField{Name: T(lang, "report.payers"), Value: summaryTotalsText(lang, current.Payers, previous.Payers)},
+	)
+
+	// よく使ったお店（返品・返金で合計がマイナスになったお店は除く）
+	var stores []string
+	for _, store := range current.Stores {
+		if len(stores) == summaryTopStores || store.Total.Minor <= 0 {
+			break
+		}
+		stores = append(stores, T(lang, "report.store_line", len(stores)+1, summaryName(lang, store.Name), store.Total.Format(lang), store.Count))
+	}
+	if len(stores) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: T(lang, "report.stores"), Value: strings.Join(stores, "\n")})
+	}
+	return embed
+}
+
+// 家計簿の記録を対象期間と前の期間に分ける（日付が読めない記録は除く）
+func splitLedgerEntries(entries []LedgerEntry, previousFrom, from, to time.Time) (current, previous []LedgerEntry) {
+	for _, entry := range entries {
+		date, ok := parseReceiptDate(entry.Date, from.Location())
+		if !ok {
+			log.Printf("⚠️  日付が読めない記録を集計から除きました: %+v", entry)
+			continue
+		}
+		switch {
+		case !date.Before(from) && date.Before(to):
+			current = append(current, entry)
+		case !date.Before(previousFrom) && date.Before(from):
+			previous = append(previous, entry)
+		}
+	}
+	return current, previous
+}
+
+// サマリーを集計してチャンネルに投稿する
+func postLedgerSummary(s *discordgo.Session, kind SummaryKind, postedAt time.Time) error {
+	from, to, previousFrom := summaryRange(kind, postedAt)
+
+	// 前の期間の分もまとめて取得する
+	entries, err := GetLedgerEntries(previousFrom, to)
+	if err != nil {
+		return err
+	}
+	current, previous := splitLedgerEntries(entries, previousFrom, from, to)
+
+	channelID := GetSummaryChannelID()
+	guildID := ""
+	if channel, err := s.State.Channel(channelID); err == nil {
+		guildID = channel.GuildID
+	}
+	lang := ResolveLang(guildID, "")
+
+	base := GetBaseCurrency()
+	embed := LedgerSummaryEmbed(lang, kind, from, to, BuildLedgerSummary(current, base), BuildLedgerSummary(previous, base))
+	if _, err := s.ChannelMessageSendEmbed(channelID, embed); err != nil {
+		return err
+	}
+	log.Printf("📊 サマリーを投稿しました (%s, %s〜%s, %d件)", kind, from.Format("2006/01/02"), to.AddDate(0, 0, -1).Format("2006/01/02"), len(current))
+	return nil
+}
+
+// サマリーの投稿状況のファイルパスを取得する（デフォルト: data/summary.json）
+func GetSummaryStatePath() string {
+	if path := os.Getenv("SUMMARY_STATE_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join("data", "summary.json")
+}
+
+// サマリーの種類ごとに最後に投稿した回の日時を保存するストア（pathが空の場合はメモリ上のみ）
+// 停止中に過ぎた回を起動時に投稿するために使う
+type SummaryState struct {
+	mu       sync.Mutex
+	path     string
+	lastRuns map[SummaryKind]time.Time
+}
+
+// サマリーの投稿状況（起動時にLoadSummaryStateで差し替える）
+var summaryState = NewSummaryState("")
+
+func NewSummaryState(path string) *SummaryState {
+	return &SummaryState{path: path, lastRuns: map[SummaryKind]time.Time{}}
+}
+
+// ファイルからサマリーの投稿状況を読み込む（ファイルがない場合は空の状態を返す）
+func LoadSummaryState(path string) (*SummaryState, error) {
+	state := NewSummaryState(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("サマリーの投稿状況の読み込みエラー: %v", err)
+	}
+	if err := json.Unmarshal(data, &state.lastRuns); err != nil {
+		return state, fmt.Errorf("サマリーの投稿状況の解析エラー: %v", err)
+	}
+
+	log.Printf("📚 サマリーの投稿状況を読み込みました (%s)", path)
+	return state, nil
+}
+
+// 最後に投稿した回の日時（まだ投稿していない場合はゼロ値）
+func (st *SummaryState) LastRun(kind SummaryKind) time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.lastRuns[kind]
+}
+
+// 日時runの回を投稿したことを記録して保存する
+// 投稿は済んでいるため、保存に失敗しても記録は戻さない（次の起動で同じ回をもう一度投稿する）
+func (st *SummaryState) MarkPosted(kind SummaryKind, run time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.lastRuns[kind] = run
+	return st.save()
+}
+
+// サマリーの投稿状況をファイルに保存する（呼び出し側でロックを取ること）
+func (st *SummaryState) save() error {
+	if st.path == "" {
+		return nil
+	}
+	return writeJSONFileAtomic(st.path, st.lastRuns)
+}
+
+// 週間・月間サマリーの定期投稿を開始する（スケジュールはBOT_TIMEZONEのタイムゾーンで判定する）
+func StartSummaryScheduler(s *discordgo.Session) {
+	loc := GetTimezone()
+	for _, kind := range []SummaryKind{SummaryWeekly, SummaryMonthly} {
+		schedule, err := GetSummarySchedule(kind)
+		if err != nil {
+			log.Printf("⚠️  サマリーのスケジュールが不正です（%sのサマリーは投稿しません）: %v", kind, err)
+			continue
+		}
+		if schedule == nil {
+			log.Printf("ℹ️  %sのサマリーの投稿は無効です", kind)
+			continue
+		}
+
+		log.Printf("📊 %sのサマリーの定期投稿を開始しました (%s, %s)", kind, schedule, loc)
+		go runSummarySchedule(s, kind, schedule, loc)
+	}
+}
+
+// スケジュールの日時になるたびにサマリーを投稿する
+// 起動直後に停止中に過ぎた回を投稿し、投稿に失敗した場合は一定時間後に再試行する
+func runSummarySchedule(s *discordgo.Session, kind SummaryKind, schedule *CronSchedule, loc *time.Location) {
+	// 初めて起動した場合は、起動した時点より前の回は投稿しない
+	if summaryState.LastRun(kind).IsZero() {
+		if err := summaryState.MarkPosted(kind, time.Now().In(loc)); err != nil {
+			log.Printf("⚠️  サマリーの投稿状況の保存失敗 (%s): %v", kind, err)
+		}
+	}
+
+	for {
+		if err := postDueSummaries(s, kind, schedule, time.Now().In(loc)); err != nil {
+			log.Printf("❌ サマリーの投稿失敗 (%s): %v", kind, err)
+			time.Sleep(summaryRetryInterval)
+			continue
+		}
+
+		next := schedule.Next(time.Now().In(loc))
+		if next.IsZero() {
+			log.Printf("⚠️  %sのサマリーを投稿する日時がありません (%s)", kind, schedule)
+			return
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// 最後に投稿した回より後で、nowまでに過ぎた回のサマリーを古い順に全て投稿する
+// 投稿に失敗した場合はその回で止め、次に呼ばれた時にその回から投稿し直す
+func postDueSummaries(s *discordgo.Session, kind SummaryKind, schedule *CronSchedule, now time.Time) error {
+	for {
+		lastRun := summaryState.LastRun(kind)
+		if lastRun.IsZero() {
+			return nil
+		}
+		run := schedule.Next(lastRun.In(now.Location()))
+		if run.IsZero() || run.After(now) {
+			return nil
+		}
+
+		if err := postLedgerSummary(s, kind, run); err != nil {
+			return err
+		}
+		if err := summaryState.MarkPosted(kind, run); err != nil {
+			log.Printf("⚠️  サマリーの投稿状況の保存失敗 (%s): %v", kind, err)
+		}
+	}
+}
@@ -1,10 +1,49 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
+	_ "time/tzdata" // コンテナにタイムゾーンのデータがなくても Asia/Tokyo を使えるようにする
 )
 
+// JSONをファイルに保存する（保存先のディレクトリがなければ作る）
+// 書き込み途中で落ちても壊れないよう、一時ファイルに書いてから置き換える
+func writeJSONFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON変換失敗: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("保存先作成失敗: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 日付・スケジュールに使うタイムゾーン（BOT_TIMEZONE、デフォルト: Asia/Tokyo）
+func GetTimezone() *time.Location {
+	name := os.Getenv("BOT_TIMEZONE")
+	if name == "" {
+		name = "Asia/Tokyo"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("⚠️  タイムゾーン %q を読み込めません（Asia/Tokyoを使用します）: %v", name, err)
+		loc, _ = time.LoadLocation("Asia/Tokyo")
+	}
+	return loc
+}
+
 func TruncateString(s string, maxLen int) string {
 	// ルーン（文字）で長さを判定（マルチバイト文字対応）
 	runes := []rune(s)
@@ -15,39 +54,40 @@ func TruncateString(s string, maxLen int) string {
 }
 
 // 金額にカンマを追加する関数（例: "食費：31828" -> "食費：31,828"）
+// 金額は全角数字・マイナス・小数・"¥"や"円"付きでもよい。読み取れない場合はそのまま返す
 func FormatAmountWithComma(s string) string {
 	// "項目：金額"の形式を分割
-	parts := strings.Split(s, "：")
-	if len(parts) != 2 {
+	category, amountStr, ok := strings.Cut(s, "：")
+	if !ok || strings.Contains(amountStr, "：") {
 		return s // 形式が異なる場合はそのまま返す
 	}
 
-	category := parts[0]
-	amountStr := strings.TrimSpace(parts[1])
-
-	// 金額が空の場合はそのまま返す
-	if amountStr == "" {
+	amount, err := ParseMoney(amountStr, GetBaseCurrency())
+	if err != nil {
 		return s
 	}
+	return category + "：" + amount.Number()
+}
 
-	// 数値以外が含まれている場合はそのまま返す
-	for _, c := range amountStr {
-		if c < '0' || c > '9' {
-			return s
-		}
+// GASの "項目：金額" を言語に合わせて表示する（日本語: "食費：31,828"、英語: "食費: ¥31,828"）
+func FormatReportLine(lang Lang, s string) string {
+	if lang != LangEnglish {
+		return FormatAmountWithComma(s)
 	}
-
-	// 3桁ごとにカンマを挿入
-	var result strings.Builder
-	n := len(amountStr)
-	for i, digit := range amountStr {
-		if i > 0 && (n-i)%3 == 0 {
-			result.WriteString(",")
-		}
-		result.WriteRune(digit)
+	category, amountStr, ok := strings.Cut(s, "：")
+	if !ok {
+		return s
+	}
+	amount, err := ParseMoney(amountStr, GetBaseCurrency())
+	if err != nil {
+		return s
 	}
+	return category + ": " + amount.Format(lang)
+}
 
-	return category + "：" + result.String()
+// 金額を3桁区切りの円表記にする（例: 31828 -> "31,828円"）
+func FormatYen(amount int) string {
+	return Money{Minor: int64(amount), Currency: "JPY"}.Format(LangJapanese)
 }
 
 // ファイル名からMIME typeを判定する
@@ -62,6 +102,8 @@ func GetMimeType(filename string) string {
 		".gif":  "image/gif",
 		".bmp":  "image/bmp",
 		".webp": "image/webp",
+		".heic": "image/heic",
+		".heif": "image/heif",
 		".svg":  "image/svg+xml",
 		".ico":  "image/x-icon",
 		// PDFなど
@@ -80,3 +122,143 @@ func GetMimeType(filename string) string {
 	// デフォルト
 	return "application/octet-stream"
 }
+
+// MIME typeからDifyのファイル種別（image / document）を判定する
+func GetDifyFileType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case mimeType == "application/pdf",
+		strings.HasPrefix(mimeType, "text/"),
+		mimeType == "application/json",
+		mimeType == "application/xml":
+		return "document"
+	}
+	return "custom"
+}
+
+// レシートとして受け付けるMIME type
+var receiptMimeTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/bmp":       true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
+	"application/pdf": true,
+}
+
+// MIME typeに対応する拡張子
+var mimeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/bmp":       ".bmp",
+	"image/webp":      ".webp",
+	"image/heic":      ".heic",
+	"image/heif":      ".heif",
+	"application/pdf": ".pdf",
+}
+
+// ファイル先頭のマジックバイトからMIME typeを判定する
+// 判定できない場合は "application/octet-stream" を返す
+func DetectMimeType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif"
+	case bytes.HasPrefix(header, []byte("BM")) && len(header) >= 14:
+		return "image/bmp"
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "image/webp"
+	case bytes.HasPrefix(header, []byte("%PDF-")):
+		return "application/pdf"
+	case IsHEIC(header):
+		if string(header[8:12]) == "mif1" || string(header[8:12]) == "msf1" {
+			return "image/heif"
+		}
+		return "image/heic"
+	}
+
+	// その他は標準ライブラリの判定に任せる（パラメータは除去）
+	return baseMimeType(http.DetectContentType(header))
+}
+
+// ファイルの中身・Discordのcontent_type・拡張子からMIME typeを決定する
+// 中身の判定を優先し、拡張子やcontent_typeと食い違う場合はログに残す
+func ResolveMimeType(data []byte, filename, contentType string) string {
+	sniffed := DetectMimeType(data)
+	byExt := GetMimeType(filename)
+	declared := baseMimeType(contentType)
+
+	resolved := sniffed
+	if sniffed == "application/octet-stream" {
+		// 中身から判定できないバイナリはDiscordのcontent_type → 拡張子の順で採用
+		switch {
+		case declared != "":
+			resolved = declared
+		case byExt != "application/octet-stream":
+			resolved = byExt
+		}
+	}
+
+	if byExt != "application/octet-stream" && byExt != resolved {
+		log.Printf("⚠️  MIME type不一致 (%s): 拡張子=%s, 実際の内容=%s", filename, byExt, resolved)
+	}
+	if declared != "" && declared != resolved {
+		log.Printf("⚠️  MIME type不一致 (%s): Discord content_type=%s, 実際の内容=%s", filename, declared, resolved)
+	}
+
+	return resolved
+}
+
+// レシートとして処理できるMIME typeかどうかを判定する
+func IsSupportedReceiptType(mimeType string) bool {
+	return receiptMimeTypes[mimeType]
+}
+
+// ファイル名の拡張子をMIME typeに合わせる（例: "image0" + image/png -> "image0.png"）
+func FilenameForMimeType(filename, mimeType string) string {
+	ext, ok := mimeExtensions[mimeType]
+	if !ok || GetMimeType(filename) == mimeType {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
+
+// 添付ファイル名を安全なファイル名に整える
+// パス区切り・制御文字・引用符を取り除き、ディレクトリの外を指せないようにする
+func SanitizeFilename(name string) string {
+	// Windows形式の区切りも考慮して最後の要素だけを使う
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = filepath.Base(name)
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			continue
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	sanitized := strings.TrimLeft(strings.TrimSpace(b.String()), ".")
+	if runes := []rune(sanitized); len(runes) > 100 {
+		ext := filepath.Ext(sanitized)
+		if len([]rune(ext)) > 10 {
+			ext = ""
+		}
+		sanitized = string(runes[:100-len([]rune(ext))]) + ext
+	}
+	if sanitized == "" {
+		return "attachment"
+	}
+	return sanitized
+}